@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,6 +11,7 @@ import (
 	"runtime"
 	"strings"
 	"time"
+	"unicode/utf16"
 
 	"github.com/shirou/gopsutil/host"
 	"github.com/shirou/gopsutil/process"
@@ -21,6 +24,106 @@ const (
 	ActionRestart WebsiteAction = "Restart"
 )
 
+// runPowerShell runs a script through -EncodedCommand so that quotes and
+// newlines in embedded values survive the trip. Errors terminate the script
+// and the returned error carries whatever PowerShell wrote.
+func runPowerShell(ps string) ([]byte, error) {
+	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-EncodedCommand", encodePowerShell(ps))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, fmt.Errorf("%v\nOutput: %s", err, strings.TrimSpace(stderr.String()+string(out)))
+	}
+	return out, nil
+}
+
+// encodePowerShell encodes a script for -EncodedCommand, with errors set to
+// stop it.
+func encodePowerShell(ps string) string {
+	script := "$ErrorActionPreference = 'Stop'; $ProgressPreference = 'SilentlyContinue'; " + ps
+	encoded := utf16.Encode([]rune(script))
+	raw := make([]byte, len(encoded)*2)
+	for i, r := range encoded {
+		raw[i*2] = byte(r)
+		raw[i*2+1] = byte(r >> 8)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// psQuote renders s as a single-quoted PowerShell literal.
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// psJSON renders v as a PowerShell expression that evaluates to the same
+// object graph, for handing structured input to a script.
+func psJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(ConvertFrom-Json -InputObject %s)", psQuote(string(data))), nil
+}
+
+// sitePSPath is the provider path that scopes configuration cmdlets to a
+// site's own web.config.
+func sitePSPath(site string) string {
+	return psQuote(`IIS:\Sites\` + site)
+}
+
+// apphostScope declares $scope for splatting into configuration cmdlets.
+// Sections locked at server level, such as authentication and ipSecurity,
+// have to be written into applicationHost.config under a location tag. An
+// empty site targets the server level itself.
+func apphostScope(site string) string {
+	if site == "" {
+		return "$scope = @{ PSPath = 'MACHINE/WEBROOT/APPHOST' };"
+	}
+	return fmt.Sprintf("$scope = @{ PSPath = 'MACHINE/WEBROOT/APPHOST'; Location = %s };", psQuote(site))
+}
+
+// apphostSection is the ServerManager expression for a section of
+// applicationHost.config at the site's location, or for the server when
+// site is empty. It expects $config from GetApplicationHostConfiguration.
+func apphostSection(section string, site string) string {
+	if site == "" {
+		return fmt.Sprintf("$config.GetSection(%s)", psQuote(section))
+	}
+	return fmt.Sprintf("$config.GetSection(%s, %s)", psQuote(section), psQuote(site))
+}
+
+// mwaSiteCheck fails a ServerManager script early when the site does not
+// exist, rather than writing a location for it. It expects $manager.
+func mwaSiteCheck(site string) string {
+	if site == "" {
+		return ""
+	}
+	return fmt.Sprintf("if (-not $manager.Sites[%s]) { throw 'site not found' };", psQuote(site))
+}
+
+// decodePSList decodes ConvertTo-Json output into a slice. PowerShell emits
+// a bare object instead of an array when there is a single item, and nothing
+// at all when there are none.
+func decodePSList[T any](out []byte) ([]T, error) {
+	out = bytes.TrimSpace(out)
+	if len(out) == 0 {
+		return []T{}, nil
+	}
+	if out[0] != '[' {
+		var single T
+		if err := json.Unmarshal(out, &single); err != nil {
+			return nil, err
+		}
+		return []T{single}, nil
+	}
+	items := []T{}
+	if err := json.Unmarshal(out, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 func GetMachineStateAction() MachineState {
 	// Get host info using psutil
 	hostInfo, _ := host.Info()
@@ -134,12 +237,20 @@ func CreateWebsiteAction(name string, protocol string, hostOrDomain string, port
 	}
 	
 	path := path.Join("C:", "inetpub", "wwwroot", name)
+	return newWebsite(name, port, "", path)
+}
 
-	ps := fmt.Sprintf(`Import-Module WebAdministration; if (-Not (Test-Path "%s")) { New-Item -Path "%s" -ItemType Directory | Out-Null }; New-Website -Name "%s" -Port %d -PhysicalPath "%s" -ApplicationPool "DefaultAppPool"`, path, path, name, port, path)
-	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", ps)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to create website %s: %v\nOutput: %s", name, err, string(out))
+// newWebsite creates a site in DefaultAppPool under its exact name, making
+// the physical path if it is missing. It is shared by the dashboard and
+// manifest applies, which set any further bindings afterwards.
+func newWebsite(name string, port int, host string, physicalPath string) error {
+	ps := fmt.Sprintf(`Import-Module WebAdministration;
+		$path = [Environment]::ExpandEnvironmentVariables(%s);
+		if (-Not (Test-Path $path)) { New-Item -Path $path -ItemType Directory | Out-Null };
+		New-Website -Name %s -Port %d -HostHeader %s -PhysicalPath $path -ApplicationPool 'DefaultAppPool' | Out-Null`,
+		psQuote(physicalPath), psQuote(name), port, psQuote(host))
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to create website %s: %v", name, err)
 	}
 	return nil
 }
@@ -170,13 +281,16 @@ func UpdateWebsiteAction(original string, name string, protocol string, hostOrDo
 		}
 		
 		// Create the new website with the new name, protocol, and port, retaining physical path
-		ps = fmt.Sprintf(`Import-Module WebAdministration; New-Website -Name "%s" -Port %d -PhysicalPath "%s" -ApplicationPool "DefaultAppPool"`, name, port, physicalPath)
-		cmd = exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", ps)
-		out, err = cmd.CombinedOutput()
+		if err := newWebsite(name, port, "", physicalPath); err != nil {
+			return err
+		}
+
+		// The recreated site has a new ID, so move the records kept for it
+		created, err := GetByNameAction(name)
 		if err != nil {
-			return fmt.Errorf("failed to create new website %s: %v\nOutput: %s", name, err, string(out))
+			return fmt.Errorf("failed to find recreated website %s: %v", name, err)
 		}
-		return nil
+		return moveSiteRecords(website.ID, created.ID, created.Name)
 	}
 	
 	// Only host changed - update binding
@@ -197,6 +311,11 @@ func UpdateWebsiteAction(original string, name string, protocol string, hostOrDo
 }
 
 func DeleteWebsiteAction(name string) error {
+	website, err := GetByNameAction(name)
+	if err != nil {
+		return fmt.Errorf("failed to get website %s: %v", name, err)
+	}
+
 	// Delete the website and its physical path
 	ps := fmt.Sprintf(`Import-Module WebAdministration; 
 		$site = Get-Website -Name "%s";
@@ -208,7 +327,21 @@ func DeleteWebsiteAction(name string) error {
 	if err != nil {
 		return fmt.Errorf("failed to delete website %s: %v\nOutput: %s", name, err, string(out))
 	}
-	return nil
+	return forgetSiteRecords(website.ID)
+}
+
+// RemoveWebsiteAction removes a site from IIS but, unlike
+// DeleteWebsiteAction, leaves its content on disk.
+func RemoveWebsiteAction(name string) error {
+	website, err := GetByNameAction(name)
+	if err != nil {
+		return fmt.Errorf("failed to get website %s: %v", name, err)
+	}
+	ps := fmt.Sprintf(`Import-Module WebAdministration; Remove-Website -Name %s`, psQuote(name))
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to remove website %s: %v", name, err)
+	}
+	return forgetSiteRecords(website.ID)
 }
 
 func GetLogsAction(site string) (string, error) {
@@ -228,81 +361,3 @@ func GetLogsAction(site string) (string, error) {
 
 	return string(out), nil
 }
-
-func GetDirectoryContentAction(name string) []DirFile {
-	website, err := GetByNameAction(name)
-	if err != nil {
-		return []DirFile{}
-	}
-
-	// PowerShell command to get directory contents
-	ps := fmt.Sprintf(`Get-ChildItem -Path "%s" | ForEach-Object {
-		$item = $_
-		$modTime = $item.LastWriteTime.ToString("yyyy-MM-dd HH:mm:ss")
-		$permission = (Get-Acl $item.FullName).Access | Select-Object -First 1 | ForEach-Object { $_.FileSystemRights.ToString() }
-		[PSCustomObject]@{
-			Name = $item.Name
-			Size = if ($item.PSIsContainer) { 0 } else { $item.Length }
-			IsDir = $item.PSIsContainer
-			ModTime = $modTime
-			Permission = if ($permission) { $permission } else { "Unknown" }
-		}
-	} | ConvertTo-Json -Depth 2`, website.PhysicalPath)
-
-	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", ps)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return []DirFile{}
-	}
-
-	var files []DirFile
-	if err := json.Unmarshal(out, &files); err != nil {
-		// Try parsing as single object in case there's only one item
-		var singleFile DirFile
-		if err := json.Unmarshal(out, &singleFile); err == nil {
-			return []DirFile{singleFile}
-		}
-		return []DirFile{}
-	}
-
-	return files
-}
-
-func GetDirectoryTreeAction(name string, dirTree string) []DirFile {
-	website, err := GetByNameAction(name)
-	if err != nil {
-		return []DirFile{}
-	}
-
-	// PowerShell command to get directory contents
-	ps := fmt.Sprintf(`Get-ChildItem -Path "%s" | ForEach-Object {
-		$item = $_
-		$modTime = $item.LastWriteTime.ToString("yyyy-MM-dd HH:mm:ss")
-		$permission = (Get-Acl $item.FullName).Access | Select-Object -First 1 | ForEach-Object { $_.FileSystemRights.ToString() }
-		[PSCustomObject]@{
-			Name = $item.Name
-			Size = if ($item.PSIsContainer) { 0 } else { $item.Length }
-			IsDir = $item.PSIsContainer
-			ModTime = $modTime
-			Permission = if ($permission) { $permission } else { "Unknown" }
-		}
-	} | ConvertTo-Json -Depth 2`, path.Join(website.PhysicalPath, dirTree))
-
-	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", ps)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return []DirFile{}
-	}
-
-	var files []DirFile
-	if err := json.Unmarshal(out, &files); err != nil {
-		// Try parsing as single object in case there's only one item
-		var singleFile DirFile
-		if err := json.Unmarshal(out, &singleFile); err == nil {
-			return []DirFile{singleFile}
-		}
-		return []DirFile{}
-	}
-
-	return files
-}
\ No newline at end of file
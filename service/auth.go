@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const authenticationFilter = "system.webServer/security/authentication"
+
+var windowsAuthProviders = []string{"Negotiate", "NTLM", "Negotiate:Kerberos", "Negotiate:PKU2U"}
+
+// authLocation joins a site and an optional path into a location tag value,
+// e.g. "MySite/admin".
+func authLocation(site string, path string) (string, error) {
+	path = strings.Trim(strings.ReplaceAll(path, `\`, "/"), "/")
+	if path == "" {
+		return site, nil
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid path %s", path)
+		}
+	}
+	return site + "/" + path, nil
+}
+
+func GetAuthenticationAction(site string, path string) (AuthenticationSettings, error) {
+	location, err := authLocation(site, path)
+	if err != nil {
+		return AuthenticationSettings{}, err
+	}
+	ps := fmt.Sprintf(`Import-Module WebAdministration; %s
+		$base = '%s';
+		$anon = Get-WebConfiguration @scope -Filter "$base/anonymousAuthentication";
+		$basic = Get-WebConfiguration @scope -Filter "$base/basicAuthentication" -ErrorAction SilentlyContinue;
+		$win = Get-WebConfiguration @scope -Filter "$base/windowsAuthentication" -ErrorAction SilentlyContinue;
+		$digest = Get-WebConfiguration @scope -Filter "$base/digestAuthentication" -ErrorAction SilentlyContinue;
+		[PSCustomObject]@{
+			anonymous = [PSCustomObject]@{ enabled = [bool]$anon.enabled; userName = [string]$anon.userName }
+			basic = [PSCustomObject]@{ enabled = [bool]$basic.enabled; defaultLogonDomain = [string]$basic.defaultLogonDomain; realm = [string]$basic.realm }
+			windows = [PSCustomObject]@{ enabled = [bool]$win.enabled; providers = @($win.providers.Collection | ForEach-Object { [string]$_.value }); useKernelMode = [bool]$win.useKernelMode }
+			digest = [PSCustomObject]@{ enabled = [bool]$digest.enabled; realm = [string]$digest.realm }
+		} | ConvertTo-Json -Depth 4`, apphostScope(location), authenticationFilter)
+	out, err := runPowerShell(ps)
+	if err != nil {
+		return AuthenticationSettings{}, fmt.Errorf("failed to read authentication for %s: %v", location, err)
+	}
+	raw := struct {
+		Anonymous struct {
+			Enabled  bool   `json:"enabled"`
+			UserName string `json:"userName"`
+		} `json:"anonymous"`
+		Basic   BasicAuthentication   `json:"basic"`
+		Windows WindowsAuthentication `json:"windows"`
+		Digest  DigestAuthentication  `json:"digest"`
+	}{}
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return AuthenticationSettings{}, fmt.Errorf("failed to parse authentication for %s: %v", location, err)
+	}
+	settings := AuthenticationSettings{
+		Path:    "/" + strings.TrimPrefix(strings.TrimPrefix(location, site), "/"),
+		Basic:   raw.Basic,
+		Windows: raw.Windows,
+		Digest:  raw.Digest,
+	}
+	settings.Anonymous.Enabled = raw.Anonymous.Enabled
+	switch raw.Anonymous.UserName {
+	case "":
+		settings.Anonymous.Identity = "ApplicationPool"
+	case "IUSR":
+		settings.Anonymous.Identity = "IUSR"
+	default:
+		settings.Anonymous.Identity = "User"
+		settings.Anonymous.UserName = raw.Anonymous.UserName
+	}
+	if settings.Windows.Providers == nil {
+		settings.Windows.Providers = []string{}
+	}
+	return settings, nil
+}
+
+func SetAuthenticationAction(site string, settings AuthenticationSettings) error {
+	if err := ValidateAuthentication(settings); err != nil {
+		return err
+	}
+	location, err := authLocation(site, settings.Path)
+	if err != nil {
+		return err
+	}
+	userName := settings.Anonymous.UserName
+	switch settings.Anonymous.Identity {
+	case "ApplicationPool":
+		userName = ""
+	case "IUSR", "":
+		userName = "IUSR"
+	}
+	providers, err := psJSON(settings.Windows.Providers)
+	if err != nil {
+		return err
+	}
+	ps := fmt.Sprintf(`Import-Module WebAdministration; %s
+		$base = '%s';
+		Set-WebConfigurationProperty @scope -Filter "$base/anonymousAuthentication" -Name enabled -Value $%t;
+		Set-WebConfigurationProperty @scope -Filter "$base/anonymousAuthentication" -Name userName -Value %s;
+		if (%s) { Set-WebConfigurationProperty @scope -Filter "$base/anonymousAuthentication" -Name password -Value %s };
+		Set-WebConfigurationProperty @scope -Filter "$base/basicAuthentication" -Name enabled -Value $%t;
+		Set-WebConfigurationProperty @scope -Filter "$base/basicAuthentication" -Name defaultLogonDomain -Value %s;
+		Set-WebConfigurationProperty @scope -Filter "$base/basicAuthentication" -Name realm -Value %s;
+		Set-WebConfigurationProperty @scope -Filter "$base/windowsAuthentication" -Name enabled -Value $%t;
+		Set-WebConfigurationProperty @scope -Filter "$base/windowsAuthentication" -Name useKernelMode -Value $%t;
+		if ($%t) {
+			Clear-WebConfiguration @scope -Filter "$base/windowsAuthentication/providers";
+			foreach ($p in %s) { Add-WebConfigurationProperty @scope -Filter "$base/windowsAuthentication/providers" -Name '.' -Value @{ value = $p } }
+		};
+		if ($%t -or (Get-WebConfiguration @scope -Filter "$base/digestAuthentication" -ErrorAction SilentlyContinue)) {
+			Set-WebConfigurationProperty @scope -Filter "$base/digestAuthentication" -Name enabled -Value $%t;
+			Set-WebConfigurationProperty @scope -Filter "$base/digestAuthentication" -Name realm -Value %s;
+		}`,
+		apphostScope(location), authenticationFilter,
+		settings.Anonymous.Enabled, psQuote(userName),
+		psQuote(settings.Anonymous.Password), psQuote(settings.Anonymous.Password),
+		settings.Basic.Enabled, psQuote(settings.Basic.DefaultLogonDomain), psQuote(settings.Basic.Realm),
+		settings.Windows.Enabled, settings.Windows.UseKernelMode,
+		settings.Windows.Enabled, providers,
+		settings.Digest.Enabled, settings.Digest.Enabled, psQuote(settings.Digest.Realm))
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to write authentication for %s: %v", location, err)
+	}
+	return nil
+}
+
+// ValidateAuthentication rejects combinations IIS would accept but that
+// leave the path unusable or misconfigured.
+func ValidateAuthentication(settings AuthenticationSettings) error {
+	if !settings.Anonymous.Enabled && !settings.Basic.Enabled && !settings.Windows.Enabled && !settings.Digest.Enabled {
+		return fmt.Errorf("at least one authentication method must be enabled")
+	}
+	switch settings.Anonymous.Identity {
+	case "", "IUSR", "ApplicationPool":
+	case "User":
+		if settings.Anonymous.UserName == "" {
+			return fmt.Errorf("anonymous identity User requires a userName")
+		}
+	default:
+		return fmt.Errorf("anonymous identity must be IUSR, ApplicationPool or User")
+	}
+	if settings.Windows.Enabled {
+		if len(settings.Windows.Providers) == 0 {
+			return fmt.Errorf("windows authentication requires at least one provider")
+		}
+		for _, provider := range settings.Windows.Providers {
+			if !slices.Contains(windowsAuthProviders, provider) {
+				return fmt.Errorf("unknown windows provider %s, valid providers are: %s", provider, strings.Join(windowsAuthProviders, ", "))
+			}
+		}
+		if slices.Contains(settings.Windows.Providers, "Negotiate:Kerberos") && slices.Contains(settings.Windows.Providers, "Negotiate") {
+			return fmt.Errorf("Negotiate and Negotiate:Kerberos cannot both be listed")
+		}
+	}
+	if settings.Digest.Enabled && settings.Basic.Enabled && settings.Basic.Realm != "" && settings.Digest.Realm != "" && settings.Basic.Realm != settings.Digest.Realm {
+		return fmt.Errorf("basic and digest authentication must share a realm when both are enabled")
+	}
+	return nil
+}
+
+// AuthenticationWarnings points out settings that are valid but rarely
+// intended, such as challenge methods that never fire because anonymous
+// access is also on.
+func AuthenticationWarnings(settings AuthenticationSettings, website Website) []string {
+	warnings := []string{}
+	challenge := settings.Basic.Enabled || settings.Windows.Enabled || settings.Digest.Enabled
+	if settings.Anonymous.Enabled && challenge {
+		warnings = append(warnings, "anonymous authentication is enabled, so other methods only apply where anonymous users are denied by authorization rules")
+	}
+	if settings.Basic.Enabled && !website.Binding.SSL && website.Binding.Protocol != "https" {
+		warnings = append(warnings, "basic authentication sends credentials in clear text over a non-https binding")
+	}
+	return warnings
+}
+
+// AuthenticationSummaryAction lists the enabled methods at site level for
+// the website detail response.
+func AuthenticationSummaryAction(site string) (*AuthenticationSummary, error) {
+	settings, err := GetAuthenticationAction(site, "")
+	if err != nil {
+		return nil, err
+	}
+	summary := AuthenticationSummary{Methods: []string{}, AnonymousIdentity: settings.Anonymous.Identity}
+	if settings.Anonymous.Enabled {
+		summary.Methods = append(summary.Methods, "Anonymous")
+	}
+	if settings.Basic.Enabled {
+		summary.Methods = append(summary.Methods, "Basic")
+	}
+	if settings.Windows.Enabled {
+		summary.Methods = append(summary.Methods, "Windows")
+	}
+	if settings.Digest.Enabled {
+		summary.Methods = append(summary.Methods, "Digest")
+	}
+	return &summary, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	bulkDefaultParallelism = 4
+	bulkMaxParallelism     = 16
+)
+
+var bulkActions = []string{"start", "stop", "restart", "delete", "changeAppPool"}
+
+var errBulkRequest = errors.New("invalid bulk request")
+
+func ChangeAppPoolAction(site string, pool string) error {
+	ps := fmt.Sprintf(`Import-Module WebAdministration;
+		$pool = %s;
+		if (-Not (Test-Path ('IIS:\AppPools\' + $pool))) { throw "application pool $pool does not exist" };
+		Set-ItemProperty -Path %s -Name applicationPool -Value $pool`,
+		psQuote(pool), psQuote(`IIS:\Sites\`+site))
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to change application pool of %s: %v", site, err)
+	}
+	return nil
+}
+
+func ValidateBulkRequest(request BulkRequest) error {
+	if len(request.Sites) == 0 && request.Selector == "" {
+		return fmt.Errorf("either sites or selector is required")
+	}
+	if len(request.Sites) > 0 && request.Selector != "" {
+		return fmt.Errorf("sites and selector cannot be combined")
+	}
+	if bulkAction(request.Action) == "" {
+		return fmt.Errorf("invalid action, valid actions are: %s", strings.Join(bulkActions, ", "))
+	}
+	if bulkAction(request.Action) == "changeAppPool" && request.AppPool == "" {
+		return fmt.Errorf("appPool is required for changeAppPool")
+	}
+	if request.Parallelism < 0 || request.Parallelism > bulkMaxParallelism {
+		return fmt.Errorf("parallelism must be between 1 and %d", bulkMaxParallelism)
+	}
+	return nil
+}
+
+// bulkAction matches an action name case-insensitively and returns its
+// canonical spelling, or "" when unknown.
+func bulkAction(action string) string {
+	for _, known := range bulkActions {
+		if strings.EqualFold(known, action) {
+			return known
+		}
+	}
+	return ""
+}
+
+// resolveBulkSites turns the request into the list of target site names.
+func resolveBulkSites(request BulkRequest, websites []Website) ([]string, error) {
+	if request.Selector != "" {
+		websites, err := attachMetadata(websites)
+		if err != nil {
+			return nil, err
+		}
+		selected, err := SelectWebsites(websites, request.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errBulkRequest, err)
+		}
+		// Labels only exist once set through the metadata API; say so
+		// rather than report an empty success
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("%w: selector %s matched no sites; labels are set with PUT /api/website/:name/metadata", errBulkRequest, request.Selector)
+		}
+		sites := []string{}
+		for _, website := range selected {
+			sites = append(sites, website.Name)
+		}
+		return sites, nil
+	}
+	seen := map[string]bool{}
+	sites := []string{}
+	for _, site := range request.Sites {
+		if !seen[site] {
+			seen[site] = true
+			sites = append(sites, site)
+		}
+	}
+	return sites, nil
+}
+
+// BulkAction runs one action over many sites with at most Parallelism
+// running at once. Every site gets a result; a failure never stops the rest.
+func BulkAction(request BulkRequest) (BulkResponse, error) {
+	if err := ValidateBulkRequest(request); err != nil {
+		return BulkResponse{}, fmt.Errorf("%w: %v", errBulkRequest, err)
+	}
+	// A failed listing must not pass for an IIS without the named sites
+	websites, err := ListWebsitesAction()
+	if err != nil {
+		return BulkResponse{}, err
+	}
+	sites, err := resolveBulkSites(request, websites)
+	if err != nil {
+		return BulkResponse{}, err
+	}
+	existing := map[string]bool{}
+	for _, website := range websites {
+		existing[website.Name] = true
+	}
+	parallelism := request.Parallelism
+	if parallelism == 0 {
+		parallelism = bulkDefaultParallelism
+	}
+	action := bulkAction(request.Action)
+
+	results := make([]SiteResult, len(sites))
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, site := range sites {
+		results[i].Site = site
+		if !existing[site] {
+			results[i].Error = "Website not found"
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(result *SiteResult) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := runBulkItem(action, result.Site, request.AppPool); err != nil {
+				result.Error = err.Error()
+				return
+			}
+			result.Success = true
+		}(&results[i])
+	}
+	wg.Wait()
+
+	response := BulkResponse{Action: action, Results: results}
+	for _, result := range results {
+		if result.Success {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+	return response, nil
+}
+
+func runBulkItem(action string, site string, appPool string) error {
+	switch action {
+	case "start":
+		return ControlWebsiteAction(ActionStart, site)
+	case "stop":
+		return ControlWebsiteAction(ActionStop, site)
+	case "restart":
+		return ControlWebsiteAction(ActionRestart, site)
+	case "delete":
+		return DeleteWebsiteAction(site)
+	case "changeAppPool":
+		return ChangeAppPoolAction(site, appPool)
+	}
+	return fmt.Errorf("unsupported action: %s", action)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A bundle is a zip archive laid out as
+//
+//	bundle.json                   BundleInfo, including the manifest
+//	sites/<site>/root/...         the site's physical directory
+//	sites/<site>/vdir/<path>/...  each virtual directory
+//
+// Without content only the web.config of each directory is included.
+const (
+	bundleVersion     = 1
+	bundleInfoFile    = "bundle.json"
+	conflictFail      = "fail"
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+)
+
+var (
+	errImportConflict = errors.New("sites or app pools in the bundle already exist")
+	errInvalidBundle  = errors.New("invalid bundle")
+)
+
+// bundleLocation is one directory of a site inside the archive.
+type bundleLocation struct {
+	prefix       string
+	physicalPath string
+}
+
+func bundleLocations(site ManifestSite) []bundleLocation {
+	locations := []bundleLocation{{prefix: "sites/" + site.Name + "/root/", physicalPath: site.PhysicalPath}}
+	for _, vdir := range site.VirtualDirectories {
+		locations = append(locations, bundleLocation{
+			prefix:       "sites/" + site.Name + "/vdir/" + strings.Trim(normalizeVirtualPath(vdir.Path), "/") + "/",
+			physicalPath: vdir.PhysicalPath,
+		})
+	}
+	// Nested virtual directories share a prefix; the longest must match first
+	sort.SliceStable(locations, func(i, j int) bool { return len(locations[i].prefix) > len(locations[j].prefix) })
+	return locations
+}
+
+// ExportBundleAction writes a bundle of the live server, or of the chosen
+// sites and the app pools they use, to w.
+func ExportBundleAction(w io.Writer, options ExportOptions) (BundleInfo, error) {
+	live, err := readLiveManifest(func(string) bool { return true })
+	if err != nil {
+		return BundleInfo{}, err
+	}
+	if len(options.Sites) > 0 {
+		live = selectManifestSites(live, options.Sites)
+	}
+	source, _ := os.Hostname()
+	info := BundleInfo{Version: bundleVersion, CreatedAt: time.Now().UTC(), Source: source, Content: options.Content, Manifest: live}
+
+	archive := zip.NewWriter(w)
+	entry, err := archive.Create(bundleInfoFile)
+	if err != nil {
+		return info, err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(info); err != nil {
+		return info, err
+	}
+	for _, site := range live.Sites {
+		for _, location := range bundleLocations(site) {
+			if err := addBundleDirectory(archive, location, options.Content); err != nil {
+				return info, fmt.Errorf("failed to export %s: %v", site.Name, err)
+			}
+		}
+	}
+	return info, archive.Close()
+}
+
+func selectManifestSites(manifest Manifest, names []string) Manifest {
+	selected := Manifest{AppPools: []ManifestAppPool{}, Sites: []ManifestSite{}}
+	pools := map[string]bool{}
+	for _, site := range manifest.Sites {
+		if slices.ContainsFunc(names, func(name string) bool { return strings.EqualFold(name, site.Name) }) {
+			selected.Sites = append(selected.Sites, site)
+			pools[strings.ToLower(site.AppPool)] = true
+		}
+	}
+	for _, pool := range manifest.AppPools {
+		if pools[strings.ToLower(pool.Name)] {
+			selected.AppPools = append(selected.AppPools, pool)
+		}
+	}
+	return selected
+}
+
+func addBundleDirectory(archive *zip.Writer, location bundleLocation, content bool) error {
+	root := expandPhysicalPath(location.physicalPath)
+	if !content {
+		err := addBundleFile(archive, filepath.Join(root, "web.config"), location.prefix+"web.config")
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Directories are implied by their files; links are not followed
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		return addBundleFile(archive, path, location.prefix+filepath.ToSlash(rel))
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func addBundleFile(archive *zip.Writer, path string, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(stat)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	entry, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, file)
+	return err
+}
+
+func ReadBundleInfo(archive *zip.Reader) (BundleInfo, error) {
+	info := BundleInfo{}
+	file, err := archive.Open(bundleInfoFile)
+	if err != nil {
+		return info, fmt.Errorf("%w: %s is missing", errInvalidBundle, bundleInfoFile)
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&info); err != nil {
+		return info, fmt.Errorf("%w: %s: %v", errInvalidBundle, bundleInfoFile, err)
+	}
+	if info.Version < 1 || info.Version > bundleVersion {
+		return info, fmt.Errorf("%w: unsupported version %d", errInvalidBundle, info.Version)
+	}
+	return info, nil
+}
+
+func ValidateImportOptions(options ImportOptions) error {
+	if options.Conflict != "" && !slices.Contains([]string{conflictFail, conflictSkip, conflictOverwrite}, options.Conflict) {
+		return fmt.Errorf("conflict must be fail, skip or overwrite")
+	}
+	for from, to := range options.Paths {
+		if from == "" || to == "" {
+			return fmt.Errorf("path mappings cannot be empty")
+		}
+	}
+	for from, to := range options.Names {
+		if from == "" || to == "" {
+			return fmt.Errorf("name mappings cannot be empty")
+		}
+	}
+	return nil
+}
+
+// remapName looks a site or app pool name up in the mapping table,
+// ignoring case as IIS does.
+func remapName(name string, names map[string]string) string {
+	for from, to := range names {
+		if strings.EqualFold(from, name) {
+			return to
+		}
+	}
+	return name
+}
+
+// remapPath replaces the longest matching directory prefix. Prefixes only
+// match whole path segments, so C:\web does not match C:\website.
+func remapPath(path string, paths map[string]string) string {
+	normalized := strings.ReplaceAll(path, "/", `\`)
+	best, bestPrefix := "", ""
+	for from := range paths {
+		prefix := strings.TrimRight(strings.ReplaceAll(from, "/", `\`), `\`)
+		if len(prefix) <= len(bestPrefix) || len(normalized) < len(prefix) || !strings.EqualFold(normalized[:len(prefix)], prefix) {
+			continue
+		}
+		if len(normalized) == len(prefix) || normalized[len(prefix)] == '\\' {
+			best, bestPrefix = from, prefix
+		}
+	}
+	if best == "" {
+		return path
+	}
+	return strings.TrimRight(paths[best], `\/`) + normalized[len(bestPrefix):]
+}
+
+// RemapManifest applies the import's name and path tables. It returns the
+// remapped manifest and, for each new site name, the name in the bundle.
+func RemapManifest(manifest Manifest, options ImportOptions) (Manifest, map[string]string) {
+	remapped := Manifest{AppPools: []ManifestAppPool{}, Sites: []ManifestSite{}}
+	sources := map[string]string{}
+	for _, pool := range manifest.AppPools {
+		pool.Name = remapName(pool.Name, options.Names)
+		remapped.AppPools = append(remapped.AppPools, pool)
+	}
+	for _, site := range manifest.Sites {
+		if len(options.Sites) > 0 && !slices.ContainsFunc(options.Sites, func(name string) bool { return strings.EqualFold(name, site.Name) }) {
+			continue
+		}
+		source := site.Name
+		site.Name = remapName(site.Name, options.Names)
+		site.AppPool = remapName(site.AppPool, options.Names)
+		site.PhysicalPath = remapPath(site.PhysicalPath, options.Paths)
+		vdirs := []ManifestVirtualDirectory{}
+		for _, vdir := range site.VirtualDirectories {
+			vdir.PhysicalPath = remapPath(vdir.PhysicalPath, options.Paths)
+			vdirs = append(vdirs, vdir)
+		}
+		site.VirtualDirectories = vdirs
+		sources[strings.ToLower(site.Name)] = source
+		remapped.Sites = append(remapped.Sites, site)
+	}
+	if len(options.Sites) > 0 {
+		remapped = selectManifestSites(remapped, siteNames(remapped))
+	}
+	return remapped, sources
+}
+
+func siteNames(manifest Manifest) []string {
+	names := []string{}
+	for _, site := range manifest.Sites {
+		names = append(names, site.Name)
+	}
+	return names
+}
+
+// bundleConflicts lists the sites and app pools that already exist live.
+func bundleConflicts(manifest Manifest, live Manifest) []string {
+	conflicts := []string{}
+	for _, pool := range manifest.AppPools {
+		if slices.ContainsFunc(live.AppPools, func(p ManifestAppPool) bool { return strings.EqualFold(p.Name, pool.Name) }) {
+			conflicts = append(conflicts, "appPool "+pool.Name)
+		}
+	}
+	for _, site := range manifest.Sites {
+		if slices.ContainsFunc(live.Sites, func(s ManifestSite) bool { return strings.EqualFold(s.Name, site.Name) }) {
+			conflicts = append(conflicts, "site "+site.Name)
+		}
+	}
+	return conflicts
+}
+
+// withoutConflicts drops what already exists live, for the skip policy.
+func withoutConflicts(manifest Manifest, live Manifest) Manifest {
+	kept := Manifest{AppPools: []ManifestAppPool{}, Sites: []ManifestSite{}}
+	for _, pool := range manifest.AppPools {
+		if !slices.ContainsFunc(live.AppPools, func(p ManifestAppPool) bool { return strings.EqualFold(p.Name, pool.Name) }) {
+			kept.AppPools = append(kept.AppPools, pool)
+		}
+	}
+	for _, site := range manifest.Sites {
+		if !slices.ContainsFunc(live.Sites, func(s ManifestSite) bool { return strings.EqualFold(s.Name, site.Name) }) {
+			kept.Sites = append(kept.Sites, site)
+		}
+	}
+	return kept
+}
+
+// ImportBundleAction recreates a bundle on this server. Files are written
+// first so that sites start with their web.config in place, then the
+// manifest is applied like POST /api/apply without pruning.
+func ImportBundleAction(archive *zip.Reader, options ImportOptions) (ImportResult, error) {
+	if options.Conflict == "" {
+		options.Conflict = conflictFail
+	}
+	info, err := ReadBundleInfo(archive)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	manifest, sources := RemapManifest(info.Manifest, options)
+	live, err := LiveManifestAction(manifest)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	result := ImportResult{Version: info.Version, Source: info.Source, CreatedAt: info.CreatedAt, DryRun: options.DryRun, Skipped: []string{}}
+	conflicts := bundleConflicts(manifest, live)
+	switch {
+	case len(conflicts) > 0 && options.Conflict == conflictFail:
+		result.Skipped = conflicts
+		return result, errImportConflict
+	case options.Conflict == conflictSkip:
+		result.Skipped = conflicts
+		manifest = withoutConflicts(manifest, live)
+	}
+	// Validated only now, so that sites left out by the filter or the skip
+	// policy cannot fail the import
+	if err := ValidateManifest(manifest); err != nil {
+		return result, fmt.Errorf("%w: %v", errInvalidBundle, err)
+	}
+	result.Plan = PlanManifest(manifest, live, false)
+	if options.DryRun {
+		return result, nil
+	}
+	settings, err := GetDeploySettingsAction()
+	if err != nil {
+		return result, err
+	}
+	// The deployment limits apply to the bundle as a whole
+	budget := &extractBudget{maxFiles: settings.MaxFiles, maxBytes: int64(settings.MaxExtractedMB) << 20}
+	for _, site := range manifest.Sites {
+		source := sources[strings.ToLower(site.Name)]
+		written, err := extractBundleSite(archive, source, site, budget)
+		result.Files += written
+		if err != nil {
+			return result, err
+		}
+	}
+	result.Plan = ApplyPlanAction(result.Plan)
+	return result, nil
+}
+
+// extractBundleSite writes the files kept under a site's name in the
+// bundle into its (possibly remapped) directories. Entry names go through
+// siteRelativeFile, so nothing can be written outside those directories,
+// and what is written counts against budget.
+func extractBundleSite(archive *zip.Reader, source string, site ManifestSite, budget *extractBudget) (int, error) {
+	original := site
+	original.Name = source
+	sourceLocations := bundleLocations(original)
+	targetLocations := bundleLocations(site)
+	written := 0
+	for _, file := range archive.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		for i, location := range sourceLocations {
+			if !strings.HasPrefix(file.Name, location.prefix) {
+				continue
+			}
+			target, err := siteRelativeFile(targetLocations[i].physicalPath, strings.TrimPrefix(file.Name, location.prefix))
+			if err != nil {
+				return written, fmt.Errorf("%w: %v", errInvalidBundle, err)
+			}
+			if !file.Mode().IsRegular() {
+				return written, fmt.Errorf("%w: %s is not a regular file", errInvalidBundle, file.Name)
+			}
+			if err := extractBundleFile(file, target, budget); err != nil {
+				return written, err
+			}
+			written++
+			break
+		}
+	}
+	return written, nil
+}
+
+func extractBundleFile(file *zip.File, target string, budget *extractBudget) error {
+	reader, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", errInvalidBundle, file.Name, err)
+	}
+	defer reader.Close()
+	return budget.writeFile(target, file.Name, reader)
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	deploymentBucket   = "deployments"
+	deployTargetBucket = "deploy-targets"
+	deployZip          = "zip"
+	deployTarGz        = "tar.gz"
+	deployDone         = "done"
+	deployFailed       = "failed"
+	deployedStatus     = "deployed"
+	prunedStatus       = "pruned"
+	deployKind         = "deploy"
+	rollbackKind       = "rollback"
+	stagingSuffix      = ".staging"
+	// multipartSlack is room for multipart headers around an upload
+	multipartSlack = 1 << 20
+)
+
+var (
+	defaultDeploySettings = DeploySettings{MaxUploadMB: 512, MaxExtractedMB: 2048, MaxFiles: 50000, KeepReleases: 5}
+
+	errInvalidArchive      = errors.New("invalid archive")
+	errDeployTooLarge      = errors.New("deployment exceeds the size limits")
+	errDeployInMaintenance = errors.New("website is in maintenance; end maintenance before deploying")
+	errReleaseNotFound     = errors.New("release not found")
+	errReleaseActive       = errors.New("release is active")
+
+	// deployMu keeps deployments one at a time, so two uploads cannot race
+	// for the same site's physical path
+	deployMu sync.Mutex
+)
+
+// deployTarget is where a site's releases live. The first deployment
+// records the site's original path and puts releases next to it.
+type deployTarget struct {
+	SiteID       int    `json:"siteId"`
+	Site         string `json:"site"`
+	OriginalPath string `json:"originalPath"`
+	ReleasesDir  string `json:"releasesDir"`
+}
+
+// archiveFile is an uploaded archive; multipart files satisfy it.
+type archiveFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+func GetDeploySettingsAction() (DeploySettings, error) {
+	settings := defaultDeploySettings
+	_, err := storeGet(settingsBucket, "deploy", &settings)
+	return settings, err
+}
+
+func SetDeploySettingsAction(settings DeploySettings) error {
+	if err := ValidateDeploySettings(settings); err != nil {
+		return err
+	}
+	return storePut(settingsBucket, "deploy", settings)
+}
+
+func ValidateDeploySettings(settings DeploySettings) error {
+	if settings.MaxUploadMB < 1 || settings.MaxExtractedMB < 1 || settings.MaxFiles < 1 {
+		return fmt.Errorf("maxUploadMB, maxExtractedMB and maxFiles must be at least 1")
+	}
+	if settings.KeepReleases < 1 {
+		return fmt.Errorf("keepReleases must be at least 1")
+	}
+	return nil
+}
+
+// detectArchiveFormat tells zip from tar.gz by their leading bytes.
+func detectArchiveFormat(header []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return deployZip, nil
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return deployTarGz, nil
+	}
+	return "", fmt.Errorf("%w: only zip and tar.gz archives are supported", errInvalidArchive)
+}
+
+// extractBudget enforces the size limits on what is actually written, not
+// on what entry headers claim.
+type extractBudget struct {
+	dest     string
+	files    int
+	bytes    int64
+	maxFiles int
+	maxBytes int64
+}
+
+func (b *extractBudget) mkdir(name string) error {
+	dir, err := b.target(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(dir, 0o755)
+}
+
+func (b *extractBudget) target(name string) (string, error) {
+	// A colon would name an NTFS alternate data stream or a drive
+	if strings.Contains(name, ":") {
+		return "", fmt.Errorf("%w: entry %s has an invalid name", errInvalidArchive, name)
+	}
+	clean, err := cleanSitePath(name)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+	// Device names such as CON, NUL or aux.txt are not local on Windows,
+	// in any directory
+	if !filepath.IsLocal(clean) {
+		return "", fmt.Errorf("%w: entry %s has an invalid name", errInvalidArchive, name)
+	}
+	return filepath.Join(expandPhysicalPath(b.dest), clean), nil
+}
+
+func (b *extractBudget) write(name string, r io.Reader) error {
+	target, err := b.target(name)
+	if err != nil {
+		return err
+	}
+	return b.writeFile(target, name, r)
+}
+
+// writeFile writes one entry to a target the caller has already checked,
+// counting it against the budget.
+func (b *extractBudget) writeFile(target string, name string, r io.Reader) error {
+	if b.files++; b.files > b.maxFiles {
+		return fmt.Errorf("%w: more than %d files", errDeployTooLarge, b.maxFiles)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, io.LimitReader(r, b.maxBytes-b.bytes+1))
+	b.bytes += n
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %v", name, err)
+	}
+	if b.bytes > b.maxBytes {
+		return fmt.Errorf("%w: more than %d MB extracted", errDeployTooLarge, b.maxBytes>>20)
+	}
+	return nil
+}
+
+// extractArchive unpacks a zip or tar.gz into the budget's dest. Only
+// regular files and directories are accepted; links and devices fail the
+// extraction.
+func extractArchive(file archiveFile, size int64, format string, budget *extractBudget) error {
+	if format == deployZip {
+		reader, err := zip.NewReader(file, size)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalidArchive, err)
+		}
+		for _, entry := range reader.File {
+			if entry.FileInfo().IsDir() {
+				if err := budget.mkdir(entry.Name); err != nil {
+					return err
+				}
+				continue
+			}
+			if !entry.Mode().IsRegular() {
+				return fmt.Errorf("%w: %s is not a regular file", errInvalidArchive, entry.Name)
+			}
+			content, err := entry.Open()
+			if err != nil {
+				return fmt.Errorf("%w: %s: %v", errInvalidArchive, entry.Name, err)
+			}
+			err = budget.write(entry.Name, content)
+			content.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+	defer gz.Close()
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalidArchive, err)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = budget.mkdir(header.Name)
+		case tar.TypeReg:
+			err = budget.write(header.Name, reader)
+		default:
+			err = fmt.Errorf("%w: %s is not a regular file", errInvalidArchive, header.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func getDeployTarget(website Website) (deployTarget, error) {
+	target := deployTarget{}
+	found, err := storeGet(deployTargetBucket, strconv.Itoa(website.ID), &target)
+	if err != nil || found {
+		target.Site = website.Name
+		return target, err
+	}
+	original := filepath.Clean(expandPhysicalPath(website.PhysicalPath))
+	return deployTarget{
+		SiteID:       website.ID,
+		Site:         website.Name,
+		OriginalPath: website.PhysicalPath,
+		ReleasesDir:  original + "-releases",
+	}, nil
+}
+
+// DeployArchiveAction extracts an uploaded archive into a new release
+// directory next to the site and then points the site at it, so requests
+// see either the old content or the new, never a mix. Without a web.config
+// of its own the release gets a copy of the current one, which holds the
+// site's rewrite rules, headers and other settings. Each step is reported
+// in the returned deployment, failed or not.
+func DeployArchiveAction(website Website, name string, file archiveFile, size int64, keepConfig bool) (Deployment, error) {
+	deployment := newDeployment(website)
+	deployment.ArchiveName = name
+	return deployArchive(website, deployment, file, size, keepConfig)
+}
+
+func newDeployment(website Website) Deployment {
+	now := time.Now().UTC()
+	return Deployment{
+		ID:           now.Format(storeTimeFormat),
+		SiteID:       website.ID,
+		Site:         website.Name,
+		CreatedAt:    now,
+		Kind:         deployKind,
+		PreviousPath: website.PhysicalPath,
+		Steps:        []DeployStep{},
+	}
+}
+
+// deployArchive carries on a deployment the caller has started, possibly
+// with steps of its own already done, from the archive on.
+func deployArchive(website Website, deployment Deployment, file archiveFile, size int64, keepConfig bool) (Deployment, error) {
+	deployMu.Lock()
+	defer deployMu.Unlock()
+	deployment.ArchiveBytes = size
+
+	maintenance, err := GetMaintenanceAction(website)
+	if err != nil {
+		return deployment, err
+	}
+	if maintenance.Active {
+		return deployment, errDeployInMaintenance
+	}
+	settings, err := GetDeploySettingsAction()
+	if err != nil {
+		return deployment, err
+	}
+	target, err := getDeployTarget(website)
+	if err != nil {
+		return deployment, err
+	}
+	hooks, err := GetDeployHooksAction(website)
+	if err != nil {
+		return deployment, err
+	}
+
+	err = deployment.step("verify", func() (string, error) {
+		if size > int64(settings.MaxUploadMB)<<20 {
+			return "", fmt.Errorf("%w: archive is larger than %d MB", errDeployTooLarge, settings.MaxUploadMB)
+		}
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(file, header); err != nil {
+			return "", fmt.Errorf("%w: %v", errInvalidArchive, err)
+		}
+		format, err := detectArchiveFormat(header)
+		if err != nil {
+			return "", err
+		}
+		hash := sha256.New()
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(hash, file); err != nil {
+			return "", err
+		}
+		deployment.Format, deployment.SHA256 = format, hex.EncodeToString(hash.Sum(nil))
+		_, err = file.Seek(0, io.SeekStart)
+		return format, err
+	})
+	if err != nil {
+		return deployment, deployment.fail(err)
+	}
+
+	release := filepath.Join(target.ReleasesDir, deployment.ID)
+	staging := release + stagingSuffix
+	err = deployment.step("extract", func() (string, error) {
+		if err := os.MkdirAll(staging, 0o755); err != nil {
+			return "", fmt.Errorf("failed to create %s: %v", staging, err)
+		}
+		budget := &extractBudget{dest: staging, maxFiles: settings.MaxFiles, maxBytes: int64(settings.MaxExtractedMB) << 20}
+		if err := extractArchive(file, size, deployment.Format, budget); err != nil {
+			return "", err
+		}
+		deployment.Files, deployment.Bytes = budget.files, budget.bytes
+		// Only complete extractions become releases
+		if err := os.Rename(staging, release); err != nil {
+			return "", fmt.Errorf("failed to move release into place: %v", err)
+		}
+		return fmt.Sprintf("%d files, %d bytes", budget.files, budget.bytes), nil
+	})
+	if err != nil {
+		os.RemoveAll(staging)
+		return deployment, deployment.fail(err)
+	}
+
+	if keepConfig {
+		err = deployment.step("config", func() (string, error) {
+			if _, err := os.Stat(filepath.Join(release, "web.config")); err == nil {
+				return "archive has its own web.config", nil
+			}
+			current, err := os.ReadFile(filepath.Join(expandPhysicalPath(website.PhysicalPath), "web.config"))
+			if errors.Is(err, os.ErrNotExist) {
+				return "no web.config to keep", nil
+			}
+			if err != nil {
+				return "", fmt.Errorf("failed to read current web.config: %v", err)
+			}
+			deployment.KeptConfig = true
+			return "kept the current web.config", os.WriteFile(filepath.Join(release, "web.config"), current, 0o644)
+		})
+		if err != nil {
+			os.RemoveAll(release)
+			return deployment, deployment.fail(err)
+		}
+	}
+
+	if err := deployment.runHooks(hooks, hookPreSwap, release); err != nil {
+		os.RemoveAll(release)
+		return deployment, deployment.fail(err)
+	}
+	err = deployment.step("swap", func() (string, error) {
+		return release, SetSitePhysicalPathAction(website.Name, release)
+	})
+	if err != nil {
+		os.RemoveAll(release)
+		return deployment, deployment.fail(err)
+	}
+	// The site already serves the release; a failing post-swap hook is
+	// reported in its step but does not undo the deployment
+	deployment.runHooks(hooks, hookPostSwap, release)
+	deployment.Path, deployment.Status = release, deployedStatus
+	if err := storePut(deployTargetBucket, strconv.Itoa(website.ID), target); err != nil {
+		return deployment, err
+	}
+	if err := recordDeployment(deployment); err != nil {
+		return deployment, err
+	}
+	// The deployment stands even if pruning fails; the step shows why
+	website.PhysicalPath = release
+	deployment.step("prune", func() (string, error) {
+		pruned, err := pruneReleases(website, settings.KeepReleases)
+		return fmt.Sprintf("removed %d old releases", len(pruned)), err
+	})
+	return deployment, recordDeployment(deployment)
+}
+
+// fail records the deployment as failed with err, after running the
+// site's failure hooks.
+func (d *Deployment) fail(err error) error {
+	d.Status, d.Error = deployFailed, err.Error()
+	hooks, hooksErr := GetDeployHooksAction(Website{ID: d.SiteID, Name: d.Site})
+	if hooksErr == nil {
+		d.runHooks(hooks, hookFailure, "")
+	}
+	return errors.Join(err, hooksErr, recordDeployment(*d))
+}
+
+// step runs one stage of a deployment and reports it, failed or not.
+func (d *Deployment) step(name string, run func() (string, error)) error {
+	started := time.Now()
+	detail, err := run()
+	step := DeployStep{Name: name, Status: deployDone, DurationMs: time.Since(started).Milliseconds(), Detail: detail}
+	if err != nil {
+		step.Status, step.Detail = deployFailed, err.Error()
+	}
+	d.Steps = append(d.Steps, step)
+	return err
+}
+
+func recordDeployment(deployment Deployment) error {
+	return storePut(deploymentBucket, siteKeyPrefix(deployment.SiteID)+deployment.ID, deployment)
+}
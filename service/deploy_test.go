@@ -0,0 +1,149 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// archiveEntry is one entry of a test archive. kind takes the tar type
+// flags; zip has no hard links, so those are only written to tar.
+type archiveEntry struct {
+	name string
+	body string
+	kind byte
+}
+
+func zipArchive(t *testing.T, entries []archiveEntry) *bytes.Reader {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		switch entry.kind {
+		case tar.TypeDir:
+			header.SetMode(fs.ModeDir | 0o755)
+		case tar.TypeSymlink:
+			header.SetMode(fs.ModeSymlink | 0o777)
+		default:
+			header.SetMode(0o644)
+		}
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(entry.body))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func tarArchive(t *testing.T, entries []archiveEntry) *bytes.Reader {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	w := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.kind, Mode: 0o644}
+		switch entry.kind {
+		case tar.TypeReg:
+			header.Size = int64(len(entry.body))
+		case tar.TypeDir:
+			header.Mode = 0o755
+		default:
+			header.Linkname = entry.body
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if entry.kind == tar.TypeReg {
+			w.Write([]byte(entry.body))
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestExtractArchive(t *testing.T) {
+	file := func(name string, body string) archiveEntry { return archiveEntry{name, body, tar.TypeReg} }
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		err     error
+		// written lists files expected inside the release afterwards
+		written []string
+		tarOnly bool
+		windows bool
+	}{
+		{"files and directories", []archiveEntry{{"css/", "", tar.TypeDir}, file("css/app.css", "body {}"), file("index.html", "hi")}, nil, []string{"css/app.css", "index.html"}, false, false},
+		{"parent entry", []archiveEntry{file("../evil.txt", "x")}, errInvalidArchive, nil, false, false},
+		{"nested parent entry", []archiveEntry{file("site/../../evil.txt", "x")}, errInvalidArchive, nil, false, false},
+		{"backslash parent entry", []archiveEntry{file(`..\evil.txt`, "x")}, errInvalidArchive, nil, false, false},
+		{"parent directory", []archiveEntry{{"../up/", "", tar.TypeDir}}, errInvalidArchive, nil, false, false},
+		{"absolute entry stays inside", []archiveEntry{file("/etc/evil.txt", "x")}, nil, []string{"etc/evil.txt"}, false, false},
+		{"unc entry stays inside", []archiveEntry{file(`\\server\share\evil.txt`, "x")}, nil, []string{"server/share/evil.txt"}, false, false},
+		{"drive letter", []archiveEntry{file(`C:\evil.txt`, "x")}, errInvalidArchive, nil, false, false},
+		{"drive relative", []archiveEntry{file("C:evil.txt", "x")}, errInvalidArchive, nil, false, false},
+		{"alternate data stream", []archiveEntry{file("index.html:evil", "x")}, errInvalidArchive, nil, false, false},
+		{"device name", []archiveEntry{file("NUL", "x")}, errInvalidArchive, nil, false, true},
+		{"device name with extension", []archiveEntry{file("logs/aux.txt", "x")}, errInvalidArchive, nil, false, true},
+		{"symlink", []archiveEntry{{"passwd", "/etc/passwd", tar.TypeSymlink}}, errInvalidArchive, nil, false, false},
+		{"symlink then write through it", []archiveEntry{{"up", "..", tar.TypeSymlink}, file("up/evil.txt", "x")}, errInvalidArchive, nil, false, false},
+		{"hard link", []archiveEntry{file("index.html", "hi"), {"passwd", "/etc/passwd", tar.TypeLink}}, errInvalidArchive, nil, true, false},
+		{"too many files", []archiveEntry{file("a", "1"), file("b", "2"), file("c", "3"), file("d", "4")}, errDeployTooLarge, nil, false, false},
+		{"file over the byte limit", []archiveEntry{file("big", strings.Repeat("x", 65))}, errDeployTooLarge, nil, false, false},
+		{"files over the byte limit together", []archiveEntry{file("a", strings.Repeat("x", 40)), file("b", strings.Repeat("x", 40))}, errDeployTooLarge, nil, false, false},
+		{"exactly at the limits", []archiveEntry{file("a", strings.Repeat("x", 32)), file("b", ""), file("c", strings.Repeat("x", 32))}, nil, []string{"a", "b", "c"}, false, false},
+	}
+	for _, format := range []string{deployZip, deployTarGz} {
+		for _, test := range tests {
+			t.Run(format+" "+test.name, func(t *testing.T) {
+				if test.windows && runtime.GOOS != "windows" {
+					t.Skip("device names are only reserved on Windows")
+				}
+				if test.tarOnly && format == deployZip {
+					t.Skip("zip has no hard links")
+				}
+				archive := tarArchive(t, test.entries)
+				if format == deployZip {
+					archive = zipArchive(t, test.entries)
+				}
+				parent := t.TempDir()
+				dest := filepath.Join(parent, "release")
+				budget := &extractBudget{dest: dest, maxFiles: 3, maxBytes: 64}
+				err := extractArchive(archive, archive.Size(), format, budget)
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected %v, got %v", test.err, err)
+				}
+				for _, name := range test.written {
+					if _, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name))); err != nil {
+						t.Errorf("expected %s in the release: %v", name, err)
+					}
+				}
+				// Nothing lands next to the release, whatever the outcome
+				entries, err := os.ReadDir(parent)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, entry := range entries {
+					if entry.Name() != "release" {
+						t.Errorf("extraction wrote %s outside the release", entry.Name())
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	diffContext = 3
+	// maxDiffCells bounds the LCS table. Past it the changed middle of a
+	// file is shown as removed and re-added rather than aligned line by line.
+	maxDiffCells = 4_000_000
+)
+
+type diffLine struct {
+	op   byte
+	text string
+}
+
+func splitLines(data []byte) []string {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines aligns a and b on their longest common subsequence after
+// trimming the common prefix and suffix, which is usually most of a config
+// file.
+func diffLines(a []string, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	lines := []diffLine{}
+	for _, text := range a[:prefix] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(midA)*len(midB) > maxDiffCells {
+		for _, text := range midA {
+			lines = append(lines, diffLine{'-', text})
+		}
+		for _, text := range midB {
+			lines = append(lines, diffLine{'+', text})
+		}
+	} else {
+		lines = append(lines, lcsDiff(midA, midB)...)
+	}
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	return lines
+}
+
+func lcsDiff(a []string, b []string) []diffLine {
+	width := len(b) + 1
+	table := make([]int32, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i*width+j] = table[(i+1)*width+j+1] + 1
+			} else {
+				table[i*width+j] = max(table[(i+1)*width+j], table[i*width+j+1])
+			}
+		}
+	}
+	lines := []diffLine{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case table[(i+1)*width+j] >= table[i*width+j+1]:
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{'+', b[j]})
+	}
+	return lines
+}
+
+// UnifiedDiff renders the difference between two files in unified diff
+// format, or "" when they are equal.
+func UnifiedDiff(fromName string, toName string, from []byte, to []byte) string {
+	lines := diffLines(splitLines(from), splitLines(to))
+	// Line numbers in each file before every diff line
+	fromLine := make([]int, len(lines)+1)
+	toLine := make([]int, len(lines)+1)
+	for k, line := range lines {
+		fromLine[k+1], toLine[k+1] = fromLine[k], toLine[k]
+		if line.op != '+' {
+			fromLine[k+1]++
+		}
+		if line.op != '-' {
+			toLine[k+1]++
+		}
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		start := max(i-diffContext, 0)
+		last := i
+		for j := i; j < len(lines) && j-last <= 2*diffContext; j++ {
+			if lines[j].op != ' ' {
+				last = j
+			}
+		}
+		stop := min(last+diffContext+1, len(lines))
+		fromCount, toCount := fromLine[stop]-fromLine[start], toLine[stop]-toLine[start]
+		fromStart, toStart := fromLine[start], toLine[start]
+		if fromCount > 0 {
+			fromStart++
+		}
+		if toCount > 0 {
+			toStart++
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", fromStart, fromCount, toStart, toCount)
+		for _, line := range lines[start:stop] {
+			out.WriteByte(line.op)
+			out.WriteString(line.text)
+			out.WriteByte('\n')
+		}
+		i = stop
+	}
+	return out.String()
+}
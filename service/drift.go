@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	baselineBucket = "site-baselines"
+	driftBucket    = "drift-reports"
+	driftLatest    = "latest"
+)
+
+var defaultDriftSettings = DriftSettings{IntervalMinutes: 15}
+
+func GetDriftSettingsAction() (DriftSettings, error) {
+	settings := defaultDriftSettings
+	_, err := storeGet(settingsBucket, "drift", &settings)
+	return settings, err
+}
+
+func SetDriftSettingsAction(settings DriftSettings) error {
+	if err := ValidateDriftSettings(settings); err != nil {
+		return err
+	}
+	return storePut(settingsBucket, "drift", settings)
+}
+
+func ValidateDriftSettings(settings DriftSettings) error {
+	if settings.IntervalMinutes < 0 || settings.IntervalMinutes > 7*24*60 {
+		return fmt.Errorf("intervalMinutes must be between 0 (off) and %d", 7*24*60)
+	}
+	return nil
+}
+
+// captureSiteState reads the settings a baseline covers. site carries the
+// bindings and app pool, already read for all sites in one call.
+func captureSiteState(website Website, site ManifestSite) (SiteBaseline, error) {
+	auth, err := GetAuthenticationAction(website.Name, "")
+	if err != nil {
+		return SiteBaseline{}, err
+	}
+	headers, err := GetSiteHeadersAction(website.Name)
+	if err != nil {
+		return SiteBaseline{}, err
+	}
+	bindings := slices.Clone(site.Bindings)
+	slices.SortFunc(bindings, func(a, b ManifestBinding) int {
+		return strings.Compare(bindingInformation(a), bindingInformation(b))
+	})
+	return SiteBaseline{
+		SiteID:         website.ID,
+		Site:           website.Name,
+		Bindings:       bindings,
+		AppPool:        site.AppPool,
+		Authentication: auth,
+		Headers:        SiteHeaders{Headers: headers.Headers, RemoveXPoweredBy: headers.RemoveXPoweredBy, RemoveServerHeader: headers.RemoveServerHeader},
+	}, nil
+}
+
+func liveManifestSite(live Manifest, name string) (ManifestSite, bool) {
+	i := slices.IndexFunc(live.Sites, func(s ManifestSite) bool { return strings.EqualFold(s.Name, name) })
+	if i < 0 {
+		return ManifestSite{}, false
+	}
+	return live.Sites[i], true
+}
+
+func GetBaselineAction(website Website) (SiteBaseline, bool, error) {
+	baseline := SiteBaseline{}
+	found, err := storeGet(baselineBucket, strconv.Itoa(website.ID), &baseline)
+	baseline.Site = website.Name
+	return baseline, found, err
+}
+
+// RecordBaselineAction stores the site's current settings as its baseline.
+// Recording over an existing baseline is how drift is accepted.
+func RecordBaselineAction(website Website) (SiteBaseline, error) {
+	live, err := readLiveManifest(func(string) bool { return false })
+	if err != nil {
+		return SiteBaseline{}, err
+	}
+	site, ok := liveManifestSite(live, website.Name)
+	if !ok {
+		return SiteBaseline{}, fmt.Errorf("website %s not found", website.Name)
+	}
+	baseline, err := captureSiteState(website, site)
+	if err != nil {
+		return SiteBaseline{}, err
+	}
+	baseline.RecordedAt = time.Now().UTC()
+	if err := storePut(baselineBucket, strconv.Itoa(website.ID), baseline); err != nil {
+		return baseline, err
+	}
+	return baseline, invalidateDriftReport()
+}
+
+func DeleteBaselineAction(website Website) error {
+	if err := storeDelete(baselineBucket, strconv.Itoa(website.ID)); err != nil {
+		return err
+	}
+	return invalidateDriftReport()
+}
+
+// invalidateDriftReport drops the stored report once it is known to be
+// stale, so the next read runs a fresh check.
+func invalidateDriftReport() error {
+	return storeDelete(driftBucket, driftLatest)
+}
+
+func normalizedHeaders(headers []CustomHeader) []string {
+	normalized := []string{}
+	for _, header := range headers {
+		normalized = append(normalized, strings.ToLower(header.Name)+": "+header.Value)
+	}
+	slices.Sort(normalized)
+	return normalized
+}
+
+func sameJSON(a any, b any) bool {
+	left, _ := json.Marshal(a)
+	right, _ := json.Marshal(b)
+	return string(left) == string(right)
+}
+
+// CompareBaseline lists the fields where current differs from baseline.
+// Custom headers compare as a set; Windows providers keep their order,
+// since IIS tries them in that order.
+func CompareBaseline(baseline SiteBaseline, current SiteBaseline) []FieldChange {
+	changes := []FieldChange{}
+	add := func(field string, before any, after any) {
+		changes = append(changes, FieldChange{Field: field, Before: before, After: after})
+	}
+	if before, after := bindingKeys(baseline.Bindings), bindingKeys(current.Bindings); !slices.Equal(before, after) {
+		add("bindings", before, after)
+	}
+	if !strings.EqualFold(baseline.AppPool, current.AppPool) {
+		add("appPool", baseline.AppPool, current.AppPool)
+	}
+	anonymous, currentAnonymous := baseline.Authentication.Anonymous, current.Authentication.Anonymous
+	anonymous.Password, currentAnonymous.Password = "", ""
+	if anonymous != currentAnonymous {
+		add("authentication.anonymous", anonymous, currentAnonymous)
+	}
+	if baseline.Authentication.Basic != current.Authentication.Basic {
+		add("authentication.basic", baseline.Authentication.Basic, current.Authentication.Basic)
+	}
+	if !sameJSON(baseline.Authentication.Windows, current.Authentication.Windows) {
+		add("authentication.windows", baseline.Authentication.Windows, current.Authentication.Windows)
+	}
+	if baseline.Authentication.Digest != current.Authentication.Digest {
+		add("authentication.digest", baseline.Authentication.Digest, current.Authentication.Digest)
+	}
+	if before, after := normalizedHeaders(baseline.Headers.Headers), normalizedHeaders(current.Headers.Headers); !slices.Equal(before, after) {
+		add("headers.customHeaders", baseline.Headers.Headers, current.Headers.Headers)
+	}
+	if baseline.Headers.RemoveXPoweredBy != current.Headers.RemoveXPoweredBy {
+		add("headers.removeXPoweredBy", baseline.Headers.RemoveXPoweredBy, current.Headers.RemoveXPoweredBy)
+	}
+	if baseline.Headers.RemoveServerHeader != current.Headers.RemoveServerHeader {
+		add("headers.removeServerHeader", baseline.Headers.RemoveServerHeader, current.Headers.RemoveServerHeader)
+	}
+	return changes
+}
+
+// DriftCheckAction compares every site that has a baseline with live IIS
+// and stores the report as the latest one.
+func DriftCheckAction() (DriftReport, error) {
+	baselines, err := storeList[SiteBaseline](baselineBucket)
+	if err != nil {
+		return DriftReport{}, err
+	}
+	report := DriftReport{CheckedAt: time.Now().UTC(), Checked: len(baselines), Sites: []SiteDrift{}}
+	if len(baselines) == 0 {
+		return report, storePut(driftBucket, driftLatest, report)
+	}
+	live, err := readLiveManifest(func(string) bool { return false })
+	if err != nil {
+		return DriftReport{}, err
+	}
+	websites, err := ListWebsitesAction()
+	if err != nil {
+		return DriftReport{}, err
+	}
+	for _, baseline := range baselines {
+		drift := SiteDrift{SiteID: baseline.SiteID, Site: baseline.Site, BaselineAt: baseline.RecordedAt, Changes: []FieldChange{}}
+		// Sites are matched by ID, so a rename in IIS Manager shows up as drift
+		i := slices.IndexFunc(websites, func(w Website) bool { return w.ID == baseline.SiteID })
+		if i < 0 {
+			drift.Missing = true
+			report.Sites = append(report.Sites, drift)
+			continue
+		}
+		site, _ := liveManifestSite(live, websites[i].Name)
+		current, err := captureSiteState(websites[i], site)
+		if err != nil {
+			drift.Error = err.Error()
+			report.Sites = append(report.Sites, drift)
+			continue
+		}
+		if websites[i].Name != baseline.Site {
+			drift.Changes = append(drift.Changes, FieldChange{Field: "name", Before: baseline.Site, After: websites[i].Name})
+		}
+		if drift.Changes = append(drift.Changes, CompareBaseline(baseline, current)...); len(drift.Changes) > 0 {
+			report.Sites = append(report.Sites, drift)
+		}
+	}
+	return report, storePut(driftBucket, driftLatest, report)
+}
+
+// LatestDriftAction returns the stored report, running a check when there
+// is none yet.
+func LatestDriftAction() (DriftReport, error) {
+	report := DriftReport{}
+	found, err := storeGet(driftBucket, driftLatest, &report)
+	if err != nil || found {
+		return report, err
+	}
+	return DriftCheckAction()
+}
+
+// RevertToBaselineAction writes back every drifted part of a site's
+// settings and returns what was reverted, then what was left alone. A
+// rename is never undone: the site is addressed by its new name by now, and
+// its records in the store already follow it.
+func RevertToBaselineAction(website Website, baseline SiteBaseline) ([]FieldChange, []FieldChange, error) {
+	kept := []FieldChange{}
+	if website.Name != baseline.Site {
+		kept = append(kept, FieldChange{Field: "name", Before: baseline.Site, After: website.Name})
+	}
+	live, err := readLiveManifest(func(string) bool { return false })
+	if err != nil {
+		return nil, kept, err
+	}
+	site, ok := liveManifestSite(live, website.Name)
+	if !ok {
+		return nil, kept, fmt.Errorf("website %s not found", website.Name)
+	}
+	current, err := captureSiteState(website, site)
+	if err != nil {
+		return nil, kept, err
+	}
+	changes := CompareBaseline(baseline, current)
+	reverted := map[string]bool{}
+	for _, change := range changes {
+		area := strings.SplitN(change.Field, ".", 2)[0]
+		if reverted[area] {
+			continue
+		}
+		reverted[area] = true
+		switch area {
+		case "bindings":
+			err = SetSiteBindingsAction(website.Name, baseline.Bindings)
+		case "appPool":
+			err = ChangeAppPoolAction(website.Name, baseline.AppPool)
+		case "authentication":
+			auth := baseline.Authentication
+			auth.Path = "/"
+			err = SetAuthenticationAction(website.Name, auth)
+		case "headers":
+			err = SetSiteHeadersAction(website.Name, baseline.Headers)
+		}
+		if err != nil {
+			return changes, kept, err
+		}
+	}
+	return changes, kept, invalidateDriftReport()
+}
+
+// StartDriftScheduler checks for drift every IntervalMinutes.
+func StartDriftScheduler() {
+	runEvery("drift check", func() (int, error) {
+		settings, err := GetDriftSettingsAction()
+		return settings.IntervalMinutes, err
+	}, func() error {
+		_, err := DriftCheckAction()
+		return err
+	})
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	fileBackupBucket = "file-backups"
+	// fileContentLimit caps what one read returns and the size of files
+	// that can be edited
+	fileContentLimit = 1 << 20
+	keepFileBackups  = 5
+
+	encodingUTF8        = "utf-8"
+	encodingUTF8BOM     = "utf-8-bom"
+	encodingUTF16LE     = "utf-16le"
+	encodingUTF16BE     = "utf-16be"
+	encodingWindows1252 = "windows-1252"
+)
+
+var (
+	fileEncodings = []string{encodingUTF8, encodingUTF8BOM, encodingUTF16LE, encodingUTF16BE, encodingWindows1252}
+
+	errFileChanged          = errors.New("file has changed since it was read")
+	errPreconditionRequired = errors.New("the etag or modTime of the version being replaced is required")
+	errBinaryFile           = errors.New("binary files cannot be edited")
+	errFileRange            = errors.New("invalid range")
+	errFileEncoding         = errors.New("unsupported encoding")
+	errBackupNotFound       = errors.New("backup not found")
+
+	// fileMu keeps the precondition check and the write of a save together
+	fileMu sync.Mutex
+
+	// windows1252 maps the bytes 0x80-0x9F, where Windows-1252 differs from
+	// Latin-1. Bytes it leaves undefined keep their own code point.
+	windows1252 = [32]rune{
+		'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+		0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+	}
+)
+
+// fileETag identifies a version of a file by its size and modification
+// time, so it can be checked without reading the file.
+func fileETag(info fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// detectEncoding works out a file's encoding from its first bytes: a byte
+// order mark, else UTF-8 if they are valid UTF-8, else Windows-1252. Files
+// with NUL bytes and no UTF-16 mark are binary. whole says sample is the
+// entire file rather than its start, which may end inside a character.
+func detectEncoding(sample []byte, whole bool) (encoding string, bom int, binary bool) {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xEF, 0xBB, 0xBF}):
+		return encodingUTF8BOM, 3, false
+	case bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		return encodingUTF16LE, 2, false
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}):
+		return encodingUTF16BE, 2, false
+	case bytes.IndexByte(sample, 0) >= 0:
+		return "", 0, true
+	}
+	if !whole {
+		sample, _ = trimPartialRune(sample)
+	}
+	if utf8.Valid(sample) {
+		return encodingUTF8, 0, false
+	}
+	return encodingWindows1252, 0, false
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence from the end of data
+// and reports how many bytes went.
+func trimPartialRune(data []byte) ([]byte, int) {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return data[:i], len(data) - i
+			}
+			break
+		}
+	}
+	return data, 0
+}
+
+// alignRange narrows a slice read from offset so it neither starts nor,
+// unless it runs to the end of the file, ends inside a character.
+func alignRange(data []byte, offset int64, encoding string, toEnd bool) ([]byte, int64) {
+	switch encoding {
+	case encodingUTF8, encodingUTF8BOM:
+		for i := 0; i < utf8.UTFMax-1 && len(data) > 0 && !utf8.RuneStart(data[0]); i++ {
+			data, offset = data[1:], offset+1
+		}
+		if !toEnd {
+			data, _ = trimPartialRune(data)
+		}
+	case encodingUTF16LE, encodingUTF16BE:
+		if offset%2 == 1 && len(data) > 0 {
+			data, offset = data[1:], offset+1
+		}
+		data = data[:len(data)-len(data)%2]
+	}
+	return data, offset
+}
+
+func decodeText(data []byte, encoding string) string {
+	switch encoding {
+	case encodingUTF16LE, encodingUTF16BE:
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if encoding == encodingUTF16LE {
+				units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+			} else {
+				units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+			}
+		}
+		return string(utf16.Decode(units))
+	case encodingWindows1252:
+		var text strings.Builder
+		for _, b := range data {
+			if b >= 0x80 && b <= 0x9F {
+				text.WriteRune(windows1252[b-0x80])
+			} else {
+				text.WriteRune(rune(b))
+			}
+		}
+		return text.String()
+	}
+	return string(data)
+}
+
+// encodeText writes text in the given encoding, byte order mark included.
+func encodeText(text string, encoding string) ([]byte, error) {
+	switch encoding {
+	case encodingUTF8:
+		return []byte(text), nil
+	case encodingUTF8BOM:
+		return append([]byte{0xEF, 0xBB, 0xBF}, text...), nil
+	case encodingUTF16LE, encodingUTF16BE:
+		data := []byte{0xFF, 0xFE}
+		if encoding == encodingUTF16BE {
+			data = []byte{0xFE, 0xFF}
+		}
+		for _, unit := range utf16.Encode([]rune(text)) {
+			if encoding == encodingUTF16LE {
+				data = append(data, byte(unit), byte(unit>>8))
+			} else {
+				data = append(data, byte(unit>>8), byte(unit))
+			}
+		}
+		return data, nil
+	case encodingWindows1252:
+		data := make([]byte, 0, len(text))
+		for _, r := range text {
+			switch i := slices.Index(windows1252[:], r); {
+			case i >= 0:
+				data = append(data, byte(0x80+i))
+			case r < 0x80 || (r > 0x9F && r <= 0xFF):
+				data = append(data, byte(r))
+			default:
+				return nil, fmt.Errorf("%w: %q cannot be written in windows-1252", errFileEncoding, r)
+			}
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("%w: %s", errFileEncoding, encoding)
+}
+
+// ReadFileContentAction reads a text file in the site, or the part of it
+// from offset, at most fileContentLimit bytes at a time. Binary files are
+// described but their content is not returned.
+func ReadFileContentAction(website Website, rel string, offset int64, length int64) (FileContent, error) {
+	clean, err := siteFilePath(rel)
+	if err != nil {
+		return FileContent{}, err
+	}
+	file, info, err := OpenSiteFileAction(website, rel)
+	if err != nil {
+		return FileContent{}, err
+	}
+	defer file.Close()
+	size := info.Size()
+	if offset < 0 || length < 0 || offset > size {
+		return FileContent{}, fmt.Errorf("%w: offset %d and length %d for a file of %d bytes", errFileRange, offset, length, size)
+	}
+	sample := make([]byte, min(size, fileContentLimit))
+	if _, err := io.ReadFull(file, sample); err != nil {
+		return FileContent{}, err
+	}
+	encoding, bom, binary := detectEncoding(sample, size <= fileContentLimit)
+	content := FileContent{
+		Path:     filepath.ToSlash(clean),
+		Size:     size,
+		ModTime:  info.ModTime(),
+		ETag:     fileETag(info),
+		Encoding: encoding,
+		Binary:   binary,
+	}
+	if binary {
+		return content, nil
+	}
+
+	// The byte order mark is not part of the text
+	offset = max(offset, int64(bom))
+	if length == 0 || length > fileContentLimit {
+		length = fileContentLimit
+	}
+	end := min(offset+length, size)
+	data := make([]byte, end-offset)
+	if _, err := file.ReadAt(data, offset); err != nil && !errors.Is(err, io.EOF) {
+		return FileContent{}, err
+	}
+	data, offset = alignRange(data, offset, encoding, end == size)
+	content.Offset, content.Length = offset, int64(len(data))
+	content.Complete = offset == int64(bom) && end == size
+	content.Content = decodeText(data, encoding)
+	return content, nil
+}
+
+// SaveFileContentAction writes a text file in the site. Replacing a file
+// needs the etag or modTime it was read with, and fails if the file has
+// changed since; the version replaced is kept as a backup. The file keeps
+// its encoding unless another is given.
+func SaveFileContentAction(website Website, request SaveFileRequest) (FileSaved, error) {
+	if request.Encoding != "" && !slices.Contains(fileEncodings, request.Encoding) {
+		return FileSaved{}, fmt.Errorf("%w: %s, use one of %s", errFileEncoding, request.Encoding, strings.Join(fileEncodings, ", "))
+	}
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	previous, info, err := readEditableFile(website, request.Path)
+	switch {
+	case errors.Is(err, errFileNotFound) && request.Create:
+		// A new file, with nothing to compare against
+	case err != nil:
+		return FileSaved{}, err
+	case request.ETag == "" && request.ModTime == nil:
+		return FileSaved{}, errPreconditionRequired
+	case request.ETag != "" && request.ETag != fileETag(info),
+		request.ModTime != nil && !request.ModTime.Equal(info.ModTime()):
+		return FileSaved{}, fmt.Errorf("%w: %s", errFileChanged, request.Path)
+	}
+	encoding := request.Encoding
+	if encoding == "" && info != nil {
+		encoding, _, _ = detectEncoding(previous, true)
+	}
+	if encoding == "" {
+		encoding = encodingUTF8
+	}
+	data, err := encodeText(request.Content, encoding)
+	if err != nil {
+		return FileSaved{}, err
+	}
+	saved, err := writeSiteFile(website, request.Path, data, previous, info)
+	saved.Encoding = encoding
+	return saved, err
+}
+
+// readEditableFile reads the current version of a file about to be
+// replaced.
+func readEditableFile(website Website, rel string) ([]byte, fs.FileInfo, error) {
+	file, info, err := OpenSiteFileAction(website, rel)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	if info.Size() > fileContentLimit {
+		return nil, nil, fmt.Errorf("%w: files over %d MB cannot be edited", errFileTooLarge, fileContentLimit>>20)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, _, binary := detectEncoding(data, true); binary {
+		return nil, nil, fmt.Errorf("%w: %s", errBinaryFile, rel)
+	}
+	return data, info, nil
+}
+
+// writeSiteFile replaces a file through a temporary file beside it, so
+// readers such as IIS never see it half written. previous, the version
+// being replaced, is backed up first; info is nil for a new file.
+func writeSiteFile(website Website, rel string, data []byte, previous []byte, info fs.FileInfo) (FileSaved, error) {
+	if len(data) > fileContentLimit {
+		return FileSaved{}, fmt.Errorf("%w: files over %d MB cannot be edited", errFileTooLarge, fileContentLimit>>20)
+	}
+	root, path, err := resolveSitePath(website, rel)
+	if err != nil {
+		return FileSaved{}, err
+	}
+	if path == root {
+		return FileSaved{}, fmt.Errorf("%w: a file path is required", errInvalidFilePath)
+	}
+	clean, _ := siteFilePath(rel)
+	saved := FileSaved{Path: filepath.ToSlash(clean)}
+	if info != nil {
+		backup := FileBackup{
+			ID:      time.Now().UTC().Format(storeTimeFormat),
+			SiteID:  website.ID,
+			Site:    website.Name,
+			Path:    saved.Path,
+			At:      time.Now().UTC(),
+			Size:    int64(len(previous)),
+			ETag:    fileETag(info),
+			Content: previous,
+		}
+		if err := storePut(fileBackupBucket, siteKeyPrefix(website.ID)+backup.ID, backup); err != nil {
+			return saved, err
+		}
+		backup.Content = nil
+		saved.Backup = &backup
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".edit-*")
+	if err != nil {
+		return saved, fileError(filepath.ToSlash(filepath.Dir(clean)), err)
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && info != nil {
+		err = os.Chmod(temp.Name(), info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		return saved, fmt.Errorf("failed to write %s: %v", rel, err)
+	}
+	if info != nil {
+		if err := pruneFileBackups(website, saved.Path); err != nil {
+			return saved, err
+		}
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return saved, err
+	}
+	saved.Size, saved.ModTime, saved.ETag = current.Size(), current.ModTime(), fileETag(current)
+	return saved, nil
+}
+
+// listFileBackups returns a file's backups, newest first.
+func listFileBackups(website Website, rel string) ([]FileBackup, error) {
+	clean, err := siteFilePath(rel)
+	if err != nil {
+		return nil, err
+	}
+	all, err := storeListRange[FileBackup](fileBackupBucket, siteKeyPrefix(website.ID), "", "")
+	if err != nil {
+		return nil, err
+	}
+	backups := []FileBackup{}
+	for _, backup := range slices.Backward(all) {
+		if samePath(backup.Path, clean) {
+			backups = append(backups, backup)
+		}
+	}
+	return backups, nil
+}
+
+func pruneFileBackups(website Website, rel string) error {
+	backups, err := listFileBackups(website, rel)
+	if err != nil || len(backups) <= keepFileBackups {
+		return err
+	}
+	for _, backup := range backups[keepFileBackups:] {
+		if err := storeDelete(fileBackupBucket, siteKeyPrefix(website.ID)+backup.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetFileBackupsAction lists the backups kept of a file, without their
+// content.
+func GetFileBackupsAction(website Website, rel string) ([]FileBackup, error) {
+	backups, err := listFileBackups(website, rel)
+	for i := range backups {
+		backups[i].Content = nil
+	}
+	return backups, err
+}
+
+// GetFileBackupAction looks up one of the site's file backups.
+func GetFileBackupAction(website Website, id string) (FileBackup, error) {
+	backup := FileBackup{}
+	found, err := storeGet(fileBackupBucket, siteKeyPrefix(website.ID)+id, &backup)
+	if err != nil {
+		return FileBackup{}, err
+	}
+	if !found {
+		return FileBackup{}, fmt.Errorf("%w: %s", errBackupNotFound, id)
+	}
+	return backup, nil
+}
+
+// RestoreFileBackupAction puts a backup back in place of its file, which
+// is itself backed up first. A file that has since been deleted is
+// recreated.
+func RestoreFileBackupAction(website Website, id string) (FileSaved, error) {
+	backup, err := GetFileBackupAction(website, id)
+	if err != nil {
+		return FileSaved{}, err
+	}
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	previous, info, err := readEditableFile(website, backup.Path)
+	if err != nil && !errors.Is(err, errFileNotFound) {
+		return FileSaved{}, err
+	}
+	saved, err := writeSiteFile(website, backup.Path, backup.Content, previous, info)
+	saved.Encoding, _, _ = detectEncoding(backup.Content, true)
+	return saved, err
+}
@@ -1,8 +1,22 @@
 package main
 
 import (
+	"archive/zip"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -23,9 +37,47 @@ func GetRootEndpoint(c *gin.Context) {
 }
 
 func GetWebsitesEndpoint(c *gin.Context) {
-	output := IISWebsitesAction()
-	websites := getSites(output)
-	c.JSON(200, websites)
+	query := WebsiteQuery{}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	websites, err := ListWebsitesAction()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	websites, err = attachMetadata(websites)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	websites, err = attachHealth(websites)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	websites, err = attachMaintenance(websites)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	websites, err = attachSlots(websites)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	page, err := QueryWebsites(websites, query)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("X-Total-Count", strconv.Itoa(page.Total))
+	if query.Paged() {
+		c.JSON(200, page)
+		return
+	}
+	c.JSON(200, page.Items)
 }
 
 func GetWebsiteEndpoint(c *gin.Context) {
@@ -44,6 +96,24 @@ func GetWebsiteEndpoint(c *gin.Context) {
 	if err != nil {
 		c.JSON(404, err.Error())
 	}
+	if summary, err := AuthenticationSummaryAction(website); err == nil {
+		siteInfo.Authentication = summary
+	}
+	if limits, err := GetSiteLimitsAction(website); err == nil {
+		siteInfo.Limits = &limits
+	}
+	if metadata, err := GetSiteMetadataAction(siteInfo); err == nil {
+		siteInfo.Metadata = &metadata
+	}
+	if health, found, err := GetHealthAction(siteInfo); err == nil && found {
+		siteInfo.Health = health.Summary()
+	}
+	if maintenance, err := GetMaintenanceAction(siteInfo); err == nil && maintenance.Active {
+		siteInfo.Maintenance = &maintenance
+	}
+	if slots, found, err := GetSlotsAction(siteInfo); err == nil && found {
+		siteInfo.Slots = slots.Summary(siteInfo)
+	}
 	c.JSON(200, siteInfo)
 }
 
@@ -74,7 +144,7 @@ func PostCreateWebsiteEndpoint(c *gin.Context) {
 }
 
 func PutUpdateWebsiteEndpoint(c *gin.Context) {
-	original := c.Param("original")
+	original := c.Param("name")
 	body := c.Request.Body
 	defer body.Close()
 	bodyBytes, err := io.ReadAll(body)
@@ -155,24 +225,2077 @@ func GetLogsEndpoint(c *gin.Context) {
 
 
 func GetDirEndpoint(c *gin.Context) {
-	site := c.Param("site")
-	if !WebsiteExistsByName(site) {
+	website, err := GetByNameAction(c.Param("site"))
+	if err != nil {
 		c.JSON(404, gin.H{"error": "Website not found"})
 		return
 	}
-	dirs := GetDirectoryContentAction(site)
+	dirs, err := ListFilesAction(website, "")
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
 	c.JSON(200, dirs)
-
 }
 
 func GetDirTreeEndpoint(c *gin.Context) {
-	site := c.Param("site")
-	tree := c.Query("tree")
-	if !WebsiteExistsByName(site) {
+	website, err := GetByNameAction(c.Param("site"))
+	if err != nil {
 		c.JSON(404, gin.H{"error": "Website not found"})
 		return
 	}
-	dirs := GetDirectoryTreeAction(site, tree)
+	dirs, err := ListFilesAction(website, c.Query("tree"))
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
 	c.JSON(200, dirs)
+}
+
+func GetRewriteRulesEndpoint(c *gin.Context) {
+	site := c.Param("name")
+	if !WebsiteExistsByName(site) {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	rules, err := GetRewriteRulesAction(site)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, rules)
+}
+
+func PostRewriteRuleEndpoint(c *gin.Context) {
+	site := c.Param("name")
+	rule := RewriteRule{}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !WebsiteExistsByName(site) {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	rule = normalizeRewriteRule(rule)
+	if err := ValidateRewriteRules([]RewriteRule{rule}); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := AddRewriteRuleAction(site, rule); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Rewrite rule added"})
+}
+
+func PostRewritePresetEndpoint(c *gin.Context) {
+	site := c.Param("name")
+	preset := RewritePresetRequest{}
+	if err := c.ShouldBindJSON(&preset); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	rule, err := RewritePresetRule(preset)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !WebsiteExistsByName(site) {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	if err := AddRewriteRuleAction(site, rule); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, rule)
+}
+
+func PutRewriteOrderEndpoint(c *gin.Context) {
+	site := c.Param("name")
+	order := RewriteOrderRequest{}
+	if err := c.ShouldBindJSON(&order); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !WebsiteExistsByName(site) {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	err := ReorderRewriteRulesAction(site, order.Names)
+	switch {
+	case errors.Is(err, errRewriteOrder):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Rewrite rules reordered"})
+}
+
+func DeleteRewriteRuleEndpoint(c *gin.Context) {
+	site := c.Param("name")
+	if !WebsiteExistsByName(site) {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	err := DeleteRewriteRuleAction(site, c.Param("rule"))
+	switch {
+	case errors.Is(err, errRewriteRuleNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Rewrite rule deleted"})
+}
+
+// PostRewriteTestEndpoint evaluates a sample url against the site's live
+// rules, or against the rules in the body when given, without touching IIS.
+func PostRewriteTestEndpoint(c *gin.Context) {
+	site := c.Param("name")
+	test := RewriteTestRequest{}
+	if err := c.ShouldBindJSON(&test); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	rules := test.Rules
+	if rules == nil {
+		if !WebsiteExistsByName(site) {
+			c.JSON(404, gin.H{"error": "Website not found"})
+			return
+		}
+		live, err := GetRewriteRulesAction(site)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		rules = live
+	}
+	result, err := EvaluateRewriteRules(rules, test.URL)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, result)
+}
+
+func GetServerIPSecurityEndpoint(c *gin.Context) {
+	policy, err := GetIPSecurityAction("")
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, policy)
+}
+
+func PutServerIPSecurityEndpoint(c *gin.Context) {
+	policy := IPSecurityPolicy{}
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateIPSecurityPolicy(policy); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := SetIPSecurityAction("", policy); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "IP security updated"})
+}
+
+func GetServerIPSecurityEffectiveEndpoint(c *gin.Context) {
+	decision, err := EffectiveIPSecurityAction("", c.Query("ip"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, decision)
+}
+
+func GetIPSecurityEndpoint(c *gin.Context) {
+	site := c.Param("name")
+	if !WebsiteExistsByName(site) {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	policy, err := GetIPSecurityAction(site)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, policy)
+}
+
+func PutIPSecurityEndpoint(c *gin.Context) {
+	site := c.Param("name")
+	policy := IPSecurityPolicy{}
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateIPSecurityPolicy(policy); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !WebsiteExistsByName(site) {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	if err := SetIPSecurityAction(site, policy); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "IP security updated"})
+}
+
+func GetIPSecurityEffectiveEndpoint(c *gin.Context) {
+	site := c.Param("name")
+	if !WebsiteExistsByName(site) {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	decision, err := EffectiveIPSecurityAction(site, c.Query("ip"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, decision)
+}
+
+func GetAuthenticationEndpoint(c *gin.Context) {
+	site := c.Param("name")
+	if !WebsiteExistsByName(site) {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	settings, err := GetAuthenticationAction(site, c.Query("path"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, settings)
+}
+
+func PutAuthenticationEndpoint(c *gin.Context) {
+	site := c.Param("name")
+	settings := AuthenticationSettings{}
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateAuthentication(settings); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(site)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	if err := SetAuthenticationAction(site, settings); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Authentication updated", "warnings": AuthenticationWarnings(settings, website)})
+}
+
+func GetSiteHeadersEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	headers, err := GetSiteHeadersWithDriftAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, headers)
+}
+
+func PutSiteHeadersEndpoint(c *gin.Context) {
+	site := c.Param("name")
+	headers := SiteHeaders{}
+	if err := c.ShouldBindJSON(&headers); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateCustomHeaders(headers.Headers); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !WebsiteExistsByName(site) {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	if err := SetSiteHeadersAction(site, headers); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Headers updated"})
+}
+
+func DeleteSiteHeaderProfileEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	if err := UnassignHeaderProfileAction(website); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Header profile unassigned"})
+}
+
+func GetHeaderProfilesEndpoint(c *gin.Context) {
+	profiles, err := GetHeaderProfilesAction()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, profiles)
+}
+
+func PutHeaderProfileEndpoint(c *gin.Context) {
+	profile := SecurityHeaderProfile{}
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	profile.Name = c.Param("profile")
+	if err := SaveHeaderProfileAction(profile); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Header profile saved"})
+}
+
+func DeleteHeaderProfileEndpoint(c *gin.Context) {
+	if err := DeleteHeaderProfileAction(c.Param("profile")); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Header profile deleted"})
+}
+
+func PostApplyHeaderProfileEndpoint(c *gin.Context) {
+	request := SitesRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	results, err := ApplyHeaderProfileAction(c.Param("profile"), request.Sites)
+	if err != nil {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, results)
+}
+
+func GetHeaderDriftEndpoint(c *gin.Context) {
+	reports, err := HeaderDriftReportAction()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, reports)
+}
 
-}
\ No newline at end of file
+func GetHTTPErrorsEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	config, err := GetHTTPErrorsAction(website.Name)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	config.Warnings = HTTPErrorWarnings(website, config)
+	c.JSON(200, config)
+}
+
+func PutHTTPErrorsEndpoint(c *gin.Context) {
+	config := HTTPErrorsConfig{}
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateHTTPErrors(config); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	err = SetHTTPErrorsAction(website, config)
+	switch {
+	case errors.Is(err, errOutsideSite), errors.Is(err, errInvalidFilePath):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Error pages updated", "warnings": HTTPErrorWarnings(website, normalizeHTTPErrors(config))})
+}
+
+func GetSiteLimitsEndpoint(c *gin.Context) {
+	site := c.Param("name")
+	if !WebsiteExistsByName(site) {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	limits, err := GetSiteLimitsAction(site)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, limits)
+}
+
+func PutSiteLimitsEndpoint(c *gin.Context) {
+	site := c.Param("name")
+	limits := SiteLimits{}
+	if err := c.ShouldBindJSON(&limits); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateSiteLimits(limits); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !WebsiteExistsByName(site) {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	if err := SetSiteLimitsAction(site, limits); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Limits updated"})
+}
+
+func PostBulkEndpoint(c *gin.Context) {
+	request := BulkRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	response, err := BulkAction(request)
+	switch {
+	case errors.Is(err, errBulkRequest):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, response)
+}
+
+func GetSiteMetadataEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	metadata, err := GetSiteMetadataAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, metadata)
+}
+
+func PutSiteMetadataEndpoint(c *gin.Context) {
+	metadata := SiteMetadata{}
+	if err := c.ShouldBindJSON(&metadata); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateSiteMetadata(metadata); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	saved, err := SetSiteMetadataAction(website, metadata)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, saved)
+}
+
+// manifestPlan parses the request body as a manifest and plans it against
+// live state. It writes the error response itself and reports false on failure.
+func manifestPlan(c *gin.Context) (Plan, bool) {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return Plan{}, false
+	}
+	manifest, err := ParseManifest(body)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return Plan{}, false
+	}
+	live, err := LiveManifestAction(manifest)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return Plan{}, false
+	}
+	return PlanManifest(manifest, live, c.Query("prune") == "true"), true
+}
+
+func PostPlanEndpoint(c *gin.Context) {
+	plan, ok := manifestPlan(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, plan)
+}
+
+func PostApplyEndpoint(c *gin.Context) {
+	plan, ok := manifestPlan(c)
+	if !ok {
+		return
+	}
+	plan = ApplyPlanAction(plan)
+	for _, action := range plan.Actions {
+		if action.Status == "failed" {
+			c.JSON(500, plan)
+			return
+		}
+	}
+	c.JSON(200, plan)
+}
+
+func GetExportEndpoint(c *gin.Context) {
+	options := ExportOptions{}
+	if err := c.ShouldBindQuery(&options); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	for _, site := range options.Sites {
+		if _, err := GetByNameAction(site); err != nil {
+			c.JSON(404, gin.H{"error": "Website not found"})
+			return
+		}
+	}
+	// Build the bundle in a temporary file so a failure can still be reported
+	file, err := os.CreateTemp("", "iis-export-*.zip")
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.Remove(file.Name())
+	info, err := ExportBundleAction(file, options)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.FileAttachment(file.Name(), fmt.Sprintf("iis-export-%s-%s.zip", info.Source, info.CreatedAt.Format("20060102-150405")))
+}
+
+func PostImportEndpoint(c *gin.Context) {
+	if err := limitUpload(c); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	upload, err := c.FormFile("bundle")
+	if uploadTooLarge(err) {
+		c.JSON(413, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(400, gin.H{"error": "bundle file is required"})
+		return
+	}
+	options := ImportOptions{}
+	if raw := c.PostForm("options"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &options); err != nil {
+			c.JSON(400, gin.H{"error": "invalid options: " + err.Error()})
+			return
+		}
+	}
+	if err := ValidateImportOptions(options); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	file, err := upload.Open()
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+	archive, err := zip.NewReader(file, upload.Size)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "bundle is not a zip archive"})
+		return
+	}
+	result, err := ImportBundleAction(archive, options)
+	switch {
+	case errors.Is(err, errImportConflict):
+		c.JSON(409, gin.H{"error": err.Error(), "conflicts": result.Skipped})
+		return
+	case errors.Is(err, errInvalidBundle):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errDeployTooLarge):
+		c.JSON(413, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for _, action := range result.Plan.Actions {
+		if action.Status == "failed" {
+			c.JSON(500, result)
+			return
+		}
+	}
+	c.JSON(200, result)
+}
+
+// readOnlyRoutes are non-GET routes that change nothing in IIS.
+var readOnlyRoutes = []string{
+	"/api/plan",
+	"/api/website/:name/rewrite/test",
+	"/api/drift/settings",
+	"/api/website/:name/baseline",
+	"/api/website/:name/health/config",
+	"/api/health/settings",
+	"/api/website/:name/recovery",
+	"/api/deploy/settings",
+	"/api/website/:name/releases/:id",
+	"/api/website/:name/releases/prune",
+	"/api/website/:name/git",
+	"/api/website/:name/webhook",
+	"/api/website/:name/hooks",
+	"/api/website/:name/files",
+	"/api/website/:name/files/upload",
+	"/api/website/:name/files/mkdir",
+	"/api/website/:name/files/move",
+	"/api/website/:name/files/rename",
+	"/api/website/:name/files/content",
+	"/api/website/:name/files/backups/:id/restore",
+	// Deliveries take their own snapshot once verified
+	"/api/hooks/:name",
+}
+
+// isWebConfig reports whether a site path names a web.config, which IIS
+// reads as configuration. Windows drops trailing dots and spaces.
+func isWebConfig(path string) bool {
+	name := path[strings.LastIndexAny(path, `/\`)+1:]
+	return strings.EqualFold(strings.TrimRight(name, ". "), "web.config")
+}
+
+// touchesWebConfig reports whether changing the entry at rel changes a
+// web.config: it names one, or is a directory with one anywhere below it.
+// Links are not followed, as moving or removing a link leaves its target.
+func touchesWebConfig(website Website, rel string) bool {
+	if isWebConfig(rel) {
+		return true
+	}
+	_, entry, err := resolveSiteEntry(website, rel)
+	if err != nil {
+		return false
+	}
+	if info, err := os.Lstat(entry); err != nil || !info.IsDir() {
+		return false
+	}
+	found := false
+	filepath.WalkDir(entry, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && isWebConfig(d.Name()) {
+			found = true
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+// snapshotFileChange snapshots before a file manager request that writes,
+// moves or removes a web.config, directly or with a directory holding one.
+// File routes are otherwise left out of SnapshotBeforeMutation.
+func snapshotFileChange(c *gin.Context, website Website, paths ...string) {
+	if !slices.ContainsFunc(paths, func(rel string) bool { return touchesWebConfig(website, rel) }) {
+		return
+	}
+	if _, err := TakeSnapshotAction(triggerMutation, c.Request.Method+" "+c.Request.URL.Path); err != nil {
+		log.Printf("snapshot before %s %s failed: %v", c.Request.Method, c.Request.URL.Path, err)
+	}
+}
+
+// SnapshotBeforeMutation snapshots the configuration before any request
+// that may change it. A failed snapshot is logged and does not block the
+// request.
+func SnapshotBeforeMutation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		mutating := c.Request.Method != "GET" && c.Request.Method != "HEAD" && c.Request.Method != "OPTIONS"
+		if mutating && route != "" && !strings.HasPrefix(route, "/api/snapshots") && !slices.Contains(readOnlyRoutes, route) {
+			if _, err := TakeSnapshotAction(triggerMutation, c.Request.Method+" "+c.Request.URL.Path); err != nil {
+				log.Printf("snapshot before %s %s failed: %v", c.Request.Method, c.Request.URL.Path, err)
+			}
+		}
+		c.Next()
+	}
+}
+
+func GetSnapshotsEndpoint(c *gin.Context) {
+	snapshots, err := GetSnapshotsAction()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, snapshots)
+}
+
+func PostSnapshotEndpoint(c *gin.Context) {
+	request := SnapshotRequest{}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	snapshot, err := TakeSnapshotAction(triggerManual, request.Reason)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, snapshot)
+}
+
+func GetSnapshotSettingsEndpoint(c *gin.Context) {
+	settings, err := GetSnapshotSettingsAction()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, settings)
+}
+
+func PutSnapshotSettingsEndpoint(c *gin.Context) {
+	settings := SnapshotSettings{}
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateSnapshotSettings(settings); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := SetSnapshotSettingsAction(settings); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Snapshot settings updated"})
+}
+
+func GetSnapshotEndpoint(c *gin.Context) {
+	snapshot, found, err := GetSnapshotAction(c.Param("id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(404, gin.H{"error": "Snapshot not found"})
+		return
+	}
+	c.JSON(200, snapshot)
+}
+
+func DeleteSnapshotEndpoint(c *gin.Context) {
+	_, found, err := GetSnapshotAction(c.Param("id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(404, gin.H{"error": "Snapshot not found"})
+		return
+	}
+	if err := DeleteSnapshotAction(c.Param("id")); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Snapshot deleted"})
+}
+
+func GetSnapshotDiffEndpoint(c *gin.Context) {
+	to := c.DefaultQuery("to", snapshotLive)
+	for _, id := range []string{c.Param("id"), to} {
+		if id == snapshotLive {
+			continue
+		}
+		if _, found, err := GetSnapshotAction(id); err != nil || !found {
+			c.JSON(404, gin.H{"error": "Snapshot not found"})
+			return
+		}
+	}
+	diff, err := DiffSnapshotsAction(c.Param("id"), to)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, diff)
+}
+
+func PostSnapshotRestoreEndpoint(c *gin.Context) {
+	request := SnapshotRestoreRequest{}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	snapshot, found, err := GetSnapshotAction(c.Param("id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(404, gin.H{"error": "Snapshot not found"})
+		return
+	}
+	if request.Site != "" && !slices.ContainsFunc(snapshot.Sites, func(s SnapshotSite) bool { return strings.EqualFold(s.Name, request.Site) }) {
+		c.JSON(404, gin.H{"error": "Website not found in snapshot"})
+		return
+	}
+	before, err := RestoreSnapshotAction(snapshot.ID, request.Site)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error(), "before": before.ID})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Snapshot restored", "before": before.ID})
+}
+
+func GetDriftEndpoint(c *gin.Context) {
+	var report DriftReport
+	var err error
+	if c.Query("refresh") == "true" {
+		report, err = DriftCheckAction()
+	} else {
+		report, err = LatestDriftAction()
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, report)
+}
+
+func GetDriftSettingsEndpoint(c *gin.Context) {
+	settings, err := GetDriftSettingsAction()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, settings)
+}
+
+func PutDriftSettingsEndpoint(c *gin.Context) {
+	settings := DriftSettings{}
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateDriftSettings(settings); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := SetDriftSettingsAction(settings); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Drift settings updated"})
+}
+
+func GetBaselineEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	baseline, found, err := GetBaselineAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(404, gin.H{"error": "No baseline recorded"})
+		return
+	}
+	c.JSON(200, baseline)
+}
+
+func PostBaselineEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	baseline, err := RecordBaselineAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, baseline)
+}
+
+func DeleteBaselineEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	if err := DeleteBaselineAction(website); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Baseline deleted"})
+}
+
+func PostRevertBaselineEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	baseline, found, err := GetBaselineAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(404, gin.H{"error": "No baseline recorded"})
+		return
+	}
+	reverted, kept, err := RevertToBaselineAction(website, baseline)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error(), "changes": reverted, "notReverted": kept})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Website reverted to baseline", "changes": reverted, "notReverted": kept})
+}
+
+func GetHealthEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	if c.Query("refresh") == "true" {
+		result, err := CheckHealthAction(website)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, result)
+		return
+	}
+	result, found, err := GetHealthAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(404, gin.H{"error": "Website has not been checked yet"})
+		return
+	}
+	c.JSON(200, result)
+}
+
+func GetHealthHistoryEndpoint(c *gin.Context) {
+	query := HealthHistoryQuery{}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if query.Limit == 0 {
+		query.Limit = 100
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	history, err := GetHealthHistoryAction(website, query.From, query.To, query.Limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, history)
+}
+
+func GetHealthConfigEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	config, err := GetHealthConfigAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, config)
+}
+
+func PutHealthConfigEndpoint(c *gin.Context) {
+	config := HealthCheckConfig{}
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateHealthConfig(normalizeHealthConfig(config)); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	saved, err := SetHealthConfigAction(website, config)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, saved)
+}
+
+func GetHealthSettingsEndpoint(c *gin.Context) {
+	settings, err := GetHealthSettingsAction()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, settings)
+}
+
+func PutHealthSettingsEndpoint(c *gin.Context) {
+	settings := HealthSettings{}
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateHealthSettings(settings); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := SetHealthSettingsAction(settings); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Health settings updated"})
+}
+
+func GetRecoveryEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	status, err := GetRecoveryStatusAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, status)
+}
+
+func PutRecoveryEndpoint(c *gin.Context) {
+	policy := RecoveryPolicy{}
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateRecoveryPolicy(normalizeRecoveryPolicy(policy)); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	saved, err := SetRecoveryPolicyAction(website, policy)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, saved)
+}
+
+func GetSiteRecoveryEventsEndpoint(c *gin.Context) {
+	query := RecoveryEventsQuery{}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	events, err := GetRecoveryEventsAction(website, query.Limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, events)
+}
+
+func GetRecoveryEventsEndpoint(c *gin.Context) {
+	query := RecoveryEventsQuery{}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	events, err := GetRecoveryEventsAction(Website{}, query.Limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, events)
+}
+
+func GetUptimeEndpoint(c *gin.Context) {
+	query := UptimeQuery{}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateUptimeQuery(&query); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	report, err := UptimeAction(website, query)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if query.Format != "csv" {
+		c.JSON(200, report)
+		return
+	}
+	kind := query.Report
+	if kind == "" {
+		kind = "monthly"
+	}
+	filename := fmt.Sprintf("uptime-%s-%s-%s-%s.csv", website.Name, kind, query.From.Format("20060102"), query.To.Format("20060102"))
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(200)
+	if err := WriteUptimeCSV(c.Writer, report, kind); err != nil {
+		log.Printf("failed to write uptime report for %s: %v", website.Name, err)
+	}
+}
+
+func GetMaintenanceEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	status, err := GetMaintenanceAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, status)
+}
+
+func PostMaintenanceEndpoint(c *gin.Context) {
+	request := MaintenanceRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateMaintenanceRequest(request, time.Now()); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	status, err := EnterMaintenanceAction(website, request)
+	switch {
+	case errors.Is(err, errInMaintenance):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, status)
+}
+
+func DeleteMaintenanceEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	err = ExitMaintenanceAction(website)
+	switch {
+	case errors.Is(err, errNotInMaintenance), errors.Is(err, errMaintenanceEdited):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Maintenance ended"})
+}
+
+func PostDeployEndpoint(c *gin.Context) {
+	if err := limitUpload(c); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	upload, err := c.FormFile("archive")
+	if uploadTooLarge(err) {
+		c.JSON(413, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(400, gin.H{"error": "archive file is required"})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	file, err := upload.Open()
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+	deployment, err := DeployArchiveAction(website, upload.Filename, file, upload.Size, c.Query("keepConfig") != "false")
+	switch {
+	case errors.Is(err, errDeployInMaintenance):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errDeployTooLarge):
+		c.JSON(413, gin.H{"error": err.Error(), "deployment": deployment})
+		return
+	case errors.Is(err, errInvalidArchive):
+		c.JSON(400, gin.H{"error": err.Error(), "deployment": deployment})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error(), "deployment": deployment})
+		return
+	}
+	c.JSON(200, deployment)
+}
+
+// limitUpload caps the request body at MaxUploadMB, plus room for the
+// multipart framing, before gin spools the form to disk.
+func limitUpload(c *gin.Context) error {
+	settings, err := GetDeploySettingsAction()
+	if err != nil {
+		return err
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(settings.MaxUploadMB)<<20+multipartSlack)
+	return nil
+}
+
+func uploadTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+func GetDeploySettingsEndpoint(c *gin.Context) {
+	settings, err := GetDeploySettingsAction()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, settings)
+}
+
+func PutDeploySettingsEndpoint(c *gin.Context) {
+	settings := DeploySettings{}
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateDeploySettings(settings); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := SetDeploySettingsAction(settings); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Deploy settings updated"})
+}
+
+func GetDeploymentsEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	deployments, err := GetDeploymentsAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, deployments)
+}
+
+func GetReleasesEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	releases, err := GetReleasesAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, releases)
+}
+
+func PostRollbackEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	rollback, err := RollbackAction(website, c.Param("id"))
+	switch {
+	case errors.Is(err, errReleaseNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errReleaseActive), errors.Is(err, errDeployInMaintenance):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error(), "deployment": rollback})
+		return
+	}
+	c.JSON(200, rollback)
+}
+
+func DeleteReleaseEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	err = DeleteReleaseAction(website, c.Param("id"))
+	switch {
+	case errors.Is(err, errReleaseNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errReleaseActive):
+		c.JSON(409, gin.H{"error": "the active release cannot be deleted"})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Release deleted"})
+}
+
+func PostPruneReleasesEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	pruned, err := PruneReleasesAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error(), "pruned": pruned})
+		return
+	}
+	c.JSON(200, gin.H{"pruned": pruned})
+}
+
+func GetGitSourceEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	source, found, err := GetGitSourceAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(404, gin.H{"error": errGitNotLinked.Error()})
+		return
+	}
+	c.JSON(200, source.Public())
+}
+
+func PutGitSourceEndpoint(c *gin.Context) {
+	source := GitSource{}
+	if err := c.ShouldBindJSON(&source); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateGitSource(source); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	saved, err := SetGitSourceAction(website, source)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, saved)
+}
+
+func DeleteGitSourceEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	if err := DeleteGitSourceAction(website); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Git repository unlinked"})
+}
+
+func PostGitDeployEndpoint(c *gin.Context) {
+	request := GitDeployRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Ref != "" {
+		if err := ValidateGitRef(request.Ref); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	deployment, err := GitDeployAction(website, request.Ref, c.Query("keepConfig") != "false")
+	switch {
+	case errors.Is(err, errGitNotLinked), errors.Is(err, errDeployInMaintenance):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errDeployTooLarge):
+		c.JSON(413, gin.H{"error": err.Error(), "deployment": deployment})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error(), "deployment": deployment})
+		return
+	}
+	c.JSON(200, deployment)
+}
+
+func GetWebhookConfigEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	config, _, err := GetWebhookConfigAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, config.Public())
+}
+
+func PutWebhookConfigEndpoint(c *gin.Context) {
+	config := WebhookConfig{}
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateWebhookConfig(config); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	saved, err := SetWebhookConfigAction(website, config)
+	switch {
+	case errors.Is(err, errWebhookNoSecret):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, saved)
+}
+
+func DeleteWebhookConfigEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	if err := DeleteWebhookConfigAction(website); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Webhook removed"})
+}
+
+func GetWebhookDeliveriesEndpoint(c *gin.Context) {
+	query := WebhookDeliveriesQuery{}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	deliveries, err := GetWebhookDeliveriesAction(website, query.Limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, deliveries)
+}
+
+// PostWebhookEndpoint receives CI deliveries. It answers 202 once a
+// deployment is started; the delivery log has its outcome.
+func PostWebhookEndpoint(c *gin.Context) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, webhookMaxBody+1))
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if len(body) > webhookMaxBody {
+		c.JSON(413, gin.H{"error": "payload is larger than 1 MB"})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	delivery, err := ReceiveWebhookAction(website, c.Request.Header, body, c.ClientIP())
+	switch {
+	case errors.Is(err, errWebhookDisabled):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errWebhookUnauthorized):
+		c.JSON(401, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errWebhookReplay), errors.Is(err, errGitNotLinked):
+		c.JSON(409, gin.H{"error": err.Error(), "delivery": delivery})
+		return
+	case errors.Is(err, errWebhookPayload):
+		c.JSON(400, gin.H{"error": err.Error(), "delivery": delivery})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if delivery.Status == webhookIgnored {
+		c.JSON(200, delivery)
+		return
+	}
+	c.JSON(202, delivery)
+}
+
+func GetDeployHooksEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	hooks, err := GetDeployHooksAction(website)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, hooks)
+}
+
+func PutDeployHooksEndpoint(c *gin.Context) {
+	hooks := DeployHooks{}
+	if err := c.ShouldBindJSON(&hooks); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateDeployHooks(hooks.Hooks); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	saved, err := SetDeployHooksAction(website, hooks)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, saved)
+}
+
+func GetSlotsEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	status, err := GetSlotsStatusAction(website)
+	switch {
+	case errors.Is(err, errSlotsNotEnabled):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, status)
+}
+
+func PostSlotsEndpoint(c *gin.Context) {
+	request := SlotsRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateSlotsRequest(request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	config, err := EnableSlotsAction(website, request)
+	switch {
+	case errors.Is(err, errSlotsEnabled):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, config)
+}
+
+func DeleteSlotsEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	err = DisableSlotsAction(website)
+	switch {
+	case errors.Is(err, errSlotsNotEnabled):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errSlotsBlueActive):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Slots disabled"})
+}
+
+func PostSlotSwapEndpoint(c *gin.Context) {
+	request := SlotSwapRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	swap, err := SwapSlotsAction(website, request.Force, swapManual, "")
+	switch {
+	case errors.Is(err, errSlotsNotEnabled):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errSlotUnhealthy):
+		c.JSON(409, gin.H{"error": err.Error(), "swap": swap})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error(), "swap": swap})
+		return
+	}
+	c.JSON(200, swap)
+}
+
+func PostSlotDeployEndpoint(c *gin.Context) {
+	if err := limitUpload(c); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	upload, err := c.FormFile("archive")
+	if uploadTooLarge(err) {
+		c.JSON(413, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(400, gin.H{"error": "archive file is required"})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	file, err := upload.Open()
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+	deployment, swap, err := SlotDeployAction(website, upload.Filename, file, upload.Size, c.Query("keepConfig") != "false", c.Query("swap") != "false")
+	switch {
+	case errors.Is(err, errSlotsNotEnabled):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errDeployInMaintenance):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errSlotUnhealthy):
+		c.JSON(409, gin.H{"error": err.Error(), "deployment": deployment, "swap": swap})
+		return
+	case errors.Is(err, errDeployTooLarge):
+		c.JSON(413, gin.H{"error": err.Error(), "deployment": deployment})
+		return
+	case errors.Is(err, errInvalidArchive):
+		c.JSON(400, gin.H{"error": err.Error(), "deployment": deployment})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": err.Error(), "deployment": deployment, "swap": swap})
+		return
+	}
+	c.JSON(200, gin.H{"deployment": deployment, "swap": swap})
+}
+
+// respondFileError maps the file manager's errors onto status codes.
+func respondFileError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errFileNotFound), errors.Is(err, errBackupNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	case errors.Is(err, errOutsideSite):
+		c.JSON(403, gin.H{"error": err.Error()})
+	case errors.Is(err, errFileExists), errors.Is(err, errDirNotEmpty):
+		c.JSON(409, gin.H{"error": err.Error()})
+	case errors.Is(err, errFileChanged):
+		c.JSON(412, gin.H{"error": err.Error()})
+	case errors.Is(err, errPreconditionRequired):
+		c.JSON(428, gin.H{"error": err.Error()})
+	case errors.Is(err, errFileTooLarge):
+		c.JSON(413, gin.H{"error": err.Error()})
+	case errors.Is(err, errBinaryFile):
+		c.JSON(415, gin.H{"error": err.Error()})
+	case errors.Is(err, errFileRange):
+		c.JSON(416, gin.H{"error": err.Error()})
+	case errors.Is(err, errInvalidFilePath), errors.Is(err, errFileEncoding):
+		c.JSON(400, gin.H{"error": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": err.Error()})
+	}
+}
+
+func GetFilesEndpoint(c *gin.Context) {
+	query := FilesQuery{}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	files, err := ListFilesAction(website, query.Path)
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+	c.JSON(200, files)
+}
+
+func GetFileDownloadEndpoint(c *gin.Context) {
+	query := FilesQuery{}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	file, info, err := OpenSiteFileAction(website, query.Path)
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+	defer file.Close()
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), file)
+}
+
+func PostFileUploadEndpoint(c *gin.Context) {
+	query := FilesQuery{}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	if err := limitUpload(c); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	form, err := c.MultipartForm()
+	if uploadTooLarge(err) {
+		c.JSON(413, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil || len(form.File["file"]) == 0 {
+		c.JSON(400, gin.H{"error": "file is required"})
+		return
+	}
+	names := []string{}
+	for _, upload := range form.File["file"] {
+		names = append(names, query.Path+"/"+upload.Filename)
+	}
+	snapshotFileChange(c, website, names...)
+	uploaded := []DirFile{}
+	for _, upload := range form.File["file"] {
+		file, err := upload.Open()
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		saved, err := UploadFileAction(website, query.Path, upload.Filename, file, upload.Size, query.Overwrite)
+		file.Close()
+		if err != nil {
+			respondFileError(c, err)
+			return
+		}
+		uploaded = append(uploaded, saved)
+	}
+	c.JSON(201, uploaded)
+}
+
+func PostMakeDirectoryEndpoint(c *gin.Context) {
+	request := MakeDirectoryRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	dir, err := MakeDirectoryAction(website, request.Path)
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+	c.JSON(201, dir)
+}
+
+func PostMoveFileEndpoint(c *gin.Context) {
+	request := MoveFileRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	snapshotFileChange(c, website, request.From, request.To)
+	moved, err := MoveFileAction(website, request.From, request.To, request.Overwrite)
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+	c.JSON(200, moved)
+}
+
+func PostRenameFileEndpoint(c *gin.Context) {
+	request := RenameFileRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	snapshotFileChange(c, website, request.Path, path.Join(path.Dir(strings.ReplaceAll(request.Path, `\`, "/")), request.Name))
+	renamed, err := RenameFileAction(website, request.Path, request.Name, request.Overwrite)
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+	c.JSON(200, renamed)
+}
+
+func DeleteFileEndpoint(c *gin.Context) {
+	query := FilesQuery{}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	snapshotFileChange(c, website, query.Path)
+	if err := DeleteFileAction(website, query.Path, query.Recursive); err != nil {
+		respondFileError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"message": "File deleted"})
+}
+
+func GetFileContentEndpoint(c *gin.Context) {
+	query := FileContentQuery{}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	content, err := ReadFileContentAction(website, query.Path, query.Offset, query.Length)
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+	c.Header("ETag", content.ETag)
+	c.JSON(200, content)
+}
+
+func PutFileContentEndpoint(c *gin.Context) {
+	request := SaveFileRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if request.ETag == "" {
+		request.ETag = c.GetHeader("If-Match")
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	snapshotFileChange(c, website, request.Path)
+	saved, err := SaveFileContentAction(website, request)
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+	c.Header("ETag", saved.ETag)
+	c.JSON(200, saved)
+}
+
+func GetFileBackupsEndpoint(c *gin.Context) {
+	query := FilesQuery{}
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	backups, err := GetFileBackupsAction(website, query.Path)
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+	c.JSON(200, backups)
+}
+
+func PostRestoreFileBackupEndpoint(c *gin.Context) {
+	website, err := GetByNameAction(c.Param("name"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Website not found"})
+		return
+	}
+	backup, err := GetFileBackupAction(website, c.Param("id"))
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+	snapshotFileChange(c, website, backup.Path)
+	saved, err := RestoreFileBackupAction(website, c.Param("id"))
+	if err != nil {
+		respondFileError(c, err)
+		return
+	}
+	c.Header("ETag", saved.ETag)
+	c.JSON(200, saved)
+}
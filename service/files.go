@@ -0,0 +1,487 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// maxLinkHops bounds how many symlinks and junctions one path may pass
+	// through, so link cycles fail instead of spinning
+	maxLinkHops    = 40
+	fileTimeFormat = "2006-01-02 15:04:05"
+	// unknownPermission is reported when a file's ACL cannot be read
+	unknownPermission = "Unknown"
+)
+
+var (
+	errFileNotFound    = errors.New("file not found")
+	errFileExists      = errors.New("file already exists")
+	errOutsideSite     = errors.New("path is outside the site directory")
+	errInvalidFilePath = errors.New("invalid path")
+	errDirNotEmpty     = errors.New("directory is not empty")
+	errFileTooLarge    = errors.New("file exceeds the upload limit")
+)
+
+// splitPath breaks a path below its volume into its elements.
+func splitPath(p string) []string {
+	return strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == filepath.Separator })
+}
+
+// resolvePath follows every symlink and junction along an absolute path,
+// the way the file system will when the path is opened. Elements past the
+// first one that does not exist are kept as they are.
+func resolvePath(p string) (string, error) {
+	volume := filepath.VolumeName(p)
+	resolved := volume + string(filepath.Separator)
+	pending := splitPath(p[len(volume):])
+	hops := 0
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+		switch part {
+		case ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		info, err := os.Lstat(next)
+		if errors.Is(err, fs.ErrNotExist) {
+			return filepath.Join(append([]string{next}, pending...)...), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		// Junctions show up as irregular files rather than symlinks
+		if info.Mode()&(fs.ModeSymlink|fs.ModeIrregular) == 0 {
+			resolved = next
+			continue
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			if info.Mode()&fs.ModeSymlink != 0 {
+				return "", err
+			}
+			// Some other reparse point, such as a deduplicated file
+			resolved = next
+			continue
+		}
+		if hops++; hops > maxLinkHops {
+			return "", fmt.Errorf("too many links in %s", p)
+		}
+		if targetVolume := filepath.VolumeName(target); filepath.IsAbs(target) {
+			resolved = targetVolume + string(filepath.Separator)
+			target = target[len(targetVolume):]
+		}
+		pending = append(splitPath(target), pending...)
+	}
+	return resolved, nil
+}
+
+// within reports whether path is root or lies below it. On Windows
+// filepath.Rel already compares without regard to case.
+func within(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// siteFilePath cleans a site-relative path for the file manager. An empty
+// path, "/" or "." is the site root and comes back as "".
+func siteFilePath(rel string) (string, error) {
+	if trimmed := strings.Trim(rel, `/\`); trimmed == "" || trimmed == "." {
+		return "", nil
+	}
+	clean, err := cleanSitePath(rel)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errOutsideSite, rel)
+	}
+	// Colons, alternate data streams and device names such as NUL
+	if !filepath.IsLocal(clean) {
+		return "", fmt.Errorf("%w: %s", errInvalidFilePath, rel)
+	}
+	return clean, nil
+}
+
+// siteRoot resolves the site's physical directory, links included.
+func siteRoot(website Website) (string, error) {
+	root, err := filepath.Abs(expandPhysicalPath(website.PhysicalPath))
+	if err != nil {
+		return "", err
+	}
+	return resolvePath(root)
+}
+
+// resolveSitePath maps a site-relative path to the file it opens, refusing
+// any path that ends up outside the site once links are followed.
+func resolveSitePath(website Website, rel string) (string, string, error) {
+	clean, err := siteFilePath(rel)
+	if err != nil {
+		return "", "", err
+	}
+	root, err := siteRoot(website)
+	if err != nil {
+		return "", "", err
+	}
+	resolved, err := resolvePath(filepath.Join(root, clean))
+	if err != nil {
+		return "", "", err
+	}
+	if !within(root, resolved) {
+		return "", "", fmt.Errorf("%w: %s", errOutsideSite, rel)
+	}
+	return root, resolved, nil
+}
+
+// resolveSiteEntry is resolveSitePath for operations on the entry itself:
+// the parent directory is resolved but a link at the end is left alone, so
+// deleting or renaming a link never touches what it points to.
+func resolveSiteEntry(website Website, rel string) (string, string, error) {
+	clean, err := siteFilePath(rel)
+	if err != nil {
+		return "", "", err
+	}
+	if clean == "" {
+		return "", "", fmt.Errorf("%w: the site directory itself cannot be changed", errInvalidFilePath)
+	}
+	root, parent, err := resolveSitePath(website, filepath.Dir(clean))
+	if err != nil {
+		return "", "", err
+	}
+	return root, filepath.Join(parent, filepath.Base(clean)), nil
+}
+
+// validFileName checks a single name given for an upload or rename.
+func validFileName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || !filepath.IsLocal(name) {
+		return fmt.Errorf("%w: %q is not a valid file name", errInvalidFilePath, name)
+	}
+	return nil
+}
+
+// fileError turns not-exist and exists errors from the os package into the
+// file manager's own.
+func fileError(rel string, err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("%w: %s", errFileNotFound, rel)
+	case errors.Is(err, fs.ErrExist):
+		return fmt.Errorf("%w: %s", errFileExists, rel)
+	}
+	return err
+}
+
+func dirFile(root string, path string, info fs.FileInfo) DirFile {
+	file := DirFile{
+		Name:       info.Name(),
+		Size:       info.Size(),
+		IsDir:      info.IsDir(),
+		ModTime:    info.ModTime().Format(fileTimeFormat),
+		Permission: unknownPermission,
+	}
+	if rel, err := filepath.Rel(root, path); err == nil {
+		file.Path = filepath.ToSlash(rel)
+	}
+	if file.IsDir {
+		file.Size = 0
+	}
+	return file
+}
+
+// fileRights reads the rights of the first access rule on each item the
+// PowerShell expression yields, by name, as the file manager has always
+// shown them. Items whose ACL cannot be read are left out.
+func fileRights(items string) map[string]string {
+	ps := fmt.Sprintf(`$rows = @(%s | ForEach-Object {
+	$rule = (Get-Acl -LiteralPath $_.FullName -ErrorAction SilentlyContinue).Access | Select-Object -First 1
+	if ($rule) { [PSCustomObject]@{ name = $_.Name; rights = $rule.FileSystemRights.ToString() } }
+})
+ConvertTo-Json -InputObject $rows -Compress`, items)
+	rights := map[string]string{}
+	out, err := runPowerShell(ps)
+	if err != nil {
+		return rights
+	}
+	rows, err := decodePSList[struct {
+		Name   string `json:"name"`
+		Rights string `json:"rights"`
+	}](out)
+	if err != nil {
+		return rights
+	}
+	for _, row := range rows {
+		rights[row.Name] = row.Rights
+	}
+	return rights
+}
+
+// siteFile describes a single file or directory the file manager just
+// wrote, with its permission.
+func siteFile(root string, path string, info fs.FileInfo) DirFile {
+	file := dirFile(root, path, info)
+	if rights, ok := fileRights("Get-Item -Force -LiteralPath " + psQuote(path))[filepath.Base(path)]; ok {
+		file.Permission = rights
+	}
+	return file
+}
+
+// ListFilesAction lists a directory in the site, directories first. Links
+// are listed with what they point to; following one out of the site is
+// refused when it is opened.
+func ListFilesAction(website Website, rel string) ([]DirFile, error) {
+	root, dir, err := resolveSitePath(website, rel)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fileError(rel, err)
+	}
+	// Paths are reported from where the caller asked, not the link target
+	clean, _ := siteFilePath(rel)
+	shown := filepath.Join(root, clean)
+	rights := fileRights("Get-ChildItem -Force -LiteralPath " + psQuote(dir))
+	files := []DirFile{}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			// A dangling link still shows up, as itself
+			if info, err = entry.Info(); err != nil {
+				continue
+			}
+		}
+		file := dirFile(root, filepath.Join(shown, entry.Name()), info)
+		file.Name = entry.Name()
+		file.IsLink = entry.Type()&(fs.ModeSymlink|fs.ModeIrregular) != 0
+		if permission, ok := rights[entry.Name()]; ok {
+			file.Permission = permission
+		}
+		files = append(files, file)
+	}
+	slices.SortFunc(files, func(a, b DirFile) int {
+		if a.IsDir != b.IsDir {
+			if a.IsDir {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	return files, nil
+}
+
+// OpenSiteFileAction opens a file in the site for download.
+func OpenSiteFileAction(website Website, rel string) (*os.File, fs.FileInfo, error) {
+	_, path, err := resolveSitePath(website, rel)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fileError(rel, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, nil, fmt.Errorf("%w: %s is a directory", errInvalidFilePath, rel)
+	}
+	return file, info, nil
+}
+
+// UploadFileAction writes an uploaded file into a directory of the site.
+// The content lands in a temporary file next to the target first, so a
+// failed upload never leaves half a file behind.
+func UploadFileAction(website Website, dirRel string, name string, content io.Reader, size int64, overwrite bool) (DirFile, error) {
+	if err := validFileName(name); err != nil {
+		return DirFile{}, err
+	}
+	settings, err := GetDeploySettingsAction()
+	if err != nil {
+		return DirFile{}, err
+	}
+	limit := int64(settings.MaxUploadMB) << 20
+	if size > limit {
+		return DirFile{}, fmt.Errorf("%w: %s is larger than %d MB", errFileTooLarge, name, settings.MaxUploadMB)
+	}
+	root, dir, err := resolveSitePath(website, dirRel)
+	if err != nil {
+		return DirFile{}, err
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return DirFile{}, fileError(dirRel, err)
+	} else if !info.IsDir() {
+		return DirFile{}, fmt.Errorf("%w: %s is not a directory", errInvalidFilePath, dirRel)
+	}
+	target := filepath.Join(dir, name)
+	if err := checkUploadTarget(target, name, overwrite); err != nil {
+		return DirFile{}, err
+	}
+
+	temp, err := os.CreateTemp(dir, "."+name+".upload-*")
+	if err != nil {
+		return DirFile{}, err
+	}
+	defer os.Remove(temp.Name())
+	written, err := io.Copy(temp, io.LimitReader(content, limit+1))
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return DirFile{}, fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	if written > limit {
+		return DirFile{}, fmt.Errorf("%w: %s is larger than %d MB", errFileTooLarge, name, settings.MaxUploadMB)
+	}
+	// Checked again: the target may have appeared during the upload
+	if err := checkUploadTarget(target, name, overwrite); err != nil {
+		return DirFile{}, err
+	}
+	if err := os.Rename(temp.Name(), target); err != nil {
+		return DirFile{}, fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return DirFile{}, err
+	}
+	return siteFile(root, target, info), nil
+}
+
+// checkUploadTarget refuses to replace a directory or link, or any file
+// unless overwrite is set.
+func checkUploadTarget(target string, name string, overwrite bool) error {
+	info, err := os.Lstat(target)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return err
+	case !info.Mode().IsRegular():
+		return fmt.Errorf("%w: %s is not a regular file", errFileExists, name)
+	case !overwrite:
+		return fmt.Errorf("%w: %s", errFileExists, name)
+	}
+	return nil
+}
+
+// MakeDirectoryAction creates a directory, and any missing parents, in the
+// site.
+func MakeDirectoryAction(website Website, rel string) (DirFile, error) {
+	root, path, err := resolveSitePath(website, rel)
+	if err != nil {
+		return DirFile{}, err
+	}
+	if path == root {
+		return DirFile{}, fmt.Errorf("%w: a directory path is required", errInvalidFilePath)
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return DirFile{}, fmt.Errorf("%w: %s", errFileExists, rel)
+	}
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return DirFile{}, fileError(rel, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return DirFile{}, err
+	}
+	return siteFile(root, path, info), nil
+}
+
+// MoveFileAction moves or renames a file or directory within the site.
+// A file at the destination is only replaced with overwrite set, and
+// directories are never replaced.
+func MoveFileAction(website Website, fromRel string, toRel string, overwrite bool) (DirFile, error) {
+	root, from, err := resolveSiteEntry(website, fromRel)
+	if err != nil {
+		return DirFile{}, err
+	}
+	_, to, err := resolveSiteEntry(website, toRel)
+	if err != nil {
+		return DirFile{}, err
+	}
+	source, err := os.Lstat(from)
+	if err != nil {
+		return DirFile{}, fileError(fromRel, err)
+	}
+	if from == to {
+		return DirFile{}, fmt.Errorf("%w: %s is already at %s", errInvalidFilePath, fromRel, toRel)
+	}
+	if source.IsDir() && within(from, to) {
+		return DirFile{}, fmt.Errorf("%w: cannot move %s into itself", errInvalidFilePath, fromRel)
+	}
+	if dest, err := os.Lstat(to); err == nil {
+		// Renaming the case of a name on Windows finds the source itself
+		if !os.SameFile(source, dest) {
+			if dest.IsDir() || source.IsDir() || !overwrite {
+				return DirFile{}, fmt.Errorf("%w: %s", errFileExists, toRel)
+			}
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return DirFile{}, err
+	}
+	if info, err := os.Stat(filepath.Dir(to)); err != nil {
+		return DirFile{}, fileError(filepath.ToSlash(filepath.Dir(toRel)), err)
+	} else if !info.IsDir() {
+		return DirFile{}, fmt.Errorf("%w: %s is not a directory", errInvalidFilePath, filepath.ToSlash(filepath.Dir(toRel)))
+	}
+	if err := os.Rename(from, to); err != nil {
+		return DirFile{}, fmt.Errorf("failed to move %s to %s: %v", fromRel, toRel, err)
+	}
+	info, err := os.Lstat(to)
+	if err != nil {
+		return DirFile{}, err
+	}
+	return siteFile(root, to, info), nil
+}
+
+// RenameFileAction renames a file or directory in place.
+func RenameFileAction(website Website, rel string, name string, overwrite bool) (DirFile, error) {
+	if err := validFileName(name); err != nil {
+		return DirFile{}, err
+	}
+	clean, err := siteFilePath(rel)
+	if err != nil {
+		return DirFile{}, err
+	}
+	return MoveFileAction(website, rel, filepath.Join(filepath.Dir(clean), name), overwrite)
+}
+
+// DeleteFileAction removes a file, link or directory from the site. Links
+// are removed without touching what they point to, and a directory with
+// anything in it needs recursive.
+func DeleteFileAction(website Website, rel string, recursive bool) error {
+	_, path, err := resolveSiteEntry(website, rel)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fileError(rel, err)
+	}
+	if !info.IsDir() {
+		return os.Remove(path)
+	}
+	if !recursive {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return fmt.Errorf("%w: %s", errDirNotEmpty, rel)
+		}
+		return os.Remove(path)
+	}
+	// RemoveAll does not follow links, so nothing outside the site goes
+	return os.RemoveAll(path)
+}
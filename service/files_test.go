@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestWithin(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "sites", "shop")
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{root, true},
+		{filepath.Join(root, "index.html"), true},
+		{filepath.Join(root, "..", "shop", "css"), true},
+		{filepath.Join(root, "..dots"), true},
+		{filepath.Dir(root), false},
+		{filepath.Join(root, "..", "blog"), false},
+		{root + "-staging", false},
+	}
+	for _, test := range tests {
+		if got := within(root, test.path); got != test.ok {
+			t.Errorf("%s: expected %v, got %v", test.path, test.ok, got)
+		}
+	}
+}
+
+// linkedSite lays out a site with links inside it that point back in and
+// out, next to a secret directory it must not reach. The site itself is
+// configured through a link, as IIS sites sometimes are.
+func linkedSite(t *testing.T) (site Website, root string, secret string) {
+	base := t.TempDir()
+	root = filepath.Join(base, "site")
+	secret = filepath.Join(base, "secret")
+	for _, dir := range []string{filepath.Join(root, "sub"), secret} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, content := range map[string]string{filepath.Join(root, "a.txt"): "a", filepath.Join(secret, "pw.txt"): "pw"} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		filepath.Join(root, "escape"):       secret,
+		filepath.Join(root, "relup"):        filepath.Join("..", "secret"),
+		filepath.Join(root, "sub", "deep"):  filepath.Join("..", "..", "secret"),
+		filepath.Join(root, "inside"):       "sub",
+		filepath.Join(root, "loop"):         "loop",
+		filepath.Join(base, "site-current"): root,
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("cannot create links here: %v", err)
+		}
+	}
+	// Temporary directories may themselves sit behind a link
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Website{ID: 9, Name: "shop", PhysicalPath: filepath.Join(base, "site-current")}, real, secret
+}
+
+func TestResolveSitePath(t *testing.T) {
+	site, root, _ := linkedSite(t)
+	tests := []struct {
+		name    string
+		rel     string
+		want    string
+		err     error
+		windows bool
+	}{
+		{"site root", "", ".", nil, false},
+		{"file", "a.txt", "a.txt", nil, false},
+		{"parent that stays inside", "sub/../a.txt", "a.txt", nil, false},
+		{"link inside the site", "inside/new.txt", "sub/new.txt", nil, false},
+		{"leading slash is the site root", "/etc/passwd", "etc/passwd", nil, false},
+		{"parent", "../secret", "", errOutsideSite, false},
+		{"nested parent", "sub/../../secret/pw.txt", "", errOutsideSite, false},
+		{"backslash parent", `..\secret`, "", errOutsideSite, false},
+		{"absolute link out", "escape", "", errOutsideSite, false},
+		{"through absolute link out", "escape/pw.txt", "", errOutsideSite, false},
+		{"relative link out", "relup/pw.txt", "", errOutsideSite, false},
+		{"nested link out", "sub/deep/pw.txt", "", errOutsideSite, false},
+		{"drive path", `C:\Windows\win.ini`, "", errOutsideSite, true},
+		{"device name", "NUL", "", errInvalidFilePath, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.windows && runtime.GOOS != "windows" {
+				t.Skip("only a drive path or device name on Windows")
+			}
+			gotRoot, path, err := resolveSitePath(site, test.rel)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if gotRoot != root {
+				t.Fatalf("expected root %s, got %s", root, gotRoot)
+			}
+			if want := filepath.Join(root, filepath.FromSlash(test.want)); path != want {
+				t.Fatalf("expected %s, got %s", want, path)
+			}
+		})
+	}
+	if _, _, err := resolveSitePath(site, "loop/x"); err == nil || errors.Is(err, errOutsideSite) {
+		t.Fatalf("expected a link cycle to fail on its own, got %v", err)
+	}
+
+	// A link itself can be named, as long as what holds it is inside
+	if _, path, err := resolveSiteEntry(site, "escape"); err != nil || path != filepath.Join(root, "escape") {
+		t.Fatalf("expected the link itself, got %s %v", path, err)
+	}
+	if _, _, err := resolveSiteEntry(site, "escape/pw.txt"); !errors.Is(err, errOutsideSite) {
+		t.Fatalf("expected an entry behind a link out to be refused, got %v", err)
+	}
+	if _, _, err := resolveSiteEntry(site, "/"); !errors.Is(err, errInvalidFilePath) {
+		t.Fatalf("expected the site root to be refused, got %v", err)
+	}
+}
+
+func TestMoveFileContainment(t *testing.T) {
+	site, root, secret := linkedSite(t)
+	tests := []struct {
+		name string
+		to   string
+		err  error
+	}{
+		{"parent", "../moved.txt", errOutsideSite},
+		{"nested parent", "sub/../../moved.txt", errOutsideSite},
+		{"into a link out", "escape/moved.txt", errOutsideSite},
+		{"into a relative link out", "relup/moved.txt", errOutsideSite},
+		{"into a nested link out", "sub/deep/moved.txt", errOutsideSite},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := MoveFileAction(site, "a.txt", test.to, true); !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+	for _, name := range []string{"..", "../moved.txt", `..\moved.txt`, "sub/moved.txt"} {
+		if _, err := RenameFileAction(site, "a.txt", name, true); !errors.Is(err, errInvalidFilePath) {
+			t.Errorf("rename to %s: expected %v, got %v", name, errInvalidFilePath, err)
+		}
+	}
+	if _, err := MoveFileAction(site, "escape/pw.txt", "stolen.txt", false); !errors.Is(err, errOutsideSite) {
+		t.Fatalf("expected a source behind a link out to be refused, got %v", err)
+	}
+	if _, err := MoveFileAction(site, "a.txt", "escape", false); !errors.Is(err, errFileExists) {
+		t.Fatalf("expected a link in the way to need overwrite, got %v", err)
+	}
+
+	// Moves that stay inside, through a link in the site, still work
+	moved, err := MoveFileAction(site, "a.txt", "inside/a.txt", false)
+	if err != nil || moved.Path != "sub/a.txt" {
+		t.Fatalf("expected a move through an inside link, got %+v %v", moved, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "sub", "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	renamed, err := RenameFileAction(site, "sub/a.txt", "b.txt", false)
+	if err != nil || renamed.Path != "sub/b.txt" {
+		t.Fatalf("expected a rename in place, got %+v %v", renamed, err)
+	}
+
+	// Overwriting a link replaces the link, never what it points to
+	if _, err := MoveFileAction(site, "sub/b.txt", "escape", true); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(filepath.Join(root, "escape")); err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("expected the link replaced by the file, got %v %v", info, err)
+	}
+	entries, err := os.ReadDir(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := fileNames(entries); !slices.Equal(names, []string{"pw.txt"}) {
+		t.Fatalf("the secret directory changed: %v", names)
+	}
+	if content, err := os.ReadFile(filepath.Join(secret, "pw.txt")); err != nil || string(content) != "pw" {
+		t.Fatalf("the secret file changed: %q %v", content, err)
+	}
+}
+
+func fileNames(entries []os.DirEntry) []string {
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestTouchesWebConfig(t *testing.T) {
+	site, root, secret := linkedSite(t)
+	for _, dir := range []string{filepath.Join(root, "sub", "inner"), filepath.Join(root, "plain")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{filepath.Join(root, "sub", "inner", "Web.config"), filepath.Join(secret, "web.config")} {
+		if err := os.WriteFile(path, []byte("<configuration />"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"web.config", true},
+		{"plain/WEB.CONFIG. ", true},
+		{"sub", true},
+		{"sub/inner", true},
+		{`sub\inner\`, true},
+		{"plain", false},
+		{"a.txt", false},
+		{"missing", false},
+		// Moving or removing a link leaves what it points to
+		{"inside", false},
+		{"escape", false},
+		{"../secret", false},
+	}
+	for _, test := range tests {
+		if got := touchesWebConfig(site, test.rel); got != test.want {
+			t.Errorf("%s: expected %v, got %v", test.rel, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gitSourceBucket = "git-sources"
+	gitTimeout      = 10 * time.Minute
+	// gitProtocols leaves out ext:: and fd::, which run commands
+	gitProtocols = "file:git:http:https:ssh"
+)
+
+var (
+	errGitNotLinked = errors.New("website is not linked to a git repository")
+
+	gitRefPattern       = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._/-]*$`)
+	gitTransportPattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9+.-]*)::`)
+	gitCommitPattern    = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+	// gitMu keeps one git operation at a time on the shared mirrors
+	gitMu sync.Mutex
+)
+
+func normalizeGitSource(source GitSource) GitSource {
+	if source.Branch == "" {
+		source.Branch = "main"
+	}
+	source.Subdirectory = strings.Trim(strings.ReplaceAll(source.Subdirectory, `\`, "/"), "/")
+	return source
+}
+
+func ValidateGitRef(ref string) error {
+	if !gitRefPattern.MatchString(ref) || strings.Contains(ref, "..") || strings.HasSuffix(ref, ".lock") || strings.HasSuffix(ref, "/") {
+		return fmt.Errorf("invalid git ref %s", ref)
+	}
+	return nil
+}
+
+func ValidateGitSource(source GitSource) error {
+	source = normalizeGitSource(source)
+	if source.Repository == "" {
+		return fmt.Errorf("repository is required")
+	}
+	if strings.HasPrefix(source.Repository, "-") || strings.ContainsAny(source.Repository, "\r\n\x00") {
+		return fmt.Errorf("invalid repository %s", source.Repository)
+	}
+	if transport := gitTransportPattern.FindStringSubmatch(source.Repository); transport != nil {
+		return fmt.Errorf("repository transport %s is not allowed", transport[1])
+	}
+	if err := ValidateGitRef(source.Branch); err != nil {
+		return err
+	}
+	if source.Subdirectory != "" {
+		if _, err := cleanSitePath(source.Subdirectory); err != nil {
+			return err
+		}
+	}
+	if source.DeployKey != nil && *source.DeployKey != "" && !strings.Contains(*source.DeployKey, "PRIVATE KEY") {
+		return fmt.Errorf("deployKey must be a PEM or OpenSSH private key")
+	}
+	return nil
+}
+
+// GetGitSourceAction returns the repository a site is linked to, deploy
+// key included; see Public for what may be shown.
+func GetGitSourceAction(website Website) (GitSource, bool, error) {
+	source := GitSource{}
+	found, err := storeGet(gitSourceBucket, strconv.Itoa(website.ID), &source)
+	source.SiteID = website.ID
+	source.Site = website.Name
+	return source, found, err
+}
+
+// SetGitSourceAction links a site to a repository. A deploy key left out
+// keeps the current one; an empty one removes it.
+func SetGitSourceAction(website Website, source GitSource) (GitSource, error) {
+	source = normalizeGitSource(source)
+	if err := ValidateGitSource(source); err != nil {
+		return source, err
+	}
+	current, _, err := GetGitSourceAction(website)
+	if err != nil {
+		return source, err
+	}
+	if source.DeployKey == nil {
+		source.DeployKey = current.DeployKey
+	}
+	if source.DeployKey != nil && *source.DeployKey == "" {
+		source.DeployKey = nil
+	}
+	source.SiteID = website.ID
+	source.Site = website.Name
+	return source.Public(), storePut(gitSourceBucket, strconv.Itoa(website.ID), source)
+}
+
+func DeleteGitSourceAction(website Website) error {
+	return storeDelete(gitSourceBucket, strconv.Itoa(website.ID))
+}
+
+// Public leaves out the deploy key, saying only whether there is one.
+func (s GitSource) Public() GitSource {
+	s.HasDeployKey = s.DeployKey != nil
+	s.DeployKey = nil
+	return s
+}
+
+// gitMirror is the bare repository fetches go into. It is keyed by
+// repository, so sites deploying the same one share it.
+func gitMirror(repository string) string {
+	sum := sha256.Sum256([]byte(repository))
+	return filepath.Join(DataDir(), "git", hex.EncodeToString(sum[:8])+".git")
+}
+
+func runGit(dir string, env []string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL="+gitProtocols)
+	cmd.Env = append(cmd.Env, env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitSSHEnv hands the deploy key to ssh for one fetch. The returned
+// function removes the key file again.
+func gitSSHEnv(source GitSource) ([]string, func(), error) {
+	if source.DeployKey == nil {
+		return nil, func() {}, nil
+	}
+	dir := filepath.Join(DataDir(), "git")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, func() {}, err
+	}
+	key, err := os.CreateTemp(dir, "deploy-key-*")
+	if err != nil {
+		return nil, func() {}, err
+	}
+	cleanup := func() { os.Remove(key.Name()) }
+	// ssh refuses a key others can read, so access is cut before it is written
+	if err := restrictToOwner(key.Name()); err != nil {
+		key.Close()
+		cleanup()
+		return nil, func() {}, fmt.Errorf("failed to protect deploy key: %v", err)
+	}
+	_, err = key.WriteString(strings.TrimSpace(*source.DeployKey) + "\n")
+	if closeErr := key.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return nil, func() {}, fmt.Errorf("failed to write deploy key: %v", err)
+	}
+	knownHosts := filepath.ToSlash(filepath.Join(dir, "known_hosts"))
+	command := fmt.Sprintf(`ssh -i "%s" -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new -o UserKnownHostsFile="%s"`, filepath.ToSlash(key.Name()), knownHosts)
+	return []string{"GIT_SSH_COMMAND=" + command}, cleanup, nil
+}
+
+// fetchGitCommit fetches the linked branch into the mirror and resolves
+// ref to a commit: a commit SHA, another branch or tag, or by default the
+// head of the linked branch.
+func fetchGitCommit(source GitSource, ref string) (string, error) {
+	mirror := gitMirror(source.Repository)
+	if _, err := os.Stat(mirror); errors.Is(err, os.ErrNotExist) {
+		if _, err := runGit("", nil, "init", "--quiet", "--bare", mirror); err != nil {
+			return "", err
+		}
+	}
+	env, cleanup, err := gitSSHEnv(source)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	branch := "refs/heads/" + source.Branch
+	if _, err := runGit(mirror, env, "fetch", "--quiet", "--no-tags", "--", source.Repository, "+"+branch+":"+branch); err != nil {
+		return "", err
+	}
+	switch {
+	case ref == "":
+		ref = branch
+	case !gitCommitPattern.MatchString(ref):
+		// Other branches and tags are fetched each time, never read from
+		// what the mirror happens to hold
+		if _, err := runGit(mirror, env, "fetch", "--quiet", "--no-tags", "--", source.Repository, ref); err != nil {
+			return "", err
+		}
+		ref = "FETCH_HEAD"
+	}
+	commit, err := runGit(mirror, nil, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err == nil || ref == branch || ref == "FETCH_HEAD" {
+		return commit, err
+	}
+	// A commit the branch does not contain
+	if _, err := runGit(mirror, env, "fetch", "--quiet", "--no-tags", "--", source.Repository, ref); err != nil {
+		return "", fmt.Errorf("commit %s not found: %v", ref, err)
+	}
+	return runGit(mirror, nil, "rev-parse", "--verify", "--quiet", "FETCH_HEAD^{commit}")
+}
+
+// exportGitTree writes a commit's tree, or the linked subdirectory of it,
+// from the mirror into a zip at output, and returns the tree it wrote.
+func exportGitTree(source GitSource, commit string, output string) (string, error) {
+	tree := commit
+	if source.Subdirectory != "" {
+		tree += ":" + source.Subdirectory
+	}
+	_, err := runGit(gitMirror(source.Repository), nil, "archive", "--format=zip", "--output="+output, tree)
+	return tree, err
+}
+
+// redactRepository hides credentials written into a repository URL before
+// it goes into the deployment history.
+func redactRepository(repository string) string {
+	if u, err := url.Parse(repository); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return repository
+}
+
+// GitDeployAction deploys a commit of the linked repository, by default
+// the head of its branch. The commit's tree, or the linked subdirectory of
+// it, is exported and deployed like an uploaded archive.
+func GitDeployAction(website Website, ref string, keepConfig bool) (Deployment, error) {
+	source, found, err := GetGitSourceAction(website)
+	if err != nil {
+		return Deployment{}, err
+	}
+	if !found {
+		return Deployment{}, errGitNotLinked
+	}
+	deployment := newDeployment(website)
+	deployment.Git = &GitRevision{Repository: redactRepository(source.Repository), Branch: source.Branch, Subdirectory: source.Subdirectory}
+
+	gitMu.Lock()
+	defer gitMu.Unlock()
+	err = deployment.step("fetch", func() (string, error) {
+		commit, err := fetchGitCommit(source, ref)
+		if err != nil {
+			return "", err
+		}
+		info, err := runGit(gitMirror(source.Repository), nil, "log", "-1", "--format=%an%n%s", commit)
+		if err != nil {
+			return "", err
+		}
+		deployment.Git.Commit = commit
+		deployment.Git.Author, deployment.Git.Message, _ = strings.Cut(info, "\n")
+		return commit, nil
+	})
+	if err != nil {
+		return deployment, deployment.fail(err)
+	}
+
+	archive, err := os.CreateTemp("", "git-deploy-*.zip")
+	if err != nil {
+		return deployment, err
+	}
+	archive.Close()
+	defer os.Remove(archive.Name())
+	err = deployment.step("checkout", func() (string, error) {
+		return exportGitTree(source, deployment.Git.Commit, archive.Name())
+	})
+	if err != nil {
+		return deployment, deployment.fail(err)
+	}
+
+	file, err := os.Open(archive.Name())
+	if err != nil {
+		return deployment, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return deployment, err
+	}
+	deployment.ArchiveName = source.Branch + "@" + deployment.Git.Commit[:12]
+	return deployArchive(website, deployment, file, stat.Size(), keepConfig)
+}
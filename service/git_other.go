@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// restrictToOwner leaves the service's own account as the only one with
+// access to path. Off Windows the mode bits are enough.
+func restrictToOwner(path string) error {
+	return os.Chmod(path, 0o600)
+}
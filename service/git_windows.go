@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// restrictToOwner leaves the service's own account as the only one with
+// access to path. Files under the data directory inherit its ACL, and
+// Windows OpenSSH refuses a private key that other accounts can read.
+func restrictToOwner(path string) error {
+	token, err := syscall.OpenCurrentProcessToken()
+	if err != nil {
+		return err
+	}
+	defer token.Close()
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return err
+	}
+	sid, err := user.User.Sid.String()
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command("icacls", path, "/inheritance:r", "/grant:r", "*"+sid+":F").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("icacls: %v: %s", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	customHeadersFilter  = "system.webServer/httpProtocol/customHeaders"
+	headerProfileBucket  = "header-profiles"
+	headerAssignBucket   = "header-assignments"
+	poweredByHeader      = "X-Powered-By"
+	profileNameMaxLength = 64
+)
+
+var (
+	headerNamePattern  = regexp.MustCompile("^[!#$%&*+.^_`|~0-9A-Za-z-]+$")
+	profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 ._-]*$`)
+	xFrameOptions      = []string{"DENY", "SAMEORIGIN"}
+	referrerPolicies   = []string{
+		"no-referrer", "no-referrer-when-downgrade", "origin", "origin-when-cross-origin",
+		"same-origin", "strict-origin", "strict-origin-when-cross-origin", "unsafe-url",
+	}
+)
+
+// headerAssignment records which profile a site follows. It is keyed by
+// site ID so that it survives a rename.
+type headerAssignment struct {
+	SiteID  int    `json:"siteId"`
+	Site    string `json:"site"`
+	Profile string `json:"profile"`
+}
+
+func GetSiteHeadersAction(site string) (SiteHeaders, error) {
+	ps := fmt.Sprintf(`Import-Module WebAdministration;
+		$pspath = %s;
+		$headers = @((Get-WebConfiguration -PSPath $pspath -Filter '%s').Collection | ForEach-Object {
+			[PSCustomObject]@{ name = $_.name; value = $_.value }
+		});
+		$filtering = Get-WebConfiguration -PSPath $pspath -Filter 'system.webServer/security/requestFiltering';
+		[PSCustomObject]@{ headers = $headers; removeServerHeader = [bool]$filtering.removeServerHeader } | ConvertTo-Json -Depth 3`,
+		sitePSPath(site), customHeadersFilter)
+	out, err := runPowerShell(ps)
+	if err != nil {
+		return SiteHeaders{}, fmt.Errorf("failed to read headers for %s: %v", site, err)
+	}
+	raw := struct {
+		Headers            []CustomHeader `json:"headers"`
+		RemoveServerHeader bool           `json:"removeServerHeader"`
+	}{}
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return SiteHeaders{}, fmt.Errorf("failed to parse headers for %s: %v", site, err)
+	}
+	headers := SiteHeaders{Headers: []CustomHeader{}, RemoveXPoweredBy: true, RemoveServerHeader: raw.RemoveServerHeader}
+	for _, header := range raw.Headers {
+		if strings.EqualFold(header.Name, poweredByHeader) {
+			headers.RemoveXPoweredBy = false
+			continue
+		}
+		headers.Headers = append(headers.Headers, header)
+	}
+	return headers, nil
+}
+
+// SetSiteHeadersAction makes the site's customHeaders match headers. The
+// site-level section is reset first, so headers inherited from the server
+// come back unless they are overridden or X-Powered-By is removed again.
+// Both sections are changed in one ServerManager and committed once, so a
+// failure part way leaves the site's web.config as it was.
+func SetSiteHeadersAction(site string, headers SiteHeaders) error {
+	if err := ValidateCustomHeaders(headers.Headers); err != nil {
+		return err
+	}
+	payload, err := psJSON(headers.Headers)
+	if err != nil {
+		return err
+	}
+	ps := fmt.Sprintf(`%s;
+		$manager = New-Object Microsoft.Web.Administration.ServerManager;
+		%s
+		$config = $manager.GetWebConfiguration(%s);
+		$section = $config.GetSection('%s');
+		$section.RevertToParent();
+		$collection = $section.GetCollection();
+		foreach ($h in %s) {
+			foreach ($old in @($collection | Where-Object { $_['name'] -eq $h.name })) { $collection.Remove($old) };
+			$add = $collection.CreateElement('add');
+			$add['name'] = $h.name;
+			$add['value'] = $h.value;
+			[void]$collection.Add($add);
+		};
+		if ($%t) {
+			foreach ($old in @($collection | Where-Object { $_['name'] -eq '%s' })) { $collection.Remove($old) };
+		};
+		$filtering = $config.GetSection('system.webServer/security/requestFiltering');
+		$filtering['removeServerHeader'] = $%t;
+		$manager.CommitChanges()`,
+		mwaAssembly, mwaSiteCheck(site), psQuote(site), customHeadersFilter, payload,
+		headers.RemoveXPoweredBy, poweredByHeader,
+		headers.RemoveServerHeader)
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to write headers for %s: %v", site, err)
+	}
+	return nil
+}
+
+func ValidateCustomHeaders(headers []CustomHeader) error {
+	seen := map[string]bool{}
+	for _, header := range headers {
+		if !headerNamePattern.MatchString(header.Name) {
+			return fmt.Errorf("invalid header name %q", header.Name)
+		}
+		if strings.EqualFold(header.Name, poweredByHeader) {
+			return fmt.Errorf("%s is controlled by removeXPoweredBy", poweredByHeader)
+		}
+		if strings.ContainsAny(header.Value, "\r\n") {
+			return fmt.Errorf("header %s: value cannot contain line breaks", header.Name)
+		}
+		key := strings.ToLower(header.Name)
+		if seen[key] {
+			return fmt.Errorf("duplicate header %s", header.Name)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+func ValidateHeaderProfile(profile SecurityHeaderProfile) error {
+	if len(profile.Name) > profileNameMaxLength || !profileNamePattern.MatchString(profile.Name) {
+		return fmt.Errorf("invalid profile name %q", profile.Name)
+	}
+	if profile.XFrameOptions != "" && !slices.Contains(xFrameOptions, strings.ToUpper(profile.XFrameOptions)) {
+		return fmt.Errorf("X-Frame-Options must be DENY or SAMEORIGIN")
+	}
+	for _, policy := range strings.Split(profile.ReferrerPolicy, ",") {
+		policy = strings.TrimSpace(policy)
+		if policy != "" && !slices.Contains(referrerPolicies, policy) {
+			return fmt.Errorf("unknown Referrer-Policy %s", policy)
+		}
+	}
+	if profile.StrictTransportSecurity != "" && !strings.Contains(profile.StrictTransportSecurity, "max-age=") {
+		return fmt.Errorf("Strict-Transport-Security needs a max-age directive")
+	}
+	return ValidateCustomHeaders(profile.HeaderList())
+}
+
+// HeaderList renders the profile as the customHeaders it manages.
+func (p SecurityHeaderProfile) HeaderList() []CustomHeader {
+	headers := []CustomHeader{}
+	named := []CustomHeader{
+		{Name: "Strict-Transport-Security", Value: p.StrictTransportSecurity},
+		{Name: "Content-Security-Policy", Value: p.ContentSecurityPolicy},
+		{Name: "X-Frame-Options", Value: strings.ToUpper(p.XFrameOptions)},
+		{Name: "Referrer-Policy", Value: p.ReferrerPolicy},
+		{Name: "Permissions-Policy", Value: p.PermissionsPolicy},
+	}
+	for _, header := range named {
+		if header.Value != "" {
+			headers = append(headers, header)
+		}
+	}
+	return append(headers, p.Headers...)
+}
+
+func GetHeaderProfilesAction() ([]SecurityHeaderProfile, error) {
+	return storeList[SecurityHeaderProfile](headerProfileBucket)
+}
+
+func GetHeaderProfileAction(name string) (SecurityHeaderProfile, error) {
+	profile := SecurityHeaderProfile{}
+	found, err := storeGet(headerProfileBucket, name, &profile)
+	if err != nil {
+		return profile, err
+	}
+	if !found {
+		return profile, fmt.Errorf("profile %s not found", name)
+	}
+	return profile, nil
+}
+
+func SaveHeaderProfileAction(profile SecurityHeaderProfile) error {
+	if err := ValidateHeaderProfile(profile); err != nil {
+		return err
+	}
+	if profile.Headers == nil {
+		profile.Headers = []CustomHeader{}
+	}
+	return storePut(headerProfileBucket, profile.Name, profile)
+}
+
+func DeleteHeaderProfileAction(name string) error {
+	assignments, err := storeList[headerAssignment](headerAssignBucket)
+	if err != nil {
+		return err
+	}
+	for _, assignment := range assignments {
+		if assignment.Profile == name {
+			return fmt.Errorf("profile %s is still assigned to %s", name, assignment.Site)
+		}
+	}
+	return storeDelete(headerProfileBucket, name)
+}
+
+// mergeProfileHeaders returns current with the profile's headers set,
+// keeping any header the profile does not manage.
+func mergeProfileHeaders(current []CustomHeader, profile SecurityHeaderProfile) []CustomHeader {
+	managed := profile.HeaderList()
+	merged := []CustomHeader{}
+	for _, header := range current {
+		if !slices.ContainsFunc(managed, func(m CustomHeader) bool { return strings.EqualFold(m.Name, header.Name) }) {
+			merged = append(merged, header)
+		}
+	}
+	return append(merged, managed...)
+}
+
+// ApplyHeaderProfileAction writes the profile into every listed site and
+// records the assignment. Each site is handled independently.
+func ApplyHeaderProfileAction(name string, sites []string) ([]SiteResult, error) {
+	profile, err := GetHeaderProfileAction(name)
+	if err != nil {
+		return nil, err
+	}
+	websites, err := ListWebsitesAction()
+	if err != nil {
+		return nil, err
+	}
+	results := []SiteResult{}
+	for _, site := range sites {
+		result := SiteResult{Site: site}
+		index := slices.IndexFunc(websites, func(w Website) bool { return w.Name == site })
+		if index < 0 {
+			result.Error = "Website not found"
+			results = append(results, result)
+			continue
+		}
+		if err := applyHeaderProfile(websites[index], profile); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Success = true
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func applyHeaderProfile(website Website, profile SecurityHeaderProfile) error {
+	live, err := GetSiteHeadersAction(website.Name)
+	if err != nil {
+		return err
+	}
+	desired := SiteHeaders{
+		Headers:            mergeProfileHeaders(live.Headers, profile),
+		RemoveXPoweredBy:   live.RemoveXPoweredBy || profile.RemoveXPoweredBy,
+		RemoveServerHeader: live.RemoveServerHeader || profile.RemoveServerHeader,
+	}
+	if err := SetSiteHeadersAction(website.Name, desired); err != nil {
+		return err
+	}
+	return storePut(headerAssignBucket, strconv.Itoa(website.ID), headerAssignment{SiteID: website.ID, Site: website.Name, Profile: profile.Name})
+}
+
+func UnassignHeaderProfileAction(website Website) error {
+	return storeDelete(headerAssignBucket, strconv.Itoa(website.ID))
+}
+
+// assignedHeaderProfile returns the profile name assigned to a site, if any.
+func assignedHeaderProfile(website Website) (string, error) {
+	assignment := headerAssignment{}
+	found, err := storeGet(headerAssignBucket, strconv.Itoa(website.ID), &assignment)
+	if err != nil || !found {
+		return "", err
+	}
+	return assignment.Profile, nil
+}
+
+// HeaderDrift lists where live headers no longer match the profile.
+func HeaderDrift(profile SecurityHeaderProfile, live SiteHeaders) []HeaderDriftItem {
+	drift := []HeaderDriftItem{}
+	for _, want := range profile.HeaderList() {
+		index := slices.IndexFunc(live.Headers, func(h CustomHeader) bool { return strings.EqualFold(h.Name, want.Name) })
+		switch {
+		case index < 0:
+			drift = append(drift, HeaderDriftItem{Header: want.Name, Expected: want.Value, Actual: ""})
+		case live.Headers[index].Value != want.Value:
+			drift = append(drift, HeaderDriftItem{Header: want.Name, Expected: want.Value, Actual: live.Headers[index].Value})
+		}
+	}
+	if profile.RemoveXPoweredBy && !live.RemoveXPoweredBy {
+		drift = append(drift, HeaderDriftItem{Header: poweredByHeader, Expected: "removed", Actual: "present"})
+	}
+	if profile.RemoveServerHeader && !live.RemoveServerHeader {
+		drift = append(drift, HeaderDriftItem{Header: "Server", Expected: "removed", Actual: "present"})
+	}
+	return drift
+}
+
+// GetSiteHeadersWithDriftAction reads a site's headers along with its
+// assigned profile and any drift from it.
+func GetSiteHeadersWithDriftAction(website Website) (SiteHeaders, error) {
+	headers, err := GetSiteHeadersAction(website.Name)
+	if err != nil {
+		return headers, err
+	}
+	name, err := assignedHeaderProfile(website)
+	if err != nil || name == "" {
+		return headers, err
+	}
+	profile, err := GetHeaderProfileAction(name)
+	if err != nil {
+		return headers, err
+	}
+	headers.Profile = name
+	headers.Drift = HeaderDrift(profile, headers)
+	return headers, nil
+}
+
+// HeaderDriftReportAction checks every site that has a profile assigned.
+func HeaderDriftReportAction() ([]HeaderDriftReport, error) {
+	assignments, err := storeList[headerAssignment](headerAssignBucket)
+	if err != nil {
+		return nil, err
+	}
+	websites, err := ListWebsitesAction()
+	if err != nil {
+		return nil, err
+	}
+	reports := []HeaderDriftReport{}
+	for _, assignment := range assignments {
+		report := HeaderDriftReport{Site: assignment.Site, Profile: assignment.Profile, Drift: []HeaderDriftItem{}}
+		index := slices.IndexFunc(websites, func(w Website) bool { return w.ID == assignment.SiteID })
+		if index < 0 {
+			report.Error = "Website not found"
+			reports = append(reports, report)
+			continue
+		}
+		report.Site = websites[index].Name
+		headers, err := GetSiteHeadersWithDriftAction(websites[index])
+		if err != nil {
+			report.Error = err.Error()
+		} else {
+			report.Drift = headers.Drift
+			report.InSync = len(headers.Drift) == 0
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	healthConfigBucket   = "health-checks"
+	healthLatestBucket   = "health-latest"
+	healthHistoryBucket  = "health-history"
+	healthParallelism    = 8
+	healthBodyLimit      = 64 << 10
+	tlsExpiryWarningDays = 14
+
+	healthHealthy     = "healthy"
+	healthDegraded    = "degraded"
+	healthUnhealthy   = "unhealthy"
+	healthStopped     = "stopped"
+	healthUnknown     = "unknown"
+	healthMaintenance = "maintenance"
+)
+
+var (
+	defaultHealthSettings = HealthSettings{IntervalMinutes: 1, HistoryDays: 30}
+	// probeRoots verifies probed certificates; nil means the system roots
+	probeRoots *x509.CertPool
+)
+
+func GetHealthSettingsAction() (HealthSettings, error) {
+	settings := defaultHealthSettings
+	_, err := storeGet(settingsBucket, "health", &settings)
+	return settings, err
+}
+
+func SetHealthSettingsAction(settings HealthSettings) error {
+	if err := ValidateHealthSettings(settings); err != nil {
+		return err
+	}
+	return storePut(settingsBucket, "health", settings)
+}
+
+func ValidateHealthSettings(settings HealthSettings) error {
+	if settings.IntervalMinutes < 0 || settings.IntervalMinutes > 24*60 {
+		return fmt.Errorf("intervalMinutes must be between 0 (off) and %d", 24*60)
+	}
+	if settings.HistoryDays < 1 || settings.HistoryDays > 400 {
+		return fmt.Errorf("historyDays must be between 1 and 400")
+	}
+	return nil
+}
+
+func normalizeHealthConfig(config HealthCheckConfig) HealthCheckConfig {
+	if config.Path == "" {
+		config.Path = "/"
+	}
+	if config.TimeoutSeconds == 0 {
+		config.TimeoutSeconds = 10
+	}
+	if config.ExpectedStatus == nil {
+		config.ExpectedStatus = []int{}
+	}
+	return config
+}
+
+func ValidateHealthConfig(config HealthCheckConfig) error {
+	if !strings.HasPrefix(config.Path, "/") || strings.ContainsAny(config.Path, " \t\r\n") {
+		return fmt.Errorf("path must start with / and contain no whitespace")
+	}
+	for _, code := range config.ExpectedStatus {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid expected status %d", code)
+		}
+	}
+	if len(config.ExpectedBody) > healthBodyLimit {
+		return fmt.Errorf("expectedBody must be at most %d bytes", healthBodyLimit)
+	}
+	if config.TimeoutSeconds < 1 || config.TimeoutSeconds > 120 {
+		return fmt.Errorf("timeoutSeconds must be between 1 and 120")
+	}
+	return nil
+}
+
+func GetHealthConfigAction(website Website) (HealthCheckConfig, error) {
+	config := HealthCheckConfig{}
+	if _, err := storeGet(healthConfigBucket, strconv.Itoa(website.ID), &config); err != nil {
+		return config, err
+	}
+	config.SiteID = website.ID
+	config.Site = website.Name
+	return normalizeHealthConfig(config), nil
+}
+
+func SetHealthConfigAction(website Website, config HealthCheckConfig) (HealthCheckConfig, error) {
+	config = normalizeHealthConfig(config)
+	if err := ValidateHealthConfig(config); err != nil {
+		return config, err
+	}
+	config.SiteID = website.ID
+	config.Site = website.Name
+	return config, storePut(healthConfigBucket, strconv.Itoa(website.ID), config)
+}
+
+// healthTargets turns a site's http and https bindings into probe targets.
+// Probes always go to this machine, with the binding's host name in the
+// Host header and SNI, so they work before DNS points at the server.
+// Bindings without a host name answer to any name, so they are probed as
+// localhost and their certificate's name is not checked.
+func healthTargets(site ManifestSite, config HealthCheckConfig) []ProbeTarget {
+	targets := []ProbeTarget{}
+	for _, binding := range site.Bindings {
+		host := binding.Host
+		if host == "" {
+			host = "localhost"
+		}
+		authority := host
+		if !(binding.Protocol == "http" && binding.Port == 80) && !(binding.Protocol == "https" && binding.Port == 443) {
+			authority = net.JoinHostPort(host, strconv.Itoa(binding.Port))
+		}
+		dial := "127.0.0.1"
+		if binding.IP != "" && binding.IP != "*" {
+			dial = strings.Trim(binding.IP, "[]")
+		}
+		targets = append(targets, ProbeTarget{
+			URL:         binding.Protocol + "://" + authority + config.Path,
+			DialAddress: net.JoinHostPort(dial, strconv.Itoa(binding.Port)),
+			AnyHost:     binding.Host == "",
+		})
+	}
+	return targets
+}
+
+func expectedStatus(code int, expected []int) bool {
+	if len(expected) == 0 {
+		return code >= 200 && code < 400
+	}
+	return slices.Contains(expected, code)
+}
+
+// Probe makes one request. Redirects are not followed, and certificates
+// are checked separately so that an invalid certificate still yields a
+// status code.
+func Probe(target ProbeTarget, config HealthCheckConfig) ProbeResult {
+	result := ProbeResult{URL: target.URL}
+	request, err := http.NewRequest(http.MethodGet, target.URL, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	request.Header.Set("User-Agent", "serverless-iis-health")
+	timeout := time.Duration(config.TimeoutSeconds) * time.Second
+	dialer := &net.Dialer{Timeout: timeout}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true, ServerName: request.URL.Hostname()},
+			DisableKeepAlives: true,
+			DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+				if target.DialAddress != "" {
+					addr = target.DialAddress
+				}
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	start := time.Now()
+	response, err := client.Do(request)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, healthBodyLimit))
+	result.StatusCode = response.StatusCode
+	result.OK = expectedStatus(response.StatusCode, config.ExpectedStatus)
+	switch {
+	case !result.OK:
+		result.Error = fmt.Sprintf("unexpected status %d", response.StatusCode)
+	case err != nil:
+		result.OK = false
+		result.Error = err.Error()
+	case config.ExpectedBody != "" && !strings.Contains(string(body), config.ExpectedBody):
+		result.OK = false
+		result.Error = fmt.Sprintf("response does not contain %q", config.ExpectedBody)
+	}
+	if response.TLS != nil {
+		host := request.URL.Hostname()
+		if target.AnyHost {
+			host = ""
+		}
+		result.TLS = checkCertificate(response.TLS, host)
+	}
+	return result
+}
+
+// checkCertificate verifies the chain and expiry of the presented
+// certificate, and its name unless host is empty.
+func checkCertificate(state *tls.ConnectionState, host string) *TLSStatus {
+	if len(state.PeerCertificates) == 0 {
+		return &TLSStatus{Error: "no certificate presented"}
+	}
+	leaf := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	status := &TLSStatus{
+		Subject:  leaf.Subject.CommonName,
+		Issuer:   leaf.Issuer.CommonName,
+		NotAfter: leaf.NotAfter,
+		DaysLeft: int(time.Until(leaf.NotAfter).Hours() / 24),
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates, Roots: probeRoots})
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Valid = true
+	}
+	return status
+}
+
+// summarizeHealth rates a site from its probes: healthy when every probe
+// passed with a valid certificate not about to expire, unhealthy when none
+// passed, degraded in between.
+func summarizeHealth(probes []ProbeResult) (string, string) {
+	if len(probes) == 0 {
+		return healthUnknown, "no http or https bindings to probe"
+	}
+	passed := 0
+	problems := []string{}
+	for _, probe := range probes {
+		if probe.OK {
+			passed++
+		} else {
+			problems = append(problems, probe.URL+": "+probe.Error)
+		}
+		if probe.TLS == nil || !probe.OK {
+			continue
+		}
+		if !probe.TLS.Valid {
+			problems = append(problems, probe.URL+": certificate "+probe.TLS.Error)
+		} else if probe.TLS.DaysLeft < tlsExpiryWarningDays {
+			problems = append(problems, fmt.Sprintf("%s: certificate expires in %d days", probe.URL, probe.TLS.DaysLeft))
+		}
+	}
+	switch {
+	case passed == 0:
+		return healthUnhealthy, strings.Join(problems, "; ")
+	case len(problems) > 0:
+		return healthDegraded, strings.Join(problems, "; ")
+	}
+	return healthHealthy, ""
+}
+
+func checkSiteHealth(website Website, site ManifestSite, config HealthCheckConfig) HealthResult {
+	result := HealthResult{SiteID: website.ID, Site: website.Name, CheckedAt: time.Now().UTC(), Probes: []ProbeResult{}}
+	if !strings.EqualFold(website.State, "Started") {
+		result.Status = healthStopped
+		result.Message = "site is " + strings.ToLower(website.State)
+		return result
+	}
+	for _, target := range healthTargets(site, config) {
+		result.Probes = append(result.Probes, Probe(target, config))
+	}
+	result.Status, result.Message = summarizeHealth(result.Probes)
+	return result
+}
+
+func recordHealth(result HealthResult) error {
+	if err := storePut(healthLatestBucket, strconv.Itoa(result.SiteID), result); err != nil {
+		return err
+	}
+	return storePut(healthHistoryBucket, siteKeyPrefix(result.SiteID)+result.CheckedAt.Format(storeTimeFormat), result)
+}
+
+// HealthCheckAllAction probes every site with checks enabled, a few at a
+// time, records the results and prunes history past HistoryDays.
+func HealthCheckAllAction() ([]HealthResult, error) {
+	settings, err := GetHealthSettingsAction()
+	if err != nil {
+		return nil, err
+	}
+	live, err := readLiveManifest(func(string) bool { return false })
+	if err != nil {
+		return nil, err
+	}
+	websites, err := ListWebsitesAction()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]HealthResult, len(websites))
+	slots := make(chan struct{}, healthParallelism)
+	var wg sync.WaitGroup
+	for i, website := range websites {
+		config, err := GetHealthConfigAction(website)
+		if err != nil {
+			return nil, err
+		}
+		if config.Disabled {
+			continue
+		}
+		site, _ := liveManifestSite(live, website.Name)
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = checkSiteHealth(website, site, config)
+		}()
+	}
+	wg.Wait()
+
+	maintenance, err := maintenanceSites()
+	if err != nil {
+		return nil, err
+	}
+	checked := []HealthResult{}
+	cutoff := time.Now().UTC().AddDate(0, 0, -settings.HistoryDays).Format(storeTimeFormat)
+	for _, result := range results {
+		if result.CheckedAt.IsZero() {
+			continue
+		}
+		if _, ok := maintenance[result.SiteID]; ok {
+			result.Status, result.Message = healthMaintenance, "site is in maintenance"
+		}
+		if err := recordHealth(result); err != nil {
+			return checked, err
+		}
+		if err := storeDeleteRange(healthHistoryBucket, siteKeyPrefix(result.SiteID), "", cutoff); err != nil {
+			return checked, err
+		}
+		checked = append(checked, result)
+	}
+	return checked, nil
+}
+
+// CheckHealthAction probes one site now and records the result.
+func CheckHealthAction(website Website) (HealthResult, error) {
+	config, err := GetHealthConfigAction(website)
+	if err != nil {
+		return HealthResult{}, err
+	}
+	live, err := readLiveManifest(func(string) bool { return false })
+	if err != nil {
+		return HealthResult{}, err
+	}
+	maintenance, err := GetMaintenanceAction(website)
+	if err != nil {
+		return HealthResult{}, err
+	}
+	site, _ := liveManifestSite(live, website.Name)
+	result := checkSiteHealth(website, site, config)
+	if maintenance.Active {
+		result.Status, result.Message = healthMaintenance, "site is in maintenance"
+	}
+	return result, recordHealth(result)
+}
+
+func GetHealthAction(website Website) (HealthResult, bool, error) {
+	result := HealthResult{}
+	found, err := storeGet(healthLatestBucket, strconv.Itoa(website.ID), &result)
+	result.Site = website.Name
+	return result, found, err
+}
+
+// GetHealthHistoryAction returns results between from and to, oldest
+// first, keeping the newest limit of them.
+func GetHealthHistoryAction(website Website, from time.Time, to time.Time, limit int) ([]HealthResult, error) {
+	results, err := storeListRange[HealthResult](healthHistoryBucket, siteKeyPrefix(website.ID), timeKey(from), timeKey(to))
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(results) > limit {
+		results = results[len(results)-limit:]
+	}
+	return results, nil
+}
+
+// timeKey renders t for a range bound; the zero time leaves the bound open.
+func timeKey(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(storeTimeFormat)
+}
+
+// attachHealth fills in Health on every website that has been checked.
+func attachHealth(websites []Website) ([]Website, error) {
+	latest, err := storeList[HealthResult](healthLatestBucket)
+	if err != nil {
+		return websites, err
+	}
+	byID := map[int]HealthResult{}
+	for _, result := range latest {
+		byID[result.SiteID] = result
+	}
+	for i := range websites {
+		if result, ok := byID[websites[i].ID]; ok {
+			websites[i].Health = result.Summary()
+		}
+	}
+	return websites, nil
+}
+
+func (r HealthResult) Summary() *HealthSummary {
+	summary := &HealthSummary{Status: r.Status, CheckedAt: r.CheckedAt, Message: r.Message}
+	for _, probe := range r.Probes {
+		summary.LatencyMs = max(summary.LatencyMs, probe.LatencyMs)
+	}
+	return summary
+}
+
+// StartHealthScheduler probes all sites every IntervalMinutes and runs
+// their recovery policies on the results.
+func StartHealthScheduler() {
+	runEvery("health check", func() (int, error) {
+		settings, err := GetHealthSettingsAction()
+		return settings.IntervalMinutes, err
+	}, func() error {
+		results, err := HealthCheckAllAction()
+		if err != nil {
+			return err
+		}
+		return RecoverAction(results)
+	})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func healthServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/slow":
+			time.Sleep(2 * time.Second)
+		case "/host":
+			w.Write([]byte(r.Host))
+		default:
+			w.Write([]byte(`{"status":"ok"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// certificateServer serves TLS with a certificate for example.com that
+// expires after validFor, trusted through probeRoots.
+func certificateServer(t *testing.T, validFor time.Duration) *httptest.Server {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-48 * time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+		// Required for a self-signed CA certificate
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	probeRoots = roots
+	t.Cleanup(func() { probeRoots = nil })
+	return server
+}
+
+func TestProbe(t *testing.T) {
+	server := healthServer(t)
+	address := strings.TrimPrefix(server.URL, "http://")
+	tests := []struct {
+		name   string
+		target ProbeTarget
+		config HealthCheckConfig
+		ok     bool
+		status int
+		err    string
+	}{
+		{"default status", ProbeTarget{URL: server.URL + "/"}, HealthCheckConfig{}, true, 200, ""},
+		{"status mismatch", ProbeTarget{URL: server.URL + "/down"}, HealthCheckConfig{}, false, 503, "unexpected status 503"},
+		{"expected status", ProbeTarget{URL: server.URL + "/down"}, HealthCheckConfig{ExpectedStatus: []int{503}}, true, 503, ""},
+		{"expected status missed", ProbeTarget{URL: server.URL + "/"}, HealthCheckConfig{ExpectedStatus: []int{204}}, false, 200, "unexpected status 200"},
+		{"body match", ProbeTarget{URL: server.URL + "/"}, HealthCheckConfig{ExpectedBody: `"ok"`}, true, 200, ""},
+		{"body mismatch", ProbeTarget{URL: server.URL + "/"}, HealthCheckConfig{ExpectedBody: "ready"}, false, 200, "does not contain"},
+		{"host header", ProbeTarget{URL: "http://shop.example.com/host", DialAddress: address}, HealthCheckConfig{ExpectedBody: "shop.example.com"}, true, 200, ""},
+		{"timeout", ProbeTarget{URL: server.URL + "/slow"}, HealthCheckConfig{TimeoutSeconds: 1}, false, 0, "Timeout"},
+		{"refused", ProbeTarget{URL: "http://127.0.0.1:1/"}, HealthCheckConfig{}, false, 0, "refused"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Probe(test.target, normalizeHealthConfig(test.config))
+			if result.OK != test.ok || result.StatusCode != test.status {
+				t.Fatalf("expected ok=%v status=%d, got %+v", test.ok, test.status, result)
+			}
+			if test.err == "" && result.Error != "" || !strings.Contains(result.Error, test.err) {
+				t.Fatalf("expected error containing %q, got %q", test.err, result.Error)
+			}
+		})
+	}
+}
+
+func TestProbeCertificate(t *testing.T) {
+	config := normalizeHealthConfig(HealthCheckConfig{})
+
+	// httptest's own certificate is not trusted by probeRoots
+	untrusted := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer untrusted.Close()
+	result := Probe(ProbeTarget{URL: untrusted.URL}, config)
+	if !result.OK || result.TLS == nil || result.TLS.Valid {
+		t.Fatalf("expected an untrusted certificate to still probe, got %+v", result)
+	}
+	if status, _ := summarizeHealth([]ProbeResult{result}); status != healthDegraded {
+		t.Fatalf("expected degraded for an untrusted certificate, got %s", status)
+	}
+
+	tests := []struct {
+		name    string
+		expiry  time.Duration
+		valid   bool
+		status  string
+		message string
+	}{
+		{"valid", 90 * 24 * time.Hour, true, healthHealthy, ""},
+		{"expiring", 5 * 24 * time.Hour, true, healthDegraded, "expires in"},
+		{"expired", -24 * time.Hour, false, healthDegraded, "expired"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := certificateServer(t, test.expiry)
+			target := ProbeTarget{URL: "https://example.com/", DialAddress: strings.TrimPrefix(server.URL, "https://")}
+			result := Probe(target, config)
+			if !result.OK || result.TLS == nil || result.TLS.Valid != test.valid {
+				t.Fatalf("expected valid=%v, got %+v %+v", test.valid, result, result.TLS)
+			}
+			status, message := summarizeHealth([]ProbeResult{result})
+			if status != test.status || !strings.Contains(message, test.message) {
+				t.Fatalf("expected %s with %q, got %s %q", test.status, test.message, status, message)
+			}
+		})
+	}
+	// A binding without a host name is probed as localhost, which its
+	// certificate does not name; only the chain and expiry are checked
+	server := certificateServer(t, 90*24*time.Hour)
+	dial := strings.TrimPrefix(server.URL, "https://")
+	named := Probe(ProbeTarget{URL: "https://localhost/", DialAddress: dial}, config)
+	if named.TLS == nil || named.TLS.Valid {
+		t.Fatalf("expected the name check to fail for localhost, got %+v", named.TLS)
+	}
+	anyHost := Probe(ProbeTarget{URL: "https://localhost/", DialAddress: dial, AnyHost: true}, config)
+	if anyHost.TLS == nil || !anyHost.TLS.Valid {
+		t.Fatalf("expected a valid certificate for a binding without a host name, got %+v", anyHost.TLS)
+	}
+	if status, message := summarizeHealth([]ProbeResult{anyHost}); status != healthHealthy {
+		t.Fatalf("expected healthy, got %s %q", status, message)
+	}
+	expired := certificateServer(t, -24*time.Hour)
+	stale := Probe(ProbeTarget{URL: "https://localhost/", DialAddress: strings.TrimPrefix(expired.URL, "https://"), AnyHost: true}, config)
+	if stale.TLS == nil || stale.TLS.Valid {
+		t.Fatalf("expected an expired certificate to stay invalid without a host name, got %+v", stale.TLS)
+	}
+}
+
+func TestHealthTargets(t *testing.T) {
+	config := HealthCheckConfig{Path: "/healthz"}
+	tests := []struct {
+		name    string
+		binding ManifestBinding
+		want    ProbeTarget
+	}{
+		{"named https", ManifestBinding{Protocol: "https", Port: 443, Host: "shop.example.com"}, ProbeTarget{URL: "https://shop.example.com/healthz", DialAddress: "127.0.0.1:443"}},
+		{"named http on a port", ManifestBinding{Protocol: "http", Port: 8080, Host: "shop.example.com"}, ProbeTarget{URL: "http://shop.example.com:8080/healthz", DialAddress: "127.0.0.1:8080"}},
+		{"no host name", ManifestBinding{Protocol: "https", IP: "*", Port: 443}, ProbeTarget{URL: "https://localhost/healthz", DialAddress: "127.0.0.1:443", AnyHost: true}},
+		{"no host name on an address", ManifestBinding{Protocol: "http", IP: "10.0.0.5", Port: 80}, ProbeTarget{URL: "http://localhost/healthz", DialAddress: "10.0.0.5:80", AnyHost: true}},
+		{"ipv6 address", ManifestBinding{Protocol: "https", IP: "[::1]", Port: 8443}, ProbeTarget{URL: "https://localhost:8443/healthz", DialAddress: "[::1]:8443", AnyHost: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			targets := healthTargets(ManifestSite{Bindings: []ManifestBinding{test.binding}}, config)
+			if len(targets) != 1 || targets[0] != test.want {
+				t.Fatalf("expected %+v, got %+v", test.want, targets)
+			}
+		})
+	}
+}
+
+func TestCheckSiteHealth(t *testing.T) {
+	server := healthServer(t)
+	_, portText, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	port, _ := strconv.Atoi(portText)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	_, closedText, _ := net.SplitHostPort(strings.TrimPrefix(closed.URL, "http://"))
+	closedPort, _ := strconv.Atoi(closedText)
+	closed.Close()
+
+	started := Website{ID: 1, Name: "shop", State: "Started"}
+	live := ManifestSite{Bindings: []ManifestBinding{{Protocol: "http", Port: port}}}
+	tests := []struct {
+		name    string
+		website Website
+		site    ManifestSite
+		config  HealthCheckConfig
+		status  string
+		probes  int
+	}{
+		{"healthy", started, live, HealthCheckConfig{}, healthHealthy, 1},
+		{"status mismatch", started, live, HealthCheckConfig{Path: "/down"}, healthUnhealthy, 1},
+		{"body mismatch", started, live, HealthCheckConfig{ExpectedBody: "ready"}, healthUnhealthy, 1},
+		{"one binding down", started, ManifestSite{Bindings: append(slices.Clone(live.Bindings), ManifestBinding{Protocol: "http", Port: closedPort})}, HealthCheckConfig{}, healthDegraded, 2},
+		{"stopped", Website{ID: 1, Name: "shop", State: "Stopped"}, live, HealthCheckConfig{}, healthStopped, 0},
+		{"no bindings", started, ManifestSite{}, HealthCheckConfig{}, healthUnknown, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := checkSiteHealth(test.website, test.site, normalizeHealthConfig(test.config))
+			if result.Status != test.status || len(result.Probes) != test.probes {
+				t.Fatalf("expected %s with %d probes, got %+v", test.status, test.probes, result)
+			}
+			if result.SiteID != test.website.ID || result.CheckedAt.IsZero() {
+				t.Fatalf("result not attributed to the site: %+v", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	deployHooksBucket = "deploy-hooks"
+
+	hookPreSwap  = "pre-swap"
+	hookPostSwap = "post-swap"
+	hookFailure  = "failure"
+
+	defaultHookTimeout = 300
+	maxHookTimeout     = 3600
+	maxHooks           = 20
+	// hookOutputLimit caps the stdout and stderr kept for each hook run
+	hookOutputLimit = 64 << 10
+)
+
+var hookStages = []string{hookPreSwap, hookPostSwap, hookFailure}
+
+func normalizeDeployHook(hook DeployHook) DeployHook {
+	if hook.TimeoutSeconds == 0 {
+		hook.TimeoutSeconds = defaultHookTimeout
+	}
+	return hook
+}
+
+func ValidateDeployHooks(hooks []DeployHook) error {
+	if len(hooks) > maxHooks {
+		return fmt.Errorf("no more than %d hooks", maxHooks)
+	}
+	names := map[string]bool{}
+	for _, hook := range hooks {
+		hook = normalizeDeployHook(hook)
+		if hook.Name == "" {
+			return fmt.Errorf("every hook needs a name")
+		}
+		if names[hook.Name] {
+			return fmt.Errorf("duplicate hook %s", hook.Name)
+		}
+		names[hook.Name] = true
+		if !slices.Contains(hookStages, hook.Stage) {
+			return fmt.Errorf("hook %s: stage must be pre-swap, post-swap or failure", hook.Name)
+		}
+		if (hook.Script == "") == (hook.Command == "") {
+			return fmt.Errorf("hook %s: give either a script or a command", hook.Name)
+		}
+		if hook.Script != "" && len(hook.Args) > 0 {
+			return fmt.Errorf("hook %s: args only apply to a command", hook.Name)
+		}
+		if hook.TimeoutSeconds < 1 || hook.TimeoutSeconds > maxHookTimeout {
+			return fmt.Errorf("hook %s: timeoutSeconds must be between 1 and %d", hook.Name, maxHookTimeout)
+		}
+	}
+	return nil
+}
+
+func GetDeployHooksAction(website Website) (DeployHooks, error) {
+	hooks := DeployHooks{}
+	_, err := storeGet(deployHooksBucket, strconv.Itoa(website.ID), &hooks)
+	hooks.SiteID = website.ID
+	hooks.Site = website.Name
+	if hooks.Hooks == nil {
+		hooks.Hooks = []DeployHook{}
+	}
+	return hooks, err
+}
+
+func SetDeployHooksAction(website Website, hooks DeployHooks) (DeployHooks, error) {
+	if err := ValidateDeployHooks(hooks.Hooks); err != nil {
+		return hooks, err
+	}
+	for i, hook := range hooks.Hooks {
+		hooks.Hooks[i] = normalizeDeployHook(hook)
+	}
+	if hooks.Hooks == nil {
+		hooks.Hooks = []DeployHook{}
+	}
+	hooks.SiteID = website.ID
+	hooks.Site = website.Name
+	return hooks, storePut(deployHooksBucket, strconv.Itoa(website.ID), hooks)
+}
+
+// hookOutput keeps the first hookOutputLimit bytes written to it.
+type hookOutput struct {
+	data      []byte
+	truncated bool
+}
+
+// Write always reports the whole of p as written, so the process is never
+// cut off for printing too much.
+func (o *hookOutput) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := hookOutputLimit - len(o.data); n > room {
+		o.truncated = true
+		p = p[:room]
+	}
+	o.data = append(o.data, p...)
+	return n, nil
+}
+
+func (o *hookOutput) String() string {
+	if o.truncated {
+		return string(o.data) + "\n[output truncated]"
+	}
+	return string(o.data)
+}
+
+// hookEnv describes the deployment to its hooks.
+func (d *Deployment) hookEnv(stage string, release string) []string {
+	env := []string{
+		"DEPLOY_STAGE=" + stage,
+		"DEPLOY_ID=" + d.ID,
+		"DEPLOY_KIND=" + d.Kind,
+		"DEPLOY_SITE=" + d.Site,
+		"DEPLOY_SITE_ID=" + strconv.Itoa(d.SiteID),
+		"DEPLOY_RELEASE_ID=" + cmp.Or(d.ReleaseID, d.ID),
+		"DEPLOY_RELEASE_PATH=" + release,
+		"DEPLOY_PREVIOUS_PATH=" + expandPhysicalPath(d.PreviousPath),
+		"DEPLOY_ARCHIVE=" + d.ArchiveName,
+		"DEPLOY_SHA256=" + d.SHA256,
+		"DEPLOY_ERROR=" + d.Error,
+	}
+	if d.Git != nil {
+		env = append(env, "DEPLOY_GIT_COMMIT="+d.Git.Commit, "DEPLOY_GIT_BRANCH="+d.Git.Branch)
+	}
+	return env
+}
+
+// hookCommand builds the process for a hook. Scripts and .ps1 files run in
+// PowerShell; relative command paths are looked up in the release.
+func hookCommand(ctx context.Context, hook DeployHook, dir string) (*exec.Cmd, error) {
+	if hook.Script != "" {
+		return exec.CommandContext(ctx, "powershell.exe", "-NoProfile", "-NonInteractive", "-EncodedCommand", encodePowerShell(hook.Script)), nil
+	}
+	command := hook.Command
+	isScript := strings.EqualFold(filepath.Ext(command), ".ps1")
+	if !filepath.IsAbs(command) && (isScript || strings.ContainsAny(command, `/\`)) {
+		resolved, err := siteRelativeFile(dir, command)
+		if err != nil {
+			return nil, err
+		}
+		command = resolved
+	}
+	if isScript {
+		args := append([]string{"-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", command}, hook.Args...)
+		return exec.CommandContext(ctx, "powershell.exe", args...), nil
+	}
+	return exec.CommandContext(ctx, command, hook.Args...), nil
+}
+
+func runHook(hook DeployHook, dir string, env []string) HookRun {
+	run := HookRun{Name: hook.Name, Stage: hook.Stage, Status: deployDone}
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(hook.TimeoutSeconds)*time.Second)
+	defer cancel()
+	stdout, stderr := &hookOutput{}, &hookOutput{}
+	cmd, err := hookCommand(ctx, hook, dir)
+	if err == nil {
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		// Children left holding the pipes must not hold up the deployment
+		cmd.WaitDelay = 10 * time.Second
+		err = cmd.Run()
+	}
+	run.DurationMs = time.Since(started).Milliseconds()
+	run.Stdout, run.Stderr = stdout.String(), stderr.String()
+	if cmd != nil && cmd.ProcessState != nil {
+		run.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %ds", hook.TimeoutSeconds)
+	}
+	if err != nil {
+		run.Status, run.Error = deployFailed, err.Error()
+	}
+	return run
+}
+
+// runHooks runs a stage's hooks in order, as one step of the deployment.
+// The first failing hook stops the stage, except on failure, where every
+// hook gets its turn. Stages without hooks add no step.
+func (d *Deployment) runHooks(hooks DeployHooks, stage string, release string) error {
+	staged := []DeployHook{}
+	for _, hook := range hooks.Hooks {
+		if hook.Stage == stage {
+			staged = append(staged, hook)
+		}
+	}
+	if len(staged) == 0 {
+		return nil
+	}
+	// Failure hooks run in the site's current directory; the release may
+	// never have been made
+	dir := release
+	if stage == hookFailure {
+		dir = expandPhysicalPath(d.PreviousPath)
+	}
+	env := d.hookEnv(stage, release)
+	return d.step(stage, func() (string, error) {
+		failed := []string{}
+		for _, hook := range staged {
+			run := runHook(hook, dir, env)
+			d.Hooks = append(d.Hooks, run)
+			if run.Status == deployFailed {
+				failed = append(failed, hook.Name)
+				if stage != hookFailure {
+					return "", fmt.Errorf("%s hook %s failed: %s", stage, hook.Name, run.Error)
+				}
+			}
+		}
+		if len(failed) > 0 {
+			return "", fmt.Errorf("%s hooks failed: %s", stage, strings.Join(failed, ", "))
+		}
+		return fmt.Sprintf("ran %d hooks", len(staged)), nil
+	})
+}
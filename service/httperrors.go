@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	httpErrorsFilter      = "system.webServer/httpErrors"
+	errorPageMaxSize      = 1 << 20
+	allSubStatusCodes     = -1
+	errorResponseFile     = "File"
+	errorResponseExecute  = "ExecuteURL"
+	errorResponseRedirect = "Redirect"
+)
+
+var (
+	httpErrorModes        = []string{"Custom", "Detailed", "DetailedLocalOnly"}
+	httpExistingResponses = []string{"Auto", "Replace", "PassThrough"}
+	httpErrorResponses    = []string{errorResponseFile, errorResponseExecute, errorResponseRedirect}
+	envVarPattern         = regexp.MustCompile(`%([^%]+)%`)
+)
+
+// expandPhysicalPath resolves %VAR% references such as %SystemDrive% that
+// IIS allows in physical paths.
+func expandPhysicalPath(path string) string {
+	return envVarPattern.ReplaceAllStringFunc(path, func(ref string) string {
+		if value, ok := os.LookupEnv(strings.Trim(ref, "%")); ok {
+			return value
+		}
+		return ref
+	})
+}
+
+// cleanSitePath normalizes a site-relative path or URL path, refusing
+// anything absolute or climbing out with "..".
+func cleanSitePath(rel string) (string, error) {
+	rel = strings.SplitN(rel, "?", 2)[0]
+	clean := filepath.Clean(filepath.FromSlash(strings.TrimLeft(strings.ReplaceAll(rel, `\`, "/"), "/")))
+	if clean == "." || filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %s must stay inside the site directory", rel)
+	}
+	return clean, nil
+}
+
+// siteRelativeFile maps a site-relative path onto the site's physical
+// directory.
+func siteRelativeFile(root string, rel string) (string, error) {
+	clean, err := cleanSitePath(rel)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(expandPhysicalPath(root), clean), nil
+}
+
+func GetHTTPErrorsAction(site string) (HTTPErrorsConfig, error) {
+	ps := fmt.Sprintf(`Import-Module WebAdministration;
+		$errors = Get-WebConfiguration -PSPath %s -Filter '%s';
+		[PSCustomObject]@{
+			errorMode = [string]$errors.errorMode
+			existingResponse = [string]$errors.existingResponse
+			defaultResponseMode = [string]$errors.defaultResponseMode
+			pages = @($errors.Collection | ForEach-Object {
+				[PSCustomObject]@{
+					statusCode = [int]$_.statusCode
+					subStatusCode = [int]$_.subStatusCode
+					path = [string]$_.path
+					responseMode = [string]$_.responseMode
+					prefixLanguageFilePath = [string]$_.prefixLanguageFilePath
+				}
+			})
+		} | ConvertTo-Json -Depth 4`, sitePSPath(site), httpErrorsFilter)
+	out, err := runPowerShell(ps)
+	if err != nil {
+		return HTTPErrorsConfig{}, fmt.Errorf("failed to read error pages for %s: %v", site, err)
+	}
+	config := HTTPErrorsConfig{}
+	if err := json.Unmarshal(out, &config); err != nil {
+		return HTTPErrorsConfig{}, fmt.Errorf("failed to parse error pages for %s: %v", site, err)
+	}
+	if config.Pages == nil {
+		config.Pages = []ErrorPage{}
+	}
+	return config, nil
+}
+
+// SetHTTPErrorsAction writes any uploaded page content into the site
+// directory, then configures httpErrors. Pages replace inherited entries
+// for the same status and substatus; other inherited entries stay. The
+// section is committed in one ServerManager write, and if that fails the
+// page files are put back as they were.
+func SetHTTPErrorsAction(website Website, config HTTPErrorsConfig) error {
+	config = normalizeHTTPErrors(config)
+	if err := ValidateHTTPErrors(config); err != nil {
+		return err
+	}
+	written, err := writeErrorPages(website, config.Pages)
+	if err != nil {
+		restoreErrorPages(written)
+		return err
+	}
+	pages := []ErrorPage{}
+	for _, page := range config.Pages {
+		page.Content = ""
+		pages = append(pages, page)
+	}
+	payload, err := psJSON(pages)
+	if err != nil {
+		restoreErrorPages(written)
+		return err
+	}
+	ps := fmt.Sprintf(`%s;
+		$manager = New-Object Microsoft.Web.Administration.ServerManager;
+		%s
+		$config = $manager.GetWebConfiguration(%s);
+		$section = $config.GetSection('%s');
+		$section.RevertToParent();
+		$section['errorMode'] = %s;
+		$section['existingResponse'] = %s;
+		$section['defaultResponseMode'] = %s;
+		$collection = $section.GetCollection();
+		foreach ($p in %s) {
+			foreach ($old in @($collection | Where-Object { $_['statusCode'] -eq $p.statusCode -and $_['subStatusCode'] -eq $p.subStatusCode })) { $collection.Remove($old) };
+			$add = $collection.CreateElement('error');
+			$add['statusCode'] = [uint32]$p.statusCode;
+			$add['subStatusCode'] = [int]$p.subStatusCode;
+			$add['path'] = $p.path;
+			$add['responseMode'] = $p.responseMode;
+			if ($p.prefixLanguageFilePath) { $add['prefixLanguageFilePath'] = $p.prefixLanguageFilePath };
+			[void]$collection.Add($add);
+		};
+		$manager.CommitChanges()`,
+		mwaAssembly, mwaSiteCheck(website.Name), psQuote(website.Name), httpErrorsFilter,
+		psQuote(config.ErrorMode), psQuote(config.ExistingResponse), psQuote(config.DefaultResponseMode), payload)
+	if _, err := runPowerShell(ps); err != nil {
+		restoreErrorPages(written)
+		return fmt.Errorf("failed to write error pages for %s: %v", website.Name, err)
+	}
+	return nil
+}
+
+// errorPageFile remembers what a page file held before it was written.
+type errorPageFile struct {
+	path     string
+	existed  bool
+	previous []byte
+}
+
+// writeErrorPages writes uploaded page content, resolving each path as
+// the file manager does so that a link in the site cannot lead a page
+// outside it. It returns what it touched, even on failure.
+func writeErrorPages(website Website, pages []ErrorPage) ([]errorPageFile, error) {
+	written := []errorPageFile{}
+	for _, page := range pages {
+		if page.Content == "" {
+			continue
+		}
+		_, file, err := resolveSitePath(website, page.Path)
+		if err != nil {
+			return written, err
+		}
+		previous, err := os.ReadFile(file)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return written, fmt.Errorf("failed to read %s: %v", page.Path, err)
+		}
+		existed := err == nil
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return written, fmt.Errorf("failed to create directory for %s: %v", page.Path, err)
+		}
+		written = append(written, errorPageFile{path: file, existed: existed, previous: previous})
+		if err := os.WriteFile(file, []byte(page.Content), 0o644); err != nil {
+			return written, fmt.Errorf("failed to write %s: %v", page.Path, err)
+		}
+	}
+	return written, nil
+}
+
+// restoreErrorPages undoes writeErrorPages, newest first so that a file
+// written for two pages ends up with its original content.
+func restoreErrorPages(written []errorPageFile) {
+	for i := len(written) - 1; i >= 0; i-- {
+		file := written[i]
+		var err error
+		if file.existed {
+			err = os.WriteFile(file.path, file.previous, 0o644)
+		} else {
+			err = os.Remove(file.path)
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("failed to restore error page %s: %v", file.path, err)
+		}
+	}
+}
+
+func normalizeHTTPErrors(config HTTPErrorsConfig) HTTPErrorsConfig {
+	if config.ExistingResponse == "" {
+		config.ExistingResponse = "Auto"
+	}
+	if config.DefaultResponseMode == "" {
+		config.DefaultResponseMode = errorResponseFile
+	}
+	for i, page := range config.Pages {
+		if page.SubStatusCode == nil {
+			all := allSubStatusCodes
+			config.Pages[i].SubStatusCode = &all
+		}
+		if page.ResponseMode == "" {
+			config.Pages[i].ResponseMode = config.DefaultResponseMode
+		}
+	}
+	return config
+}
+
+func ValidateHTTPErrors(config HTTPErrorsConfig) error {
+	config = normalizeHTTPErrors(config)
+	if !slices.Contains(httpErrorModes, config.ErrorMode) {
+		return fmt.Errorf("errorMode must be one of %s", strings.Join(httpErrorModes, ", "))
+	}
+	if !slices.Contains(httpExistingResponses, config.ExistingResponse) {
+		return fmt.Errorf("existingResponse must be one of %s", strings.Join(httpExistingResponses, ", "))
+	}
+	if !slices.Contains(httpErrorResponses, config.DefaultResponseMode) {
+		return fmt.Errorf("defaultResponseMode must be one of %s", strings.Join(httpErrorResponses, ", "))
+	}
+	seen := map[string]bool{}
+	for _, page := range config.Pages {
+		if page.StatusCode < 400 || page.StatusCode > 999 {
+			return fmt.Errorf("status code %d is not an error status", page.StatusCode)
+		}
+		if *page.SubStatusCode < allSubStatusCodes || *page.SubStatusCode > 999 {
+			return fmt.Errorf("status %d: invalid substatus %d", page.StatusCode, *page.SubStatusCode)
+		}
+		key := fmt.Sprintf("%d.%d", page.StatusCode, *page.SubStatusCode)
+		if seen[key] {
+			return fmt.Errorf("duplicate page for status %s", key)
+		}
+		seen[key] = true
+		if !slices.Contains(httpErrorResponses, page.ResponseMode) {
+			return fmt.Errorf("status %s: responseMode must be one of %s", key, strings.Join(httpErrorResponses, ", "))
+		}
+		if page.Path == "" {
+			return fmt.Errorf("status %s: path is required", key)
+		}
+		if page.PrefixLanguageFilePath != "" && page.Content != "" {
+			return fmt.Errorf("status %s: content cannot be uploaded for a page under a language prefix", key)
+		}
+		switch page.ResponseMode {
+		case errorResponseRedirect:
+			if page.Content != "" {
+				return fmt.Errorf("status %s: content cannot be uploaded for a redirect", key)
+			}
+		case errorResponseExecute:
+			if !strings.HasPrefix(page.Path, "/") {
+				return fmt.Errorf("status %s: ExecuteURL paths must start with /", key)
+			}
+		}
+		if page.ResponseMode != errorResponseRedirect {
+			if _, err := cleanSitePath(page.Path); err != nil {
+				return fmt.Errorf("status %s: %v", key, err)
+			}
+		}
+		if len(page.Content) > errorPageMaxSize {
+			return fmt.Errorf("status %s: content exceeds %d bytes", key, errorPageMaxSize)
+		}
+	}
+	return nil
+}
+
+// HTTPErrorWarnings reports pages whose file is missing from the site's
+// physical path. Entries with a language prefix, like the server defaults
+// under inetpub\custerr, live outside the site and are skipped.
+func HTTPErrorWarnings(website Website, config HTTPErrorsConfig) []string {
+	warnings := []string{}
+	for _, page := range config.Pages {
+		if page.ResponseMode == errorResponseRedirect || page.PrefixLanguageFilePath != "" {
+			continue
+		}
+		_, file, err := resolveSitePath(website, page.Path)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("status %d: %v", page.StatusCode, err))
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			warnings = append(warnings, fmt.Sprintf("status %d: %s does not exist in %s", page.StatusCode, page.Path, website.PhysicalPath))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateHTTPErrors(t *testing.T) {
+	page := func(status int, path string, mode string) ErrorPage {
+		return ErrorPage{StatusCode: status, Path: path, ResponseMode: mode}
+	}
+	tests := []struct {
+		name  string
+		pages []ErrorPage
+		err   string
+	}{
+		{"file and url", []ErrorPage{page(404, "/errors/404.html", errorResponseExecute), page(500, "500.html", "")}, ""},
+		{"language prefix", []ErrorPage{{StatusCode: 404, Path: "404.htm", PrefixLanguageFilePath: `%SystemDrive%\inetpub\custerr`}}, ""},
+		{"content under a language prefix", []ErrorPage{{StatusCode: 404, Path: "404.htm", PrefixLanguageFilePath: `%SystemDrive%\inetpub\custerr`, Content: "x"}}, "language prefix"},
+		{"duplicate", []ErrorPage{page(404, "a.html", ""), page(404, "b.html", "")}, "duplicate"},
+		{"parent", []ErrorPage{page(404, "../404.html", "")}, "inside the site"},
+		{"not an error", []ErrorPage{page(302, "302.html", "")}, "not an error status"},
+		{"relative url", []ErrorPage{page(404, "404.aspx", errorResponseExecute)}, "must start with /"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateHTTPErrors(HTTPErrorsConfig{ErrorMode: "Custom", Pages: test.pages})
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestWriteErrorPages(t *testing.T) {
+	site, root, secret := linkedSite(t)
+
+	// Links in the site cannot lead a page outside it
+	for _, path := range []string{"escape/404.html", "relup/404.html", "sub/deep/404.html"} {
+		written, err := writeErrorPages(site, []ErrorPage{{StatusCode: 404, Path: path, Content: "gone"}})
+		if !errors.Is(err, errOutsideSite) || len(written) != 0 {
+			t.Fatalf("%s: expected %v, got %v", path, errOutsideSite, err)
+		}
+	}
+	entries, err := os.ReadDir(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := fileNames(entries); !slices.Equal(names, []string{"pw.txt"}) {
+		t.Fatalf("the secret directory changed: %v", names)
+	}
+
+	// Written pages are put back as they were, including a file written
+	// for two pages
+	pages := []ErrorPage{
+		{StatusCode: 404, Path: "/errors/404.html?lang=en", Content: "not found"},
+		{StatusCode: 500, Path: "a.txt", Content: "first"},
+		{StatusCode: 503, Path: "a.txt", Content: "second"},
+		{StatusCode: 502, Path: "inside/502.html", Content: "bad gateway"},
+	}
+	written, err := writeErrorPages(site, pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{"errors/404.html": "not found", "a.txt": "second", "sub/502.html": "bad gateway"} {
+		if content, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(path))); err != nil || string(content) != want {
+			t.Fatalf("%s: expected %q, got %q %v", path, want, content, err)
+		}
+	}
+	restoreErrorPages(written)
+	if content, err := os.ReadFile(filepath.Join(root, "a.txt")); err != nil || string(content) != "a" {
+		t.Fatalf("expected a.txt restored, got %q %v", content, err)
+	}
+	for _, path := range []string{"errors/404.html", "sub/502.html"} {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(path))); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%s: expected it removed, got %v", path, err)
+		}
+	}
+}
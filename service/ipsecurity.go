@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var ipSecurityDenyActions = []string{"Unauthorized", "Forbidden", "NotFound", "AbortRequest"}
+
+// ipSecurityEntry is an ipSecurity collection entry as IIS stores it, with
+// the address and mask kept apart.
+type ipSecurityEntry struct {
+	IPAddress  string `json:"ipAddress"`
+	SubnetMask string `json:"subnetMask"`
+	DomainName string `json:"domainName"`
+	Allowed    bool   `json:"allowed"`
+}
+
+type ipSecurityConfig struct {
+	AllowUnlisted    bool              `json:"allowUnlisted"`
+	DenyAction       string            `json:"denyAction"`
+	EnableProxyMode  bool              `json:"enableProxyMode"`
+	EnableReverseDNS bool              `json:"enableReverseDns"`
+	Entries          []ipSecurityEntry `json:"entries"`
+	Dynamic          DynamicIPSecurity `json:"dynamic"`
+}
+
+// GetIPSecurityAction reads the ipSecurity and dynamicIpSecurity sections
+// for a site, or for the server when site is empty. Site policies include
+// the entries inherited from the server, flagged as such.
+func GetIPSecurityAction(site string) (IPSecurityPolicy, error) {
+	config, err := readIPSecurity(site)
+	if err != nil {
+		return IPSecurityPolicy{}, err
+	}
+	inherited := map[string]bool{}
+	if site != "" {
+		server, err := readIPSecurity("")
+		if err != nil {
+			return IPSecurityPolicy{}, err
+		}
+		for _, entry := range server.Entries {
+			inherited[ipSecurityKey(entry)] = true
+		}
+	}
+	policy := IPSecurityPolicy{
+		Scope:            "server",
+		AllowUnlisted:    config.AllowUnlisted,
+		DenyAction:       config.DenyAction,
+		EnableProxyMode:  config.EnableProxyMode,
+		EnableReverseDNS: config.EnableReverseDNS,
+		Rules:            []IPSecurityRule{},
+		Dynamic:          config.Dynamic,
+	}
+	if site != "" {
+		policy.Scope = "site"
+	}
+	for _, entry := range config.Entries {
+		rule := IPSecurityRule{Domain: entry.DomainName, Allowed: entry.Allowed, Inherited: inherited[ipSecurityKey(entry)]}
+		if entry.IPAddress != "" {
+			cidr, err := entryToCIDR(entry.IPAddress, entry.SubnetMask)
+			if err != nil {
+				return IPSecurityPolicy{}, err
+			}
+			rule.CIDR = cidr
+		}
+		policy.Rules = append(policy.Rules, rule)
+	}
+	return policy, nil
+}
+
+func readIPSecurity(site string) (ipSecurityConfig, error) {
+	ps := fmt.Sprintf(`Import-Module WebAdministration; %s
+		$ip = Get-WebConfiguration @scope -Filter 'system.webServer/security/ipSecurity';
+		$dyn = Get-WebConfiguration @scope -Filter 'system.webServer/security/dynamicIpSecurity';
+		[PSCustomObject]@{
+			allowUnlisted = [bool]$ip.allowUnlisted
+			denyAction = [string]$ip.denyAction
+			enableProxyMode = [bool]$ip.enableProxyMode
+			enableReverseDns = [bool]$ip.enableReverseDns
+			entries = @($ip.Collection | ForEach-Object {
+				[PSCustomObject]@{ ipAddress = $_.ipAddress; subnetMask = $_.subnetMask; domainName = $_.domainName; allowed = [bool]$_.allowed }
+			})
+			dynamic = [PSCustomObject]@{
+				denyAction = [string]$dyn.denyAction
+				loggingOnly = [bool]$dyn.enableLoggingOnlyMode
+				concurrentEnabled = [bool]$dyn.denyByConcurrentRequests.enabled
+				maxConcurrentRequests = [int]$dyn.denyByConcurrentRequests.maxConcurrentRequests
+				rateEnabled = [bool]$dyn.denyByRequestRate.enabled
+				maxRequests = [int]$dyn.denyByRequestRate.maxRequests
+				requestIntervalMs = [int]$dyn.denyByRequestRate.requestIntervalInMilliseconds
+			}
+		} | ConvertTo-Json -Depth 4`, apphostScope(site))
+	out, err := runPowerShell(ps)
+	if err != nil {
+		return ipSecurityConfig{}, fmt.Errorf("failed to read ip security for %s: %v", scopeName(site), err)
+	}
+	config := ipSecurityConfig{}
+	if err := json.Unmarshal(out, &config); err != nil {
+		return ipSecurityConfig{}, fmt.Errorf("failed to parse ip security for %s: %v", scopeName(site), err)
+	}
+	return config, nil
+}
+
+// SetIPSecurityAction writes the policy at site or server level. Inherited
+// rules are left to the parent level and only local rules are written.
+func SetIPSecurityAction(site string, policy IPSecurityPolicy) error {
+	if err := ValidateIPSecurityPolicy(policy); err != nil {
+		return err
+	}
+	entries := []ipSecurityEntry{}
+	for _, rule := range policy.Rules {
+		if rule.Inherited {
+			continue
+		}
+		entry := ipSecurityEntry{DomainName: rule.Domain, Allowed: rule.Allowed}
+		if rule.CIDR != "" {
+			entry.IPAddress, entry.SubnetMask = cidrToEntry(rule.CIDR)
+		}
+		entries = append(entries, entry)
+	}
+	payload, err := psJSON(entries)
+	if err != nil {
+		return err
+	}
+	dyn := withDynamicDefaults(policy.Dynamic)
+	// Both sections are built in one ServerManager and committed once, so
+	// a failure part way leaves the policy as it was. Only entries stored
+	// at this level are replaced; inherited ones stay with the parent.
+	ps := fmt.Sprintf(`%s;
+		$manager = New-Object Microsoft.Web.Administration.ServerManager;
+		%s
+		$config = $manager.GetApplicationHostConfiguration();
+		$ip = %s;
+		$ip['allowUnlisted'] = $%t;
+		$ip['denyAction'] = %s;
+		$ip['enableProxyMode'] = $%t;
+		$ip['enableReverseDns'] = $%t;
+		$collection = $ip.GetCollection();
+		foreach ($old in @($collection | Where-Object { $_.IsLocallyStored })) { $collection.Remove($old) };
+		foreach ($e in %s) {
+			$add = $collection.CreateElement('add');
+			$add['allowed'] = [bool]$e.allowed;
+			if ($e.ipAddress) { $add['ipAddress'] = $e.ipAddress; $add['subnetMask'] = $e.subnetMask } else { $add['domainName'] = $e.domainName };
+			[void]$collection.Add($add);
+		};
+		$dyn = %s;
+		$dyn['denyAction'] = %s;
+		$dyn['enableLoggingOnlyMode'] = $%t;
+		$concurrent = $dyn.GetChildElement('denyByConcurrentRequests');
+		$concurrent['enabled'] = $%t;
+		$concurrent['maxConcurrentRequests'] = [uint32]%d;
+		$rate = $dyn.GetChildElement('denyByRequestRate');
+		$rate['enabled'] = $%t;
+		$rate['maxRequests'] = [uint32]%d;
+		$rate['requestIntervalInMilliseconds'] = [uint32]%d;
+		$manager.CommitChanges()`,
+		mwaAssembly, mwaSiteCheck(site),
+		apphostSection("system.webServer/security/ipSecurity", site),
+		policy.AllowUnlisted, psQuote(policy.DenyAction), policy.EnableProxyMode, policy.EnableReverseDNS, payload,
+		apphostSection("system.webServer/security/dynamicIpSecurity", site),
+		psQuote(dyn.DenyAction), dyn.LoggingOnly,
+		dyn.ConcurrentEnabled, dyn.MaxConcurrentRequests,
+		dyn.RateEnabled, dyn.MaxRequests, dyn.RequestIntervalMs)
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to write ip security for %s: %v", scopeName(site), err)
+	}
+	return nil
+}
+
+// withDynamicDefaults fills in IIS's defaults for unset dynamic settings,
+// since the schema rejects zero for all of them.
+func withDynamicDefaults(dyn DynamicIPSecurity) DynamicIPSecurity {
+	if dyn.DenyAction == "" {
+		dyn.DenyAction = "Forbidden"
+	}
+	if dyn.MaxConcurrentRequests == 0 {
+		dyn.MaxConcurrentRequests = 5
+	}
+	if dyn.MaxRequests == 0 {
+		dyn.MaxRequests = 20
+	}
+	if dyn.RequestIntervalMs == 0 {
+		dyn.RequestIntervalMs = 200
+	}
+	return dyn
+}
+
+func ValidateIPSecurityPolicy(policy IPSecurityPolicy) error {
+	if !slices.Contains(ipSecurityDenyActions, policy.DenyAction) {
+		return fmt.Errorf("denyAction must be one of %s", strings.Join(ipSecurityDenyActions, ", "))
+	}
+	for _, rule := range policy.Rules {
+		switch {
+		case rule.CIDR != "" && rule.Domain != "":
+			return fmt.Errorf("rule cannot have both cidr and domain")
+		case rule.CIDR != "":
+			if _, err := parseCIDR(rule.CIDR); err != nil {
+				return err
+			}
+		case rule.Domain != "":
+			if !policy.EnableReverseDNS {
+				return fmt.Errorf("domain rule %s requires enableReverseDns", rule.Domain)
+			}
+		default:
+			return fmt.Errorf("rule needs a cidr or a domain")
+		}
+	}
+	dyn := policy.Dynamic
+	if dyn.DenyAction != "" && !slices.Contains(ipSecurityDenyActions, dyn.DenyAction) {
+		return fmt.Errorf("dynamic denyAction must be one of %s", strings.Join(ipSecurityDenyActions, ", "))
+	}
+	if dyn.ConcurrentEnabled && dyn.MaxConcurrentRequests < 1 {
+		return fmt.Errorf("maxConcurrentRequests must be at least 1")
+	}
+	if dyn.RateEnabled && (dyn.MaxRequests < 1 || dyn.RequestIntervalMs < 1) {
+		return fmt.Errorf("maxRequests and requestIntervalMs must be at least 1")
+	}
+	return nil
+}
+
+// parseCIDR accepts CIDR notation or a bare address, which is taken as a
+// single host.
+func parseCIDR(value string) (netip.Prefix, error) {
+	if !strings.Contains(value, "/") {
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid cidr %s", value)
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid cidr %s", value)
+	}
+	if prefix.Masked() != prefix {
+		return netip.Prefix{}, fmt.Errorf("invalid cidr %s: host bits set, did you mean %s", value, prefix.Masked())
+	}
+	return prefix, nil
+}
+
+// cidrToEntry splits a prefix into IIS's ipAddress and subnetMask. IPv4
+// masks are written dotted, IPv6 masks as a prefix length.
+func cidrToEntry(cidr string) (string, string) {
+	prefix, _ := parseCIDR(cidr)
+	if prefix.Addr().Is4() {
+		mask := net.CIDRMask(prefix.Bits(), 32)
+		return prefix.Addr().String(), net.IP(mask).String()
+	}
+	return prefix.Addr().String(), strconv.Itoa(prefix.Bits())
+}
+
+func entryToCIDR(address string, mask string) (string, error) {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid ip address %s in configuration", address)
+	}
+	bits := addr.BitLen()
+	if mask != "" {
+		if n, err := strconv.Atoi(mask); err == nil {
+			bits = n
+		} else if ip := net.ParseIP(mask).To4(); ip != nil {
+			bits, _ = net.IPMask(ip).Size()
+		}
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return "", fmt.Errorf("invalid subnet mask %s in configuration", mask)
+	}
+	return prefix.String(), nil
+}
+
+func ipSecurityKey(entry ipSecurityEntry) string {
+	return strings.ToLower(entry.IPAddress + "|" + entry.SubnetMask + "|" + entry.DomainName)
+}
+
+// EvaluateIPSecurity decides whether ip may reach the site under policy.
+// Rules are checked in order and the first match wins, as in IIS's ordered
+// list; unmatched clients fall back to allowUnlisted. hostnames are the
+// reverse DNS names for ip and are only used for domain rules.
+func EvaluateIPSecurity(policy IPSecurityPolicy, ip string, hostnames []string) (IPSecurityDecision, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return IPSecurityDecision{}, fmt.Errorf("invalid ip %s", ip)
+	}
+	addr = addr.Unmap()
+	decision := IPSecurityDecision{
+		IP:         addr.String(),
+		Scope:      policy.Scope,
+		Allowed:    policy.AllowUnlisted,
+		DenyAction: policy.DenyAction,
+		Reason:     "no rule matched, using default action",
+		Dynamic:    policy.Dynamic,
+	}
+	for _, rule := range policy.Rules {
+		matched := false
+		if rule.CIDR != "" {
+			prefix, err := parseCIDR(rule.CIDR)
+			matched = err == nil && prefix.Contains(addr)
+		} else if policy.EnableReverseDNS {
+			for _, host := range hostnames {
+				if domainMatches(rule.Domain, host) {
+					matched = true
+					break
+				}
+			}
+		}
+		if matched {
+			match := rule
+			decision.MatchedRule = &match
+			decision.Allowed = rule.Allowed
+			decision.Reason = "matched rule"
+			if rule.Inherited {
+				decision.Reason = "matched rule inherited from server"
+			}
+			break
+		}
+	}
+	if decision.Allowed {
+		decision.DenyAction = ""
+	}
+	return decision, nil
+}
+
+// domainMatches compares a host against an ipSecurity domainName, which may
+// start with a *. wildcard.
+func domainMatches(pattern string, host string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return pattern == host
+}
+
+// EffectiveIPSecurityAction evaluates ip against the live policy of a site,
+// or of the server when site is empty.
+func EffectiveIPSecurityAction(site string, ip string) (IPSecurityDecision, error) {
+	policy, err := GetIPSecurityAction(site)
+	if err != nil {
+		return IPSecurityDecision{}, err
+	}
+	hostnames := []string{}
+	if policy.EnableReverseDNS {
+		hostnames, _ = net.LookupAddr(ip)
+	}
+	return EvaluateIPSecurity(policy, ip, hostnames)
+}
+
+func scopeName(site string) string {
+	if site == "" {
+		return "server"
+	}
+	return site
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	requestLimitsFilter  = "system.webServer/security/requestFiltering/requestLimits"
+	maxConnectionTimeout = 24 * 60 * 60
+	minBandwidth         = 1024
+	maxURLSegmentsLimit  = 16383
+)
+
+// siteElementFilter addresses a site's element in applicationHost.config.
+func siteElementFilter(site string) string {
+	return fmt.Sprintf("system.applicationHost/sites/site[@name=%s]", xpathLiteral(site))
+}
+
+// xpathLiteral quotes s as an XPath string. XPath has no escapes, so a
+// value holding both kinds of quote is pieced together with concat().
+func xpathLiteral(s string) string {
+	switch {
+	case !strings.Contains(s, "'"):
+		return "'" + s + "'"
+	case !strings.Contains(s, `"`):
+		return `"` + s + `"`
+	}
+	parts := []string{}
+	for i, piece := range strings.Split(s, "'") {
+		if i > 0 {
+			parts = append(parts, `"'"`)
+		}
+		if piece != "" {
+			parts = append(parts, "'"+piece+"'")
+		}
+	}
+	return "concat(" + strings.Join(parts, ", ") + ")"
+}
+
+func GetSiteLimitsAction(site string) (SiteLimits, error) {
+	ps := fmt.Sprintf(`Import-Module WebAdministration;
+		$limits = Get-WebConfiguration -PSPath 'MACHINE/WEBROOT/APPHOST' -Filter %s;
+		$request = Get-WebConfiguration -PSPath %s -Filter '%s';
+		[PSCustomObject]@{
+			connectionTimeoutSeconds = [int64]([TimeSpan]$limits.connectionTimeout).TotalSeconds
+			maxConnections = [int64]$limits.maxConnections
+			maxBandwidth = [int64]$limits.maxBandwidth
+			maxUrlSegments = [int64]$limits.maxUrlSegments
+			maxAllowedContentLength = [int64]$request.maxAllowedContentLength
+			maxUrl = [int64]$request.maxUrl
+			maxQueryString = [int64]$request.maxQueryString
+		} | ConvertTo-Json`, psQuote(siteElementFilter(site)+"/limits"), sitePSPath(site), requestLimitsFilter)
+	out, err := runPowerShell(ps)
+	if err != nil {
+		return SiteLimits{}, fmt.Errorf("failed to read limits for %s: %v", site, err)
+	}
+	limits := SiteLimits{}
+	if err := json.Unmarshal(out, &limits); err != nil {
+		return SiteLimits{}, fmt.Errorf("failed to parse limits for %s: %v", site, err)
+	}
+	return limits, nil
+}
+
+func SetSiteLimitsAction(site string, limits SiteLimits) error {
+	if err := ValidateSiteLimits(limits); err != nil {
+		return err
+	}
+	timeout := time.Duration(limits.ConnectionTimeoutSeconds) * time.Second
+	ps := fmt.Sprintf(`Import-Module WebAdministration;
+		$apphost = 'MACHINE/WEBROOT/APPHOST'; $filter = %s;
+		Set-WebConfigurationProperty -PSPath $apphost -Filter $filter -Name connectionTimeout -Value '%02d:%02d:%02d';
+		Set-WebConfigurationProperty -PSPath $apphost -Filter $filter -Name maxConnections -Value %d;
+		Set-WebConfigurationProperty -PSPath $apphost -Filter $filter -Name maxBandwidth -Value %d;
+		Set-WebConfigurationProperty -PSPath $apphost -Filter $filter -Name maxUrlSegments -Value %d;
+		$pspath = %s;
+		Set-WebConfigurationProperty -PSPath $pspath -Filter '%s' -Name maxAllowedContentLength -Value %d;
+		Set-WebConfigurationProperty -PSPath $pspath -Filter '%s' -Name maxUrl -Value %d;
+		Set-WebConfigurationProperty -PSPath $pspath -Filter '%s' -Name maxQueryString -Value %d`,
+		psQuote(siteElementFilter(site)+"/limits"),
+		int(timeout.Hours()), int(timeout.Minutes())%60, int(timeout.Seconds())%60,
+		limits.MaxConnections, limits.MaxBandwidth, limits.MaxURLSegments,
+		sitePSPath(site),
+		requestLimitsFilter, limits.MaxAllowedContentLength,
+		requestLimitsFilter, limits.MaxURL,
+		requestLimitsFilter, limits.MaxQueryString)
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to write limits for %s: %v", site, err)
+	}
+	return nil
+}
+
+// ValidateSiteLimits checks values against the ranges IIS accepts. The
+// largest uint32 means unlimited for connections and bandwidth.
+func ValidateSiteLimits(limits SiteLimits) error {
+	if limits.ConnectionTimeoutSeconds < 1 || limits.ConnectionTimeoutSeconds > maxConnectionTimeout {
+		return fmt.Errorf("connectionTimeoutSeconds must be between 1 and %d", maxConnectionTimeout)
+	}
+	if limits.MaxConnections < 1 {
+		return fmt.Errorf("maxConnections must be at least 1")
+	}
+	if limits.MaxBandwidth < minBandwidth {
+		return fmt.Errorf("maxBandwidth must be at least %d bytes per second", minBandwidth)
+	}
+	if limits.MaxURLSegments < 1 || limits.MaxURLSegments > maxURLSegmentsLimit {
+		return fmt.Errorf("maxUrlSegments must be between 1 and %d", maxURLSegmentsLimit)
+	}
+	if limits.MaxAllowedContentLength < 1 {
+		return fmt.Errorf("maxAllowedContentLength must be at least 1")
+	}
+	if limits.MaxURL < 1 {
+		return fmt.Errorf("maxUrl must be at least 1")
+	}
+	if limits.MaxQueryString < 1 {
+		return fmt.Errorf("maxQueryString must be at least 1")
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	defaultPageSize = 25
+	maxPageSize     = 500
+)
+
+// websiteSortKeys compares two websites on one column.
+var websiteSortKeys = map[string]func(a, b Website) int{
+	"name":         func(a, b Website) int { return cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)) },
+	"id":           func(a, b Website) int { return cmp.Compare(a.ID, b.ID) },
+	"state":        func(a, b Website) int { return cmp.Compare(a.State, b.State) },
+	"physicalPath": func(a, b Website) int { return cmp.Compare(strings.ToLower(a.PhysicalPath), strings.ToLower(b.PhysicalPath)) },
+	"protocol":     func(a, b Website) int { return cmp.Compare(a.Binding.Protocol, b.Binding.Protocol) },
+	"port":         func(a, b Website) int { return cmp.Compare(a.Binding.Port, b.Binding.Port) },
+	"host":         func(a, b Website) int { return cmp.Compare(strings.ToLower(a.Binding.Host), strings.ToLower(b.Binding.Host)) },
+	"ssl":          func(a, b Website) int { return cmp.Compare(boolRank(a.Binding.SSL), boolRank(b.Binding.SSL)) },
+	"health":       func(a, b Website) int { return cmp.Compare(healthRank(a.Health), healthRank(b.Health)) },
+}
+
+// healthRanks orders health from best to worst so that an ascending sort
+// puts working sites first. Sites never checked sort last.
+var healthRanks = map[string]int{
+	healthHealthy:     0,
+	healthDegraded:    1,
+	healthMaintenance: 2,
+	healthStopped:     3,
+	healthUnhealthy:   4,
+	healthUnknown:     5,
+}
+
+func healthRank(health *HealthSummary) int {
+	if health == nil {
+		return len(healthRanks)
+	}
+	if rank, ok := healthRanks[health.Status]; ok {
+		return rank
+	}
+	return len(healthRanks)
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ListWebsitesAction reads every site with its bindings in one PowerShell
+// call, instead of parsing the Get-Website table, so the list costs the same
+// on a server with hundreds of sites as on one with a few.
+func ListWebsitesAction() ([]Website, error) {
+	ps := `Import-Module WebAdministration;
+		$sites = Get-ChildItem IIS:\Sites | ForEach-Object {
+			[PSCustomObject]@{
+				name = $_.Name
+				id = [int]$_.id
+				state = [string]$_.State
+				physicalPath = $_.physicalPath
+				bindings = @($_.Bindings.Collection | ForEach-Object { [PSCustomObject]@{ protocol = $_.protocol; bindingInformation = $_.bindingInformation; sslFlags = [int]$_.sslFlags } })
+			}
+		};
+		ConvertTo-Json -InputObject @($sites) -Depth 4`
+	out, err := runPowerShell(ps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list websites: %v", err)
+	}
+	return parseWebsiteList(out)
+}
+
+func parseWebsiteList(out []byte) ([]Website, error) {
+	raw, err := decodePSList[struct {
+		Name         string `json:"name"`
+		ID           int    `json:"id"`
+		State        string `json:"state"`
+		PhysicalPath string `json:"physicalPath"`
+		Bindings     []struct {
+			Protocol           string `json:"protocol"`
+			BindingInformation string `json:"bindingInformation"`
+			SSLFlags           int    `json:"sslFlags"`
+		} `json:"bindings"`
+	}](out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse websites: %v", err)
+	}
+	websites := []Website{}
+	for _, site := range raw {
+		website := Website{Name: site.Name, ID: site.ID, State: site.State, PhysicalPath: site.PhysicalPath}
+		// Like getSites, the list shows the first web binding
+		for _, b := range site.Bindings {
+			if b.Protocol != "http" && b.Protocol != "https" {
+				continue
+			}
+			binding, ok := parseBindingInformation(b.Protocol, b.BindingInformation)
+			if !ok {
+				continue
+			}
+			host := binding.Host
+			if host == "" {
+				host = "localhost"
+			}
+			website.Binding = Binding{Protocol: binding.Protocol, Port: binding.Port, Host: host, SSL: b.SSLFlags&1 == 1}
+			break
+		}
+		websites = append(websites, website)
+	}
+	return websites, nil
+}
+
+// Paged reports whether the caller asked for a page rather than the plain
+// list the endpoint has always returned.
+func (q WebsiteQuery) Paged() bool {
+	return q.Page > 0 || q.PageSize > 0
+}
+
+func ValidateWebsiteQuery(query WebsiteQuery) error {
+	if query.Page < 0 {
+		return fmt.Errorf("page must be at least 1")
+	}
+	if query.PageSize < 0 || query.PageSize > maxPageSize {
+		return fmt.Errorf("pageSize must be between 1 and %d", maxPageSize)
+	}
+	if query.Sort != "" {
+		if _, ok := websiteSortKeys[query.Sort]; !ok {
+			keys := []string{}
+			for key := range websiteSortKeys {
+				keys = append(keys, key)
+			}
+			slices.Sort(keys)
+			return fmt.Errorf("sort must be one of %s", strings.Join(keys, ", "))
+		}
+	}
+	if query.Order != "" && query.Order != "asc" && query.Order != "desc" {
+		return fmt.Errorf("order must be asc or desc")
+	}
+	return nil
+}
+
+// QueryWebsites filters, sorts and pages websites. Websites must already
+// carry their metadata for selector and owner filters to apply.
+func QueryWebsites(websites []Website, query WebsiteQuery) (WebsitePage, error) {
+	if err := ValidateWebsiteQuery(query); err != nil {
+		return WebsitePage{}, err
+	}
+	filtered := websites
+	if query.Selector != "" {
+		selected, err := SelectWebsites(filtered, query.Selector)
+		if err != nil {
+			return WebsitePage{}, err
+		}
+		filtered = selected
+	}
+	filtered = slices.DeleteFunc(slices.Clone(filtered), func(w Website) bool {
+		return !websiteMatchesQuery(w, query)
+	})
+
+	if query.Sort != "" {
+		compare := websiteSortKeys[query.Sort]
+		slices.SortStableFunc(filtered, func(a, b Website) int {
+			if query.Order == "desc" {
+				return compare(b, a)
+			}
+			return compare(a, b)
+		})
+	}
+
+	page := WebsitePage{Items: filtered, Total: len(filtered), Page: 1, PageSize: len(filtered), TotalPages: 1}
+	if !query.Paged() {
+		return page, nil
+	}
+	page.Page = max(query.Page, 1)
+	page.PageSize = query.PageSize
+	if page.PageSize == 0 {
+		page.PageSize = defaultPageSize
+	}
+	page.TotalPages = (page.Total + page.PageSize - 1) / page.PageSize
+	start := min((page.Page-1)*page.PageSize, page.Total)
+	end := min(start+page.PageSize, page.Total)
+	page.Items = filtered[start:end]
+	return page, nil
+}
+
+func websiteMatchesQuery(website Website, query WebsiteQuery) bool {
+	if query.Q != "" {
+		q := strings.ToLower(query.Q)
+		if !strings.Contains(strings.ToLower(website.Name), q) &&
+			!strings.Contains(strings.ToLower(website.Binding.Host), q) &&
+			!strings.Contains(strings.ToLower(website.PhysicalPath), q) {
+			return false
+		}
+	}
+	if query.State != "" && !strings.EqualFold(website.State, query.State) {
+		return false
+	}
+	if query.Protocol != "" && !strings.EqualFold(website.Binding.Protocol, query.Protocol) {
+		return false
+	}
+	if query.Port != 0 && website.Binding.Port != query.Port {
+		return false
+	}
+	if query.Owner != "" && (website.Metadata == nil || !strings.EqualFold(website.Metadata.Owner, query.Owner)) {
+		return false
+	}
+	return true
+}
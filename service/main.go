@@ -2,38 +2,11 @@ package main
 
 import (
 	"log"
-	"syscall"
-	"unsafe"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-var (
-	modAdvapi32              = syscall.NewLazyDLL("advapi32.dll")
-	procCheckTokenMembership = modAdvapi32.NewProc("CheckTokenMembership")
-)
-
-func IsAdmin() (bool, error) {
-	var sid *syscall.SID
-	// BUILTIN\Administrators group
-	sid, err := syscall.StringToSid("S-1-5-32-544")
-	if err != nil {
-		return false, err
-	}
-
-	var isAdmin uint32
-	ret, _, err := procCheckTokenMembership.Call(
-		0,
-		uintptr(unsafe.Pointer(sid)),
-		uintptr(unsafe.Pointer(&isAdmin)),
-	)
-	if ret == 0 {
-		return false, err
-	}
-	return isAdmin != 0, nil
-}
-
 func main() {
 	isAdmin, err := IsAdmin()
 	if err != nil {
@@ -42,8 +15,16 @@ func main() {
 	if !isAdmin {
 		log.Fatal("You must be an administrator to run this program")
 	}
+	if err := OpenStore(); err != nil {
+		log.Fatal(err)
+	}
+	StartSnapshotScheduler()
+	StartDriftScheduler()
+	StartHealthScheduler()
+	StartMaintenanceScheduler()
 	r := gin.Default()
 	r.Use(cors.Default())
+	r.Use(SnapshotBeforeMutation())
 	// Machine state
 	r.GET("/api/machine/info", GetMachineInfoEndpoint)
 	r.GET("/api/machine/process", GetMachineProcessEndpoint)
@@ -52,9 +33,123 @@ func main() {
 	r.GET("/api/website", GetWebsitesEndpoint)
 	r.GET("/api/website/:name", GetWebsiteEndpoint)
 	r.POST("/api/website", PostCreateWebsiteEndpoint)
-	r.PUT("/api/website/:original", PutUpdateWebsiteEndpoint)
+	r.POST("/api/website/bulk", PostBulkEndpoint)
+	r.PUT("/api/website/:name", PutUpdateWebsiteEndpoint)
 	r.PATCH("/api/website/:site/:action", PatchStatusEndpoint)
 	r.DELETE("/api/website/:name", DeleteWebsiteEndpoint)
+	// Labels and metadata
+	r.GET("/api/website/:name/metadata", GetSiteMetadataEndpoint)
+	r.PUT("/api/website/:name/metadata", PutSiteMetadataEndpoint)
+	// URL Rewrite
+	r.GET("/api/website/:name/rewrite", GetRewriteRulesEndpoint)
+	r.POST("/api/website/:name/rewrite", PostRewriteRuleEndpoint)
+	r.POST("/api/website/:name/rewrite/preset", PostRewritePresetEndpoint)
+	r.POST("/api/website/:name/rewrite/test", PostRewriteTestEndpoint)
+	r.PUT("/api/website/:name/rewrite/order", PutRewriteOrderEndpoint)
+	r.DELETE("/api/website/:name/rewrite/:rule", DeleteRewriteRuleEndpoint)
+	// IP security
+	r.GET("/api/ipsecurity", GetServerIPSecurityEndpoint)
+	r.PUT("/api/ipsecurity", PutServerIPSecurityEndpoint)
+	r.GET("/api/ipsecurity/effective", GetServerIPSecurityEffectiveEndpoint)
+	r.GET("/api/website/:name/ipsecurity", GetIPSecurityEndpoint)
+	r.PUT("/api/website/:name/ipsecurity", PutIPSecurityEndpoint)
+	r.GET("/api/website/:name/ipsecurity/effective", GetIPSecurityEffectiveEndpoint)
+	// Authentication
+	r.GET("/api/website/:name/auth", GetAuthenticationEndpoint)
+	r.PUT("/api/website/:name/auth", PutAuthenticationEndpoint)
+	// Response headers
+	r.GET("/api/website/:name/headers", GetSiteHeadersEndpoint)
+	r.PUT("/api/website/:name/headers", PutSiteHeadersEndpoint)
+	r.DELETE("/api/website/:name/headers/profile", DeleteSiteHeaderProfileEndpoint)
+	r.GET("/api/headers/profiles", GetHeaderProfilesEndpoint)
+	r.PUT("/api/headers/profiles/:profile", PutHeaderProfileEndpoint)
+	r.DELETE("/api/headers/profiles/:profile", DeleteHeaderProfileEndpoint)
+	r.POST("/api/headers/profiles/:profile/apply", PostApplyHeaderProfileEndpoint)
+	r.GET("/api/headers/drift", GetHeaderDriftEndpoint)
+	// Custom error pages
+	r.GET("/api/website/:name/errors", GetHTTPErrorsEndpoint)
+	r.PUT("/api/website/:name/errors", PutHTTPErrorsEndpoint)
+	// Limits
+	r.GET("/api/website/:name/limits", GetSiteLimitsEndpoint)
+	r.PUT("/api/website/:name/limits", PutSiteLimitsEndpoint)
+	// Manifests
+	r.POST("/api/plan", PostPlanEndpoint)
+	r.POST("/api/apply", PostApplyEndpoint)
+	// Export and import
+	r.GET("/api/export", GetExportEndpoint)
+	r.POST("/api/import", PostImportEndpoint)
+	// Configuration snapshots
+	r.GET("/api/snapshots", GetSnapshotsEndpoint)
+	r.POST("/api/snapshots", PostSnapshotEndpoint)
+	r.GET("/api/snapshots/settings", GetSnapshotSettingsEndpoint)
+	r.PUT("/api/snapshots/settings", PutSnapshotSettingsEndpoint)
+	r.GET("/api/snapshots/:id", GetSnapshotEndpoint)
+	r.DELETE("/api/snapshots/:id", DeleteSnapshotEndpoint)
+	r.GET("/api/snapshots/:id/diff", GetSnapshotDiffEndpoint)
+	r.POST("/api/snapshots/:id/restore", PostSnapshotRestoreEndpoint)
+	// Drift detection
+	r.GET("/api/drift", GetDriftEndpoint)
+	r.GET("/api/drift/settings", GetDriftSettingsEndpoint)
+	r.PUT("/api/drift/settings", PutDriftSettingsEndpoint)
+	r.GET("/api/website/:name/baseline", GetBaselineEndpoint)
+	r.POST("/api/website/:name/baseline", PostBaselineEndpoint)
+	r.DELETE("/api/website/:name/baseline", DeleteBaselineEndpoint)
+	r.POST("/api/website/:name/baseline/revert", PostRevertBaselineEndpoint)
+	// Health checks
+	r.GET("/api/health/settings", GetHealthSettingsEndpoint)
+	r.PUT("/api/health/settings", PutHealthSettingsEndpoint)
+	r.GET("/api/website/:name/health", GetHealthEndpoint)
+	r.GET("/api/website/:name/health/history", GetHealthHistoryEndpoint)
+	r.GET("/api/website/:name/uptime", GetUptimeEndpoint)
+	r.GET("/api/website/:name/health/config", GetHealthConfigEndpoint)
+	r.PUT("/api/website/:name/health/config", PutHealthConfigEndpoint)
+	// Maintenance
+	r.GET("/api/website/:name/maintenance", GetMaintenanceEndpoint)
+	r.POST("/api/website/:name/maintenance", PostMaintenanceEndpoint)
+	r.DELETE("/api/website/:name/maintenance", DeleteMaintenanceEndpoint)
+	// Deployments
+	r.GET("/api/deploy/settings", GetDeploySettingsEndpoint)
+	r.PUT("/api/deploy/settings", PutDeploySettingsEndpoint)
+	r.POST("/api/website/:name/deploy", PostDeployEndpoint)
+	r.POST("/api/website/:name/deploy/git", PostGitDeployEndpoint)
+	r.GET("/api/website/:name/git", GetGitSourceEndpoint)
+	r.PUT("/api/website/:name/git", PutGitSourceEndpoint)
+	r.DELETE("/api/website/:name/git", DeleteGitSourceEndpoint)
+	r.GET("/api/website/:name/hooks", GetDeployHooksEndpoint)
+	r.PUT("/api/website/:name/hooks", PutDeployHooksEndpoint)
+	r.GET("/api/website/:name/webhook", GetWebhookConfigEndpoint)
+	r.PUT("/api/website/:name/webhook", PutWebhookConfigEndpoint)
+	r.DELETE("/api/website/:name/webhook", DeleteWebhookConfigEndpoint)
+	r.GET("/api/website/:name/webhook/deliveries", GetWebhookDeliveriesEndpoint)
+	r.POST("/api/hooks/:name", PostWebhookEndpoint)
+	r.GET("/api/website/:name/deployments", GetDeploymentsEndpoint)
+	r.GET("/api/website/:name/releases", GetReleasesEndpoint)
+	r.POST("/api/website/:name/releases/prune", PostPruneReleasesEndpoint)
+	r.POST("/api/website/:name/releases/:id/rollback", PostRollbackEndpoint)
+	r.DELETE("/api/website/:name/releases/:id", DeleteReleaseEndpoint)
+	// Blue/green slots
+	r.GET("/api/website/:name/slots", GetSlotsEndpoint)
+	r.POST("/api/website/:name/slots", PostSlotsEndpoint)
+	r.DELETE("/api/website/:name/slots", DeleteSlotsEndpoint)
+	r.POST("/api/website/:name/slots/swap", PostSlotSwapEndpoint)
+	r.POST("/api/website/:name/slots/deploy", PostSlotDeployEndpoint)
+	// Recovery
+	r.GET("/api/recovery/events", GetRecoveryEventsEndpoint)
+	r.GET("/api/website/:name/recovery", GetRecoveryEndpoint)
+	r.PUT("/api/website/:name/recovery", PutRecoveryEndpoint)
+	r.GET("/api/website/:name/recovery/events", GetSiteRecoveryEventsEndpoint)
+	// Files
+	r.GET("/api/website/:name/files", GetFilesEndpoint)
+	r.DELETE("/api/website/:name/files", DeleteFileEndpoint)
+	r.GET("/api/website/:name/files/download", GetFileDownloadEndpoint)
+	r.POST("/api/website/:name/files/upload", PostFileUploadEndpoint)
+	r.POST("/api/website/:name/files/mkdir", PostMakeDirectoryEndpoint)
+	r.POST("/api/website/:name/files/move", PostMoveFileEndpoint)
+	r.POST("/api/website/:name/files/rename", PostRenameFileEndpoint)
+	r.GET("/api/website/:name/files/content", GetFileContentEndpoint)
+	r.PUT("/api/website/:name/files/content", PutFileContentEndpoint)
+	r.GET("/api/website/:name/files/backups", GetFileBackupsEndpoint)
+	r.POST("/api/website/:name/files/backups/:id/restore", PostRestoreFileBackupEndpoint)
 	// Logs
 	r.GET("/api/log/:site", GetLogsEndpoint)
 	// Others
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modAdvapi32              = syscall.NewLazyDLL("advapi32.dll")
+	procCheckTokenMembership = modAdvapi32.NewProc("CheckTokenMembership")
+)
+
+func IsAdmin() (bool, error) {
+	var sid *syscall.SID
+	// BUILTIN\Administrators group
+	sid, err := syscall.StringToSid("S-1-5-32-544")
+	if err != nil {
+		return false, err
+	}
+
+	var isAdmin uint32
+	ret, _, err := procCheckTokenMembership.Call(
+		0,
+		uintptr(unsafe.Pointer(sid)),
+		uintptr(unsafe.Pointer(&isAdmin)),
+	)
+	if ret == 0 {
+		return false, err
+	}
+	return isAdmin != 0, nil
+}
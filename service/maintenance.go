@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maintenanceBucket      = "maintenance"
+	maintenanceAppOffline  = "app_offline"
+	maintenanceRewrite     = "rewrite"
+	maintenanceRuleName    = "Maintenance mode"
+	maintenancePageFile    = "maintenance.htm"
+	appOfflineFile         = "app_offline.htm"
+	defaultRetryAfter      = 600
+	defaultMaintenanceText = "We are carrying out scheduled maintenance and will be back shortly."
+)
+
+var (
+	errInMaintenance     = errors.New("website is already in maintenance")
+	errNotInMaintenance  = errors.New("website is not in maintenance")
+	errMaintenanceEdited = errors.New("web.config was edited during maintenance")
+
+	maintenanceModes = []string{maintenanceAppOffline, maintenanceRewrite}
+
+	defaultMaintenancePage = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Site}} is down for maintenance</title>
+<style>body { font-family: system-ui, sans-serif; max-width: 36em; margin: 4em auto; padding: 0 1em; color: #333; }</style>
+</head>
+<body>
+<h1>Down for maintenance</h1>
+<p>{{.Message}}</p>
+{{if .EndsAt}}<p>We expect to be back by {{.EndsAt}}.</p>{{end}}
+</body>
+</html>
+`))
+)
+
+// maintenanceRecord is what entering maintenance changed: the status shown
+// to clients and every file as it was before, so leaving can put each one
+// back byte for byte.
+type maintenanceRecord struct {
+	MaintenanceStatus
+	Saved []savedFile `json:"saved"`
+	// AppliedHash is the web.config hash right after entering, to tell
+	// whether anyone edited it since
+	AppliedHash string `json:"appliedHash,omitempty"`
+}
+
+type savedFile struct {
+	Path    string `json:"path"`
+	Existed bool   `json:"existed"`
+	Content []byte `json:"content,omitempty"`
+}
+
+func saveFile(path string) (savedFile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return savedFile{Path: path}, nil
+	}
+	if err != nil {
+		return savedFile{}, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return savedFile{Path: path, Existed: true, Content: data}, nil
+}
+
+func (f savedFile) restore() error {
+	if !f.Existed {
+		if err := os.Remove(f.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %v", f.Path, err)
+		}
+		return nil
+	}
+	if err := writeFileAtomic(f.Path, f.Content); err != nil {
+		return fmt.Errorf("failed to restore %s: %v", f.Path, err)
+	}
+	return nil
+}
+
+func restoreFiles(saved []savedFile) error {
+	errs := []error{}
+	for i := len(saved) - 1; i >= 0; i-- {
+		if err := saved[i].restore(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func fileHash(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// isAspNetCoreConfig reports whether a web.config hands requests to the
+// ASP.NET Core Module, which serves app_offline.htm by itself.
+func isAspNetCoreConfig(data []byte) bool {
+	config := strings.ToLower(string(data))
+	return strings.Contains(config, "<aspnetcore") || strings.Contains(config, "aspnetcoremodule")
+}
+
+func ValidateMaintenanceRequest(request MaintenanceRequest, now time.Time) error {
+	if request.Mode != "" && !slices.Contains(maintenanceModes, request.Mode) {
+		return fmt.Errorf("mode must be one of %s", strings.Join(maintenanceModes, ", "))
+	}
+	if request.EndsAt != nil && !request.EndsAt.After(now) {
+		return fmt.Errorf("endsAt must be in the future")
+	}
+	if request.RetryAfterSeconds < 0 {
+		return fmt.Errorf("retryAfterSeconds cannot be negative")
+	}
+	if request.Page != "" {
+		if _, err := template.New("page").Parse(request.Page); err != nil {
+			return fmt.Errorf("invalid page template: %v", err)
+		}
+	}
+	return nil
+}
+
+// renderMaintenancePage fills in the request's page, or the default one,
+// with the site name, message and expected end.
+func renderMaintenancePage(website Website, request MaintenanceRequest) ([]byte, error) {
+	page := defaultMaintenancePage
+	if request.Page != "" {
+		var err error
+		if page, err = template.New("page").Parse(request.Page); err != nil {
+			return nil, fmt.Errorf("invalid page template: %v", err)
+		}
+	}
+	data := map[string]string{"Site": website.Name, "Message": request.Message}
+	if data["Message"] == "" {
+		data["Message"] = defaultMaintenanceText
+	}
+	if request.EndsAt != nil {
+		data["EndsAt"] = request.EndsAt.UTC().Format(time.RFC1123)
+	}
+	out := &bytes.Buffer{}
+	if err := page.Execute(out, data); err != nil {
+		return nil, fmt.Errorf("failed to render maintenance page: %v", err)
+	}
+	return out.Bytes(), nil
+}
+
+// retryAfter is how long clients are told to wait: the time left until
+// the scheduled end, if there is one.
+func retryAfter(request MaintenanceRequest, now time.Time) int {
+	if request.RetryAfterSeconds > 0 {
+		return request.RetryAfterSeconds
+	}
+	if request.EndsAt != nil {
+		return max(int(request.EndsAt.Sub(now).Seconds()), 60)
+	}
+	return defaultRetryAfter
+}
+
+func GetMaintenanceAction(website Website) (MaintenanceStatus, error) {
+	record := maintenanceRecord{}
+	found, err := storeGet(maintenanceBucket, strconv.Itoa(website.ID), &record)
+	if err != nil || !found {
+		return MaintenanceStatus{SiteID: website.ID, Site: website.Name}, err
+	}
+	record.Site = website.Name
+	return record.MaintenanceStatus, nil
+}
+
+// EnterMaintenanceAction takes a site offline. ASP.NET Core sites get an
+// app_offline.htm; other sites a rewrite rule answering every request with
+// 503, the page and Retry-After. Either way everything it touches is saved
+// first.
+func EnterMaintenanceAction(website Website, request MaintenanceRequest) (MaintenanceStatus, error) {
+	current, err := GetMaintenanceAction(website)
+	if err != nil {
+		return current, err
+	}
+	if current.Active {
+		return current, errInMaintenance
+	}
+	now := time.Now().UTC()
+	if err := ValidateMaintenanceRequest(request, now); err != nil {
+		return current, err
+	}
+	root := expandPhysicalPath(website.PhysicalPath)
+	webConfig, err := saveFile(filepath.Join(root, "web.config"))
+	if err != nil {
+		return current, err
+	}
+	if request.Mode == "" {
+		request.Mode = maintenanceRewrite
+		if isAspNetCoreConfig(webConfig.Content) {
+			request.Mode = maintenanceAppOffline
+		}
+	}
+	page, err := renderMaintenancePage(website, request)
+	if err != nil {
+		return current, err
+	}
+	record := maintenanceRecord{MaintenanceStatus: MaintenanceStatus{
+		SiteID:    website.ID,
+		Site:      website.Name,
+		Active:    true,
+		Mode:      request.Mode,
+		Message:   request.Message,
+		StartedAt: now,
+		EndsAt:    request.EndsAt,
+	}}
+
+	pageFile := filepath.Join(root, appOfflineFile)
+	if request.Mode == maintenanceRewrite {
+		pageFile = filepath.Join(root, maintenancePageFile)
+		record.RetryAfterSeconds = retryAfter(request, now)
+		record.Saved = append(record.Saved, webConfig)
+	}
+	saved, err := saveFile(pageFile)
+	if err != nil {
+		return current, err
+	}
+	record.Saved = append(record.Saved, saved)
+	if err := writeFileAtomic(pageFile, page); err != nil {
+		return current, fmt.Errorf("failed to write %s: %v", filepath.Base(pageFile), err)
+	}
+	if request.Mode == maintenanceRewrite {
+		if err := applyMaintenanceRule(website, record.RetryAfterSeconds); err != nil {
+			return current, errors.Join(err, restoreFiles(record.Saved))
+		}
+		record.AppliedHash = fileHash(webConfig.Path)
+	}
+	if err := storePut(maintenanceBucket, strconv.Itoa(website.ID), record); err != nil {
+		return current, errors.Join(err, restoreFiles(record.Saved))
+	}
+	return record.MaintenanceStatus, nil
+}
+
+// applyMaintenanceRule puts a 503 rule in front of the site's rewrite
+// rules, adds Retry-After and serves the maintenance page for 503s.
+func applyMaintenanceRule(website Website, seconds int) error {
+	ps := fmt.Sprintf(`Import-Module WebAdministration;
+		$pspath = %s; $rules = '%s'; $name = %s;
+		Add-WebConfigurationProperty -PSPath $pspath -Filter $rules -Name '.' -AtIndex 0 -Value @{ name = $name; stopProcessing = $true };
+		$rule = "$rules/rule[@name='$name']";
+		Set-WebConfigurationProperty -PSPath $pspath -Filter "$rule/match" -Name url -Value '.*';
+		Set-WebConfigurationProperty -PSPath $pspath -Filter "$rule/action" -Name '.' -Value @{ type = 'CustomResponse'; statusCode = 503; subStatusCode = 0; statusReason = 'Service Unavailable'; statusDescription = 'Down for maintenance' };
+		$headers = '%s';
+		Remove-WebConfigurationProperty -PSPath $pspath -Filter $headers -Name '.' -AtElement @{ name = 'Retry-After' } -ErrorAction SilentlyContinue;
+		Add-WebConfigurationProperty -PSPath $pspath -Filter $headers -Name '.' -Value @{ name = 'Retry-After'; value = '%d' };
+		$errors = '%s';
+		Set-WebConfigurationProperty -PSPath $pspath -Filter $errors -Name errorMode -Value 'Custom';
+		Set-WebConfigurationProperty -PSPath $pspath -Filter $errors -Name existingResponse -Value 'Replace';
+		Remove-WebConfigurationProperty -PSPath $pspath -Filter $errors -Name '.' -AtElement @{ statusCode = 503; subStatusCode = -1 } -ErrorAction SilentlyContinue;
+		Add-WebConfigurationProperty -PSPath $pspath -Filter $errors -Name '.' -Value @{ statusCode = 503; subStatusCode = -1; path = '%s'; responseMode = 'File' }`,
+		sitePSPath(website.Name), rewriteRulesFilter, psQuote(maintenanceRuleName),
+		customHeadersFilter, seconds, httpErrorsFilter, maintenancePageFile)
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to add maintenance rule for %s (is URL Rewrite installed?): %v", website.Name, err)
+	}
+	return nil
+}
+
+// ExitMaintenanceAction puts back every file entering maintenance changed.
+// If web.config was edited in between, it is snapshotted and only the
+// maintenance rule, header and error page are taken out of it, so the edits
+// stay. Should that fail, the site stays in maintenance and the error names
+// the snapshot.
+func ExitMaintenanceAction(website Website) error {
+	record := maintenanceRecord{}
+	found, err := storeGet(maintenanceBucket, strconv.Itoa(website.ID), &record)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errNotInMaintenance
+	}
+	saved := record.Saved
+	// In rewrite mode web.config is saved first
+	if record.Mode == maintenanceRewrite && fileHash(record.Saved[0].Path) != record.AppliedHash {
+		reason := fmt.Sprintf("web.config of %s changed during maintenance", website.Name)
+		snapshot, err := TakeSnapshotAction(triggerManual, reason)
+		if err != nil {
+			return err
+		}
+		if err := removeMaintenanceRule(website, record.Saved[0]); err != nil {
+			return fmt.Errorf("%w; remove the %q rule by hand or restore snapshot %s: %v", errMaintenanceEdited, maintenanceRuleName, snapshot.ID, err)
+		}
+		saved = saved[1:]
+	}
+	if err := restoreFiles(saved); err != nil {
+		return err
+	}
+	return storeDelete(maintenanceBucket, strconv.Itoa(website.ID))
+}
+
+// removeMaintenanceRule undoes applyMaintenanceRule in place. The httpErrors
+// attributes it set go back to their values in the saved web.config, or are
+// removed when it had none.
+func removeMaintenanceRule(website Website, webConfig savedFile) error {
+	original := struct {
+		HTTPErrors struct {
+			ErrorMode        *string `xml:"errorMode,attr"`
+			ExistingResponse *string `xml:"existingResponse,attr"`
+		} `xml:"system.webServer>httpErrors"`
+	}{}
+	if webConfig.Existed {
+		if err := xml.Unmarshal(webConfig.Content, &original); err != nil {
+			return fmt.Errorf("failed to read the saved web.config: %v", err)
+		}
+	}
+	attribute := func(name string, value *string) string {
+		if value == nil {
+			return fmt.Sprintf("Remove-WebConfigurationProperty -PSPath $pspath -Filter $errors -Name %s -ErrorAction SilentlyContinue;", name)
+		}
+		return fmt.Sprintf("Set-WebConfigurationProperty -PSPath $pspath -Filter $errors -Name %s -Value %s;", name, psQuote(*value))
+	}
+	ps := fmt.Sprintf(`Import-Module WebAdministration;
+		$pspath = %s; $errors = '%s';
+		Remove-WebConfigurationProperty -PSPath $pspath -Filter '%s' -Name '.' -AtElement @{ name = %s } -ErrorAction SilentlyContinue;
+		Remove-WebConfigurationProperty -PSPath $pspath -Filter '%s' -Name '.' -AtElement @{ name = 'Retry-After' } -ErrorAction SilentlyContinue;
+		Remove-WebConfigurationProperty -PSPath $pspath -Filter $errors -Name '.' -AtElement @{ statusCode = 503; subStatusCode = -1 } -ErrorAction SilentlyContinue;
+		%s
+		%s`,
+		sitePSPath(website.Name), httpErrorsFilter, rewriteRulesFilter, psQuote(maintenanceRuleName), customHeadersFilter,
+		attribute("errorMode", original.HTTPErrors.ErrorMode), attribute("existingResponse", original.HTTPErrors.ExistingResponse))
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to remove maintenance rule for %s: %v", website.Name, err)
+	}
+	return nil
+}
+
+// maintenanceSites returns the status of every site in maintenance by ID.
+func maintenanceSites() (map[int]MaintenanceStatus, error) {
+	records, err := storeList[maintenanceRecord](maintenanceBucket)
+	if err != nil {
+		return nil, err
+	}
+	sites := map[int]MaintenanceStatus{}
+	for _, record := range records {
+		sites[record.SiteID] = record.MaintenanceStatus
+	}
+	return sites, nil
+}
+
+// attachMaintenance fills in Maintenance on every website in maintenance.
+func attachMaintenance(websites []Website) ([]Website, error) {
+	sites, err := maintenanceSites()
+	if err != nil {
+		return websites, err
+	}
+	for i := range websites {
+		if status, ok := sites[websites[i].ID]; ok {
+			status.Site = websites[i].Name
+			websites[i].Maintenance = &status
+		}
+	}
+	return websites, nil
+}
+
+// EndScheduledMaintenanceAction brings back every site whose scheduled
+// end has passed.
+func EndScheduledMaintenanceAction() error {
+	sites, err := maintenanceSites()
+	if err != nil {
+		return err
+	}
+	errs := []error{}
+	for _, status := range sites {
+		if status.EndsAt == nil || status.EndsAt.After(time.Now()) {
+			continue
+		}
+		if err := ExitMaintenanceAction(Website{ID: status.SiteID, Name: status.Site}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to end maintenance for %s: %v", status.Site, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StartMaintenanceScheduler ends scheduled maintenance on time, to the
+// minute.
+func StartMaintenanceScheduler() {
+	runEvery("maintenance end", func() (int, error) { return 1, nil }, EndScheduledMaintenanceAction)
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+const (
+	planCreate = "create"
+	planUpdate = "update"
+	planDelete = "delete"
+
+	kindAppPool          = "appPool"
+	kindSite             = "site"
+	kindVirtualDirectory = "virtualDirectory"
+)
+
+var (
+	pipelineModes = []string{"Integrated", "Classic"}
+	siteStates    = []string{"Started", "Stopped"}
+)
+
+// ParseManifest reads a manifest written as JSON or YAML. Unknown fields
+// are rejected so that a typo does not silently drop a setting.
+func ParseManifest(data []byte) (Manifest, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '{' {
+		converted, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return Manifest{}, fmt.Errorf("invalid yaml: %v", err)
+		}
+		data = converted
+	}
+	manifest := Manifest{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&manifest); err != nil {
+		return Manifest{}, fmt.Errorf("invalid manifest: %v", err)
+	}
+	return manifest, ValidateManifest(manifest)
+}
+
+func ValidateManifest(manifest Manifest) error {
+	pools := map[string]bool{}
+	for _, pool := range manifest.AppPools {
+		key := strings.ToLower(pool.Name)
+		if pool.Name == "" || strings.ContainsAny(pool.Name, `'"/\`) {
+			return fmt.Errorf("invalid app pool name %q", pool.Name)
+		}
+		if pools[key] {
+			return fmt.Errorf("duplicate app pool %s", pool.Name)
+		}
+		pools[key] = true
+		if pool.PipelineMode != "" && !slices.Contains(pipelineModes, pool.PipelineMode) {
+			return fmt.Errorf("app pool %s: pipelineMode must be Integrated or Classic", pool.Name)
+		}
+		if pool.RuntimeVersion != nil && *pool.RuntimeVersion != "" && *pool.RuntimeVersion != "v2.0" && *pool.RuntimeVersion != "v4.0" {
+			return fmt.Errorf("app pool %s: runtimeVersion must be empty, v2.0 or v4.0", pool.Name)
+		}
+	}
+	sites := map[string]bool{}
+	bindings := map[string]string{}
+	for _, site := range manifest.Sites {
+		key := strings.ToLower(site.Name)
+		if site.Name == "" || strings.TrimSpace(site.Name) != site.Name || strings.ContainsAny(site.Name, `'"/\`) {
+			return fmt.Errorf("invalid site name %q", site.Name)
+		}
+		if sites[key] {
+			return fmt.Errorf("duplicate site %s", site.Name)
+		}
+		sites[key] = true
+		if len(site.Bindings) == 0 {
+			return fmt.Errorf("site %s needs at least one binding", site.Name)
+		}
+		for _, binding := range site.Bindings {
+			if binding.Protocol != "http" && binding.Protocol != "https" {
+				return fmt.Errorf("site %s: binding protocol must be http or https", site.Name)
+			}
+			if binding.Port < 1 || binding.Port > 65535 {
+				return fmt.Errorf("site %s: invalid port %d", site.Name, binding.Port)
+			}
+			info := bindingInformation(binding)
+			if other, ok := bindings[info]; ok {
+				return fmt.Errorf("binding %s is used by both %s and %s", info, other, site.Name)
+			}
+			bindings[info] = site.Name
+		}
+		if site.State != "" && !slices.Contains(siteStates, site.State) {
+			return fmt.Errorf("site %s: state must be Started or Stopped", site.Name)
+		}
+		if site.Limits != nil {
+			if err := ValidateSiteLimits(*site.Limits); err != nil {
+				return fmt.Errorf("site %s: %v", site.Name, err)
+			}
+		}
+		paths := map[string]bool{}
+		for _, vdir := range site.VirtualDirectories {
+			path := normalizeVirtualPath(vdir.Path)
+			if path == "/" || strings.Contains(path, "..") || strings.ContainsAny(path, `'"`) {
+				return fmt.Errorf("site %s: invalid virtual directory path %q", site.Name, vdir.Path)
+			}
+			if paths[path] {
+				return fmt.Errorf("site %s: duplicate virtual directory %s", site.Name, path)
+			}
+			paths[path] = true
+			if vdir.PhysicalPath == "" {
+				return fmt.Errorf("site %s: virtual directory %s needs a physicalPath", site.Name, path)
+			}
+		}
+	}
+	return nil
+}
+
+func normalizeVirtualPath(path string) string {
+	return "/" + strings.Trim(strings.ReplaceAll(path, `\`, "/"), "/")
+}
+
+func bindingInformation(binding ManifestBinding) string {
+	ip := binding.IP
+	switch {
+	case ip == "":
+		ip = "*"
+	case strings.Contains(ip, ":") && !strings.HasPrefix(ip, "["):
+		// IIS keeps IPv6 addresses in brackets
+		ip = "[" + ip + "]"
+	}
+	return fmt.Sprintf("%s/%s:%d:%s", binding.Protocol, ip, binding.Port, strings.ToLower(binding.Host))
+}
+
+// parseBindingInformation reads IIS's "ip:port:host" form. Host names
+// never hold a colon, so it splits from the right and leaves IPv6 addresses
+// such as "[::1]" whole.
+func parseBindingInformation(protocol string, info string) (ManifestBinding, bool) {
+	rest, host, ok := cutLast(info, ":")
+	if !ok {
+		return ManifestBinding{}, false
+	}
+	ip, portText, ok := cutLast(rest, ":")
+	if !ok || ip == "" {
+		return ManifestBinding{}, false
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return ManifestBinding{}, false
+	}
+	binding := ManifestBinding{Protocol: protocol, Port: port, Host: host}
+	if ip != "*" {
+		binding.IP = ip
+	}
+	return binding, true
+}
+
+func cutLast(s string, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+func samePath(a string, b string) bool {
+	clean := func(p string) string {
+		return strings.TrimRight(strings.ReplaceAll(expandPhysicalPath(p), "/", `\`), `\`)
+	}
+	return strings.EqualFold(clean(a), clean(b))
+}
+
+func bindingKeys(bindings []ManifestBinding) []string {
+	keys := []string{}
+	for _, binding := range bindings {
+		keys = append(keys, bindingInformation(binding))
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// planPhase orders actions so that everything an action needs exists
+// before it runs, and nothing is removed while still in use.
+func planPhase(action PlanAction) int {
+	switch {
+	case action.Kind == kindAppPool && action.Op != planDelete:
+		return 0
+	case action.Kind == kindSite && action.Op == planCreate:
+		return 1
+	case action.Kind == kindSite && action.Op == planUpdate:
+		return 2
+	case action.Kind == kindVirtualDirectory && action.Op != planDelete:
+		return 3
+	case action.Kind == kindVirtualDirectory:
+		return 4
+	case action.Kind == kindSite:
+		return 5
+	}
+	return 6
+}
+
+// PlanManifest diffs the desired manifest against live state and returns
+// the actions that would make live match, in the order they must run.
+// Deletions are only planned when prune is set.
+func PlanManifest(desired Manifest, live Manifest, prune bool) Plan {
+	actions := []PlanAction{}
+
+	livePools := map[string]ManifestAppPool{}
+	for _, pool := range live.AppPools {
+		livePools[strings.ToLower(pool.Name)] = pool
+	}
+	for _, pool := range desired.AppPools {
+		current, ok := livePools[strings.ToLower(pool.Name)]
+		if !ok {
+			actions = append(actions, PlanAction{Op: planCreate, Kind: kindAppPool, Name: pool.Name, AppPool: &pool})
+			continue
+		}
+		changes := []FieldChange{}
+		if pool.RuntimeVersion != nil && (current.RuntimeVersion == nil || *pool.RuntimeVersion != *current.RuntimeVersion) {
+			changes = append(changes, FieldChange{Field: "runtimeVersion", Before: current.RuntimeVersion, After: *pool.RuntimeVersion})
+		}
+		if pool.PipelineMode != "" && pool.PipelineMode != current.PipelineMode {
+			changes = append(changes, FieldChange{Field: "pipelineMode", Before: current.PipelineMode, After: pool.PipelineMode})
+		}
+		if len(changes) > 0 {
+			actions = append(actions, PlanAction{Op: planUpdate, Kind: kindAppPool, Name: pool.Name, Changes: changes, AppPool: &pool})
+		}
+	}
+
+	liveSites := map[string]ManifestSite{}
+	for _, site := range live.Sites {
+		liveSites[strings.ToLower(site.Name)] = site
+	}
+	for _, site := range desired.Sites {
+		current, ok := liveSites[strings.ToLower(site.Name)]
+		if !ok {
+			actions = append(actions, PlanAction{Op: planCreate, Kind: kindSite, Name: site.Name, Site: site.Name, Desired: &site})
+			for _, vdir := range site.VirtualDirectories {
+				actions = append(actions, PlanAction{Op: planCreate, Kind: kindVirtualDirectory, Name: normalizeVirtualPath(vdir.Path), Site: site.Name, VirtualDirectory: &vdir})
+			}
+			continue
+		}
+		if changes := siteChanges(site, current); len(changes) > 0 {
+			actions = append(actions, PlanAction{Op: planUpdate, Kind: kindSite, Name: site.Name, Site: site.Name, Changes: changes, Desired: &site})
+		}
+		actions = append(actions, virtualDirectoryActions(site, current, prune)...)
+	}
+
+	if prune {
+		wanted := map[string]bool{}
+		used := map[string]bool{}
+		for _, site := range desired.Sites {
+			wanted[strings.ToLower(site.Name)] = true
+			pool := site.AppPool
+			if current, ok := liveSites[strings.ToLower(site.Name)]; pool == "" && ok {
+				pool = current.AppPool
+			} else if pool == "" {
+				// New sites land in DefaultAppPool; see newWebsite
+				pool = "DefaultAppPool"
+			}
+			used[strings.ToLower(pool)] = true
+		}
+		for _, site := range live.Sites {
+			if !wanted[strings.ToLower(site.Name)] {
+				actions = append(actions, PlanAction{Op: planDelete, Kind: kindSite, Name: site.Name, Site: site.Name})
+			}
+		}
+		for _, pool := range desired.AppPools {
+			used[strings.ToLower(pool.Name)] = true
+		}
+		for _, pool := range live.AppPools {
+			if !used[strings.ToLower(pool.Name)] {
+				actions = append(actions, PlanAction{Op: planDelete, Kind: kindAppPool, Name: pool.Name})
+			}
+		}
+	}
+
+	sort.SliceStable(actions, func(i, j int) bool { return planPhase(actions[i]) < planPhase(actions[j]) })
+	plan := Plan{Prune: prune, Actions: actions}
+	for _, action := range actions {
+		switch action.Op {
+		case planCreate:
+			plan.Summary.Create++
+		case planUpdate:
+			plan.Summary.Update++
+		case planDelete:
+			plan.Summary.Delete++
+		}
+	}
+	return plan
+}
+
+func siteChanges(site ManifestSite, current ManifestSite) []FieldChange {
+	changes := []FieldChange{}
+	if site.PhysicalPath != "" && !samePath(site.PhysicalPath, current.PhysicalPath) {
+		changes = append(changes, FieldChange{Field: "physicalPath", Before: current.PhysicalPath, After: site.PhysicalPath})
+	}
+	if site.AppPool != "" && !strings.EqualFold(site.AppPool, current.AppPool) {
+		changes = append(changes, FieldChange{Field: "appPool", Before: current.AppPool, After: site.AppPool})
+	}
+	if want, have := bindingKeys(site.Bindings), bindingKeys(current.Bindings); !slices.Equal(want, have) {
+		changes = append(changes, FieldChange{Field: "bindings", Before: have, After: want})
+	}
+	if site.Limits != nil && (current.Limits == nil || *site.Limits != *current.Limits) {
+		changes = append(changes, FieldChange{Field: "limits", Before: current.Limits, After: site.Limits})
+	}
+	if site.State != "" && site.State != current.State {
+		changes = append(changes, FieldChange{Field: "state", Before: current.State, After: site.State})
+	}
+	return changes
+}
+
+func virtualDirectoryActions(site ManifestSite, current ManifestSite, prune bool) []PlanAction {
+	actions := []PlanAction{}
+	liveDirs := map[string]ManifestVirtualDirectory{}
+	for _, vdir := range current.VirtualDirectories {
+		liveDirs[strings.ToLower(normalizeVirtualPath(vdir.Path))] = vdir
+	}
+	wanted := map[string]bool{}
+	for _, vdir := range site.VirtualDirectories {
+		path := normalizeVirtualPath(vdir.Path)
+		wanted[strings.ToLower(path)] = true
+		existing, ok := liveDirs[strings.ToLower(path)]
+		switch {
+		case !ok:
+			actions = append(actions, PlanAction{Op: planCreate, Kind: kindVirtualDirectory, Name: path, Site: site.Name, VirtualDirectory: &vdir})
+		case !samePath(existing.PhysicalPath, vdir.PhysicalPath):
+			actions = append(actions, PlanAction{
+				Op: planUpdate, Kind: kindVirtualDirectory, Name: path, Site: site.Name, VirtualDirectory: &vdir,
+				Changes: []FieldChange{{Field: "physicalPath", Before: existing.PhysicalPath, After: vdir.PhysicalPath}},
+			})
+		}
+	}
+	if prune {
+		for _, vdir := range current.VirtualDirectories {
+			path := normalizeVirtualPath(vdir.Path)
+			if !wanted[strings.ToLower(path)] {
+				actions = append(actions, PlanAction{Op: planDelete, Kind: kindVirtualDirectory, Name: path, Site: site.Name})
+			}
+		}
+	}
+	return actions
+}
+
+// LiveManifestAction reads IIS into manifest form. Limits are only read for
+// sites whose desired manifest manages them, since that costs a call each.
+func LiveManifestAction(desired Manifest) (Manifest, error) {
+	managedLimits := map[string]bool{}
+	for _, site := range desired.Sites {
+		if site.Limits != nil {
+			managedLimits[strings.ToLower(site.Name)] = true
+		}
+	}
+	return readLiveManifest(func(site string) bool { return managedLimits[strings.ToLower(site)] })
+}
+
+func readLiveManifest(withLimits func(site string) bool) (Manifest, error) {
+	ps := `Import-Module WebAdministration;
+		$pools = Get-ChildItem IIS:\AppPools | ForEach-Object {
+			[PSCustomObject]@{ name = $_.Name; runtimeVersion = [string]$_.managedRuntimeVersion; pipelineMode = [string]$_.managedPipelineMode }
+		};
+		$sites = Get-ChildItem IIS:\Sites | ForEach-Object {
+			$site = $_
+			[PSCustomObject]@{
+				name = $site.Name
+				physicalPath = $site.physicalPath
+				appPool = $site.applicationPool
+				state = [string]$site.State
+				bindings = @($site.Bindings.Collection | ForEach-Object { [PSCustomObject]@{ protocol = $_.protocol; bindingInformation = $_.bindingInformation } })
+				virtualDirectories = @(Get-WebVirtualDirectory -Site $site.Name | ForEach-Object { [PSCustomObject]@{ path = $_.path; physicalPath = $_.physicalPath } })
+			}
+		};
+		[PSCustomObject]@{ appPools = @($pools); sites = @($sites) } | ConvertTo-Json -Depth 5`
+	out, err := runPowerShell(ps)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to read live configuration: %v", err)
+	}
+	raw := struct {
+		AppPools []ManifestAppPool `json:"appPools"`
+		Sites    []struct {
+			Name               string                     `json:"name"`
+			PhysicalPath       string                     `json:"physicalPath"`
+			AppPool            string                     `json:"appPool"`
+			State              string                     `json:"state"`
+			VirtualDirectories []ManifestVirtualDirectory `json:"virtualDirectories"`
+			Bindings           []struct {
+				Protocol           string `json:"protocol"`
+				BindingInformation string `json:"bindingInformation"`
+			} `json:"bindings"`
+		} `json:"sites"`
+	}{}
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return Manifest{}, fmt.Errorf("failed to parse live configuration: %v", err)
+	}
+	live := Manifest{AppPools: raw.AppPools, Sites: []ManifestSite{}}
+	for _, rawSite := range raw.Sites {
+		site := ManifestSite{
+			Name:               rawSite.Name,
+			PhysicalPath:       rawSite.PhysicalPath,
+			AppPool:            rawSite.AppPool,
+			State:              rawSite.State,
+			Bindings:           []ManifestBinding{},
+			VirtualDirectories: rawSite.VirtualDirectories,
+		}
+		for _, b := range rawSite.Bindings {
+			if b.Protocol != "http" && b.Protocol != "https" {
+				continue
+			}
+			if binding, ok := parseBindingInformation(b.Protocol, b.BindingInformation); ok {
+				site.Bindings = append(site.Bindings, binding)
+			}
+		}
+		if withLimits(site.Name) {
+			limits, err := GetSiteLimitsAction(site.Name)
+			if err != nil {
+				return Manifest{}, err
+			}
+			site.Limits = &limits
+		}
+		live.Sites = append(live.Sites, site)
+	}
+	return live, nil
+}
+
+// ApplyPlanAction carries out a plan in order. It stops at the first
+// failure, since later actions usually depend on earlier ones, and marks
+// what was not attempted as skipped.
+func ApplyPlanAction(plan Plan) Plan {
+	failed := false
+	for i := range plan.Actions {
+		action := &plan.Actions[i]
+		if failed {
+			action.Status = "skipped"
+			continue
+		}
+		if err := applyPlanItem(*action); err != nil {
+			action.Status = "failed"
+			action.Error = err.Error()
+			failed = true
+			continue
+		}
+		action.Status = "applied"
+	}
+	return plan
+}
+
+func applyPlanItem(action PlanAction) error {
+	switch action.Kind {
+	case kindAppPool:
+		if action.Op == planDelete {
+			return DeleteAppPoolAction(action.Name)
+		}
+		return SetAppPoolAction(*action.AppPool, action.Op == planCreate)
+	case kindSite:
+		switch action.Op {
+		case planCreate:
+			site := action.Desired
+			physicalPath := site.PhysicalPath
+			if physicalPath == "" {
+				physicalPath = `C:\inetpub\wwwroot\` + site.Name
+			}
+			// The exact name is kept: CreateWebsiteAction strips spaces
+			// typed in the dashboard, which would turn "Default Web Site"
+			// into a different site
+			first := site.Bindings[0]
+			if err := newWebsite(site.Name, first.Port, first.Host, physicalPath); err != nil {
+				return err
+			}
+			return applySiteFields(*site, []string{"bindings", "physicalPath", "appPool", "limits", "state"})
+		case planUpdate:
+			fields := []string{}
+			for _, change := range action.Changes {
+				fields = append(fields, change.Field)
+			}
+			return applySiteFields(*action.Desired, fields)
+		case planDelete:
+			// Pruning takes a site out of IIS; its content stays on disk
+			return RemoveWebsiteAction(action.Name)
+		}
+	case kindVirtualDirectory:
+		return SetVirtualDirectoryAction(action.Site, action.Name, action.VirtualDirectory, action.Op)
+	}
+	return fmt.Errorf("unsupported plan action %s %s", action.Op, action.Kind)
+}
+
+func applySiteFields(site ManifestSite, fields []string) error {
+	for _, field := range fields {
+		var err error
+		switch field {
+		case "bindings":
+			err = SetSiteBindingsAction(site.Name, site.Bindings)
+		case "physicalPath":
+			if site.PhysicalPath != "" {
+				err = SetSitePhysicalPathAction(site.Name, site.PhysicalPath)
+			}
+		case "appPool":
+			if site.AppPool != "" {
+				err = ChangeAppPoolAction(site.Name, site.AppPool)
+			}
+		case "limits":
+			if site.Limits != nil {
+				err = SetSiteLimitsAction(site.Name, *site.Limits)
+			}
+		case "state":
+			switch site.State {
+			case "Started":
+				err = ControlWebsiteAction(ActionStart, site.Name)
+			case "Stopped":
+				err = ControlWebsiteAction(ActionStop, site.Name)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func SetAppPoolAction(pool ManifestAppPool, create bool) error {
+	// Settings left out of the manifest are left as IIS has them; a new
+	// pool starts from the IIS defaults
+	settings := ""
+	if pool.RuntimeVersion != nil {
+		settings += fmt.Sprintf(`Set-ItemProperty -Path ('IIS:\AppPools\' + $name) -Name managedRuntimeVersion -Value %s;`, psQuote(*pool.RuntimeVersion))
+	}
+	if pool.PipelineMode != "" {
+		settings += fmt.Sprintf(`Set-ItemProperty -Path ('IIS:\AppPools\' + $name) -Name managedPipelineMode -Value %s;`, psQuote(pool.PipelineMode))
+	}
+	ps := fmt.Sprintf(`Import-Module WebAdministration;
+		$name = %s;
+		if ($%t) { New-WebAppPool -Name $name | Out-Null };
+		%s`,
+		psQuote(pool.Name), create, settings)
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to configure app pool %s: %v", pool.Name, err)
+	}
+	return nil
+}
+
+func DeleteAppPoolAction(name string) error {
+	ps := fmt.Sprintf(`Import-Module WebAdministration; Remove-WebAppPool -Name %s`, psQuote(name))
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to delete app pool %s: %v", name, err)
+	}
+	return nil
+}
+
+// SetSiteBindingsAction replaces a site's http and https bindings. Bindings
+// for other protocols, such as net.tcp, are left alone.
+func SetSiteBindingsAction(site string, bindings []ManifestBinding) error {
+	values := []map[string]string{}
+	for _, binding := range bindings {
+		info := strings.SplitN(bindingInformation(binding), "/", 2)[1]
+		if binding.Host != "" {
+			info = strings.TrimSuffix(info, strings.ToLower(binding.Host)) + binding.Host
+		}
+		values = append(values, map[string]string{"protocol": binding.Protocol, "bindingInformation": info})
+	}
+	payload, err := psJSON(values)
+	if err != nil {
+		return err
+	}
+	ps := fmt.Sprintf(`Import-Module WebAdministration;
+		$path = %s;
+		$others = @((Get-ItemProperty -Path $path -Name bindings).Collection | Where-Object { $_.protocol -notin 'http', 'https' } | ForEach-Object {
+			@{ protocol = $_.protocol; bindingInformation = $_.bindingInformation }
+		});
+		$wanted = @(foreach ($b in %s) { @{ protocol = $b.protocol; bindingInformation = $b.bindingInformation } });
+		Set-ItemProperty -Path $path -Name bindings -Value ($wanted + $others)`,
+		psQuote(`IIS:\Sites\`+site), payload)
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to set bindings for %s: %v", site, err)
+	}
+	return nil
+}
+
+func SetSitePhysicalPathAction(site string, physicalPath string) error {
+	ps := fmt.Sprintf(`Import-Module WebAdministration;
+		$dir = [Environment]::ExpandEnvironmentVariables(%s);
+		if (-Not (Test-Path $dir)) { New-Item -Path $dir -ItemType Directory | Out-Null };
+		Set-ItemProperty -Path %s -Name physicalPath -Value %s`,
+		psQuote(physicalPath), psQuote(`IIS:\Sites\`+site), psQuote(physicalPath))
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to set physical path for %s: %v", site, err)
+	}
+	return nil
+}
+
+// SetVirtualDirectoryAction creates, updates or deletes a virtual directory
+// under a site's root application.
+func SetVirtualDirectoryAction(site string, path string, vdir *ManifestVirtualDirectory, op string) error {
+	itemPath := `IIS:\Sites\` + site + strings.ReplaceAll(normalizeVirtualPath(path), "/", `\`)
+	var ps string
+	switch op {
+	case planCreate:
+		ps = fmt.Sprintf(`Import-Module WebAdministration;
+			$dir = [Environment]::ExpandEnvironmentVariables(%s);
+			if (-Not (Test-Path $dir)) { New-Item -Path $dir -ItemType Directory | Out-Null };
+			New-Item -Path %s -Type VirtualDirectory -PhysicalPath %s | Out-Null`,
+			psQuote(vdir.PhysicalPath), psQuote(itemPath), psQuote(vdir.PhysicalPath))
+	case planUpdate:
+		ps = fmt.Sprintf(`Import-Module WebAdministration; Set-ItemProperty -Path %s -Name physicalPath -Value %s`,
+			psQuote(itemPath), psQuote(vdir.PhysicalPath))
+	case planDelete:
+		ps = fmt.Sprintf(`Import-Module WebAdministration; Remove-Item -Path %s -Recurse -Confirm:$false`, psQuote(itemPath))
+	default:
+		return fmt.Errorf("unsupported virtual directory operation %s", op)
+	}
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to %s virtual directory %s on %s: %v", op, path, site, err)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlanManifest(t *testing.T) {
+	desired, err := ParseManifest([]byte(`
+appPools:
+  - name: Checkout
+    runtimeVersion: ""
+sites:
+  - name: Checkout
+    appPool: Checkout
+    physicalPath: C:\inetpub\wwwroot\Checkout
+    bindings:
+      - {protocol: http, port: 80, host: Shop.example.com}
+    virtualDirectories:
+      - {path: assets/, physicalPath: D:\assets}
+  - name: New
+    bindings:
+      - {protocol: https, port: 443, host: new.example.com}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := Manifest{
+		AppPools: []ManifestAppPool{{Name: "DefaultAppPool", RuntimeVersion: runtimeVersion("v4.0"), PipelineMode: "Integrated"}, {Name: "Old"}},
+		Sites: []ManifestSite{
+			{
+				Name:               "Checkout",
+				AppPool:            "DefaultAppPool",
+				PhysicalPath:       `C:/inetpub/wwwroot/checkout/`,
+				State:              "Started",
+				Bindings:           []ManifestBinding{{Protocol: "http", Port: 80, Host: "shop.example.com"}},
+				VirtualDirectories: []ManifestVirtualDirectory{{Path: "/assets", PhysicalPath: `D:\assets\`}, {Path: "/old", PhysicalPath: `D:\old`}},
+			},
+			{Name: "Legacy", AppPool: "Old", Bindings: []ManifestBinding{{Protocol: "http", Port: 8080}}},
+		},
+	}
+
+	// Paths and hosts differing only in case or slashes are not changes
+	plan := PlanManifest(desired, live, false)
+	if plan.Summary != (PlanSummary{Create: 2, Update: 1}) {
+		t.Fatalf("unexpected summary %+v", plan.Summary)
+	}
+	if plan.Actions[0].Kind != kindAppPool || plan.Actions[0].Op != planCreate {
+		t.Fatalf("app pools must be created first, got %+v", plan.Actions[0])
+	}
+	if plan.Actions[1].Name != "New" || plan.Actions[1].Op != planCreate {
+		t.Fatalf("expected site New to be created, got %+v", plan.Actions[1])
+	}
+	update := plan.Actions[2]
+	if update.Op != planUpdate || len(update.Changes) != 1 || update.Changes[0].Field != "appPool" {
+		t.Fatalf("expected only the app pool of Checkout to change, got %+v", update)
+	}
+
+	// Pruning deletes the unmanaged site, virtual directory and app pool,
+	// pools last since sites may still use them
+	plan = PlanManifest(desired, live, true)
+	if plan.Summary.Delete != 3 {
+		t.Fatalf("expected 3 deletes, got %+v", plan.Actions)
+	}
+	last := plan.Actions[len(plan.Actions)-1]
+	if last.Kind != kindAppPool || last.Op != planDelete {
+		t.Fatalf("app pools must be deleted last, got %+v", last)
+	}
+
+	// Applying the desired state to itself plans nothing
+	if plan := PlanManifest(desired, desired, true); len(plan.Actions) != 0 {
+		t.Fatalf("expected an empty plan, got %+v", plan.Actions)
+	}
+}
+
+func runtimeVersion(version string) *string {
+	return &version
+}
+
+func TestPlanAppPoolRuntime(t *testing.T) {
+	live := Manifest{AppPools: []ManifestAppPool{{Name: "Pool", RuntimeVersion: runtimeVersion("v4.0"), PipelineMode: "Integrated"}}}
+	tests := []struct {
+		name    string
+		version *string
+		changes int
+	}{
+		{"left out", nil, 0},
+		{"same", runtimeVersion("v4.0"), 0},
+		{"no managed code", runtimeVersion(""), 1},
+		{"older", runtimeVersion("v2.0"), 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			desired := Manifest{AppPools: []ManifestAppPool{{Name: "Pool", RuntimeVersion: test.version}}}
+			plan := PlanManifest(desired, live, false)
+			if plan.Summary.Update != test.changes {
+				t.Fatalf("expected %d updates, got %+v", test.changes, plan.Actions)
+			}
+		})
+	}
+}
+
+func TestParseBindingInformation(t *testing.T) {
+	tests := []struct {
+		info    string
+		binding ManifestBinding
+		ok      bool
+	}{
+		{"*:80:", ManifestBinding{Protocol: "http", Port: 80}, true},
+		{"*:80:shop.example.com", ManifestBinding{Protocol: "http", Port: 80, Host: "shop.example.com"}, true},
+		{"10.0.0.5:8080:", ManifestBinding{Protocol: "http", IP: "10.0.0.5", Port: 8080}, true},
+		{"[::]:443:shop.example.com", ManifestBinding{Protocol: "http", IP: "[::]", Port: 443, Host: "shop.example.com"}, true},
+		{"[2001:db8::1]:80:", ManifestBinding{Protocol: "http", IP: "[2001:db8::1]", Port: 80}, true},
+		{"*:http:", ManifestBinding{}, false},
+		{":80:", ManifestBinding{}, false},
+		{"80", ManifestBinding{}, false},
+	}
+	for _, test := range tests {
+		binding, ok := parseBindingInformation("http", test.info)
+		if ok != test.ok || binding != test.binding {
+			t.Errorf("%s: expected %+v %v, got %+v %v", test.info, test.binding, test.ok, binding, ok)
+		}
+	}
+
+	// A manifest may name an IPv6 address with or without brackets
+	live, _ := parseBindingInformation("https", "[::1]:443:shop.example.com")
+	for _, ip := range []string{"::1", "[::1]"} {
+		desired := ManifestBinding{Protocol: "https", IP: ip, Port: 443, Host: "Shop.example.com"}
+		if bindingInformation(desired) != bindingInformation(live) {
+			t.Errorf("%s: expected %s, got %s", ip, bindingInformation(live), bindingInformation(desired))
+		}
+	}
+}
+
+func TestValidateManifest(t *testing.T) {
+	site := func(name string, port int) ManifestSite {
+		return ManifestSite{Name: name, Bindings: []ManifestBinding{{Protocol: "http", Port: port}}}
+	}
+	tests := []struct {
+		name     string
+		manifest Manifest
+		err      string
+	}{
+		{"valid", Manifest{AppPools: []ManifestAppPool{{Name: "Pool", PipelineMode: "Classic"}}, Sites: []ManifestSite{site("a", 80), site("b", 81)}}, ""},
+		{"spaces in site name", Manifest{Sites: []ManifestSite{site("Default Web Site", 80)}}, ""},
+		{"padded site name", Manifest{Sites: []ManifestSite{site(" a", 80)}}, "invalid site name"},
+		{"empty site name", Manifest{Sites: []ManifestSite{site("", 80)}}, "invalid site name"},
+		{"quote in site name", Manifest{Sites: []ManifestSite{site("a'b", 80)}}, "invalid site name"},
+		{"duplicate site", Manifest{Sites: []ManifestSite{site("a", 80), site("A", 81)}}, "duplicate site"},
+		{"duplicate binding", Manifest{Sites: []ManifestSite{site("a", 80), site("b", 80)}}, "used by both"},
+		{"no binding", Manifest{Sites: []ManifestSite{{Name: "a"}}}, "at least one binding"},
+		{"bad port", Manifest{Sites: []ManifestSite{site("a", 70000)}}, "invalid port"},
+		{"bad protocol", Manifest{Sites: []ManifestSite{{Name: "a", Bindings: []ManifestBinding{{Protocol: "ftp", Port: 21}}}}}, "protocol"},
+		{"bad state", Manifest{Sites: []ManifestSite{{Name: "a", State: "Paused", Bindings: site("a", 80).Bindings}}}, "state"},
+		{"bad pipeline", Manifest{AppPools: []ManifestAppPool{{Name: "Pool", PipelineMode: "Fast"}}}, "pipelineMode"},
+		{"bad runtime", Manifest{AppPools: []ManifestAppPool{{Name: "Pool", RuntimeVersion: runtimeVersion("v3.0")}}}, "runtimeVersion"},
+		{"duplicate pool", Manifest{AppPools: []ManifestAppPool{{Name: "Pool"}, {Name: "pool"}}}, "duplicate app pool"},
+		{
+			"virtual directory escape",
+			Manifest{Sites: []ManifestSite{{Name: "a", Bindings: site("a", 80).Bindings, VirtualDirectories: []ManifestVirtualDirectory{{Path: "/x/../..", PhysicalPath: `D:\x`}}}}},
+			"invalid virtual directory",
+		},
+		{
+			"virtual directory without path",
+			Manifest{Sites: []ManifestSite{{Name: "a", Bindings: site("a", 80).Bindings, VirtualDirectories: []ManifestVirtualDirectory{{Path: "/x"}}}}},
+			"needs a physicalPath",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateManifest(test.manifest)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	if _, err := ParseManifest([]byte(`{"sites":[],"typo":1}`)); err == nil {
+		t.Fatal("unknown field accepted")
+	}
+	if _, err := ParseManifest([]byte("sites:\n  - name: a\n    bindings: [{protocol: http, port: 80}]\n")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	metadataBucket    = "site-metadata"
+	labelMaxLength    = 63
+	metadataMaxLength = 4096
+)
+
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+// siteBuckets are the store buckets whose records are keyed by site ID.
+// They follow a site through a rename and go away with it. Their records
+// carry "siteId" and "site" fields, which a move rewrites.
+var siteBuckets = []string{metadataBucket, headerAssignBucket, baselineBucket, healthConfigBucket, healthLatestBucket, recoveryPolicyBucket, recoveryStateBucket, maintenanceBucket, deployTargetBucket, gitSourceBucket, webhookBucket, deployHooksBucket, slotsBucket}
+
+// siteSeriesBuckets hold many records per site under siteKeyPrefix. They
+// are moved and removed with the site the same way.
+var siteSeriesBuckets = []string{healthHistoryBucket, recoveryEventBucket, deploymentBucket, webhookDeliveryBucket, webhookReplayBucket, slotSwapBucket, fileBackupBucket}
+
+// moveSiteRecords re-keys a site's records after IIS gave it a new ID, as
+// happens when UpdateWebsiteAction recreates a renamed site.
+func moveSiteRecords(oldID int, newID int, newName string) error {
+	if oldID == newID {
+		return nil
+	}
+	for _, bucket := range siteBuckets {
+		// Slots name both of their sites; see moveSlotRecords
+		if bucket == slotsBucket {
+			continue
+		}
+		record := map[string]any{}
+		found, err := storeGet(bucket, strconv.Itoa(oldID), &record)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		record["siteId"] = newID
+		record["site"] = newName
+		if err := storePut(bucket, strconv.Itoa(newID), record); err != nil {
+			return err
+		}
+		if err := storeDelete(bucket, strconv.Itoa(oldID)); err != nil {
+			return err
+		}
+	}
+	if err := moveSlotRecords(oldID, newID, newName); err != nil {
+		return err
+	}
+	for _, bucket := range siteSeriesBuckets {
+		err := storeMoveRange(bucket, siteKeyPrefix(oldID), siteKeyPrefix(newID), func(record map[string]any) {
+			record["siteId"] = newID
+			record["site"] = newName
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func forgetSiteRecords(id int) error {
+	for _, bucket := range siteBuckets {
+		if err := storeDelete(bucket, strconv.Itoa(id)); err != nil {
+			return err
+		}
+	}
+	for _, bucket := range siteSeriesBuckets {
+		if err := storeDeleteRange(bucket, siteKeyPrefix(id), "", ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetSiteMetadataAction(website Website) (SiteMetadata, error) {
+	metadata := SiteMetadata{}
+	found, err := storeGet(metadataBucket, strconv.Itoa(website.ID), &metadata)
+	if err != nil {
+		return metadata, err
+	}
+	if !found {
+		metadata = SiteMetadata{SiteID: website.ID}
+	}
+	metadata.Site = website.Name
+	if metadata.Labels == nil {
+		metadata.Labels = map[string]string{}
+	}
+	return metadata, nil
+}
+
+func SetSiteMetadataAction(website Website, metadata SiteMetadata) (SiteMetadata, error) {
+	if err := ValidateSiteMetadata(metadata); err != nil {
+		return metadata, err
+	}
+	metadata.SiteID = website.ID
+	metadata.Site = website.Name
+	metadata.UpdatedAt = time.Now().UTC()
+	if metadata.Labels == nil {
+		metadata.Labels = map[string]string{}
+	}
+	return metadata, storePut(metadataBucket, strconv.Itoa(website.ID), metadata)
+}
+
+func ValidateSiteMetadata(metadata SiteMetadata) error {
+	for key, value := range metadata.Labels {
+		if len(key) > labelMaxLength || !labelKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid label key %q", key)
+		}
+		if len(value) > labelMaxLength || (value != "" && !labelKeyPattern.MatchString(value)) {
+			return fmt.Errorf("invalid value %q for label %s", value, key)
+		}
+	}
+	if len(metadata.Owner) > labelMaxLength*4 {
+		return fmt.Errorf("owner is too long")
+	}
+	if len(metadata.Notes) > metadataMaxLength {
+		return fmt.Errorf("notes cannot exceed %d characters", metadataMaxLength)
+	}
+	if metadata.Repository != "" {
+		u, err := url.Parse(metadata.Repository)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("repository must be an absolute url")
+		}
+	}
+	return nil
+}
+
+// attachMetadata fills in Metadata on every website that has a record.
+func attachMetadata(websites []Website) ([]Website, error) {
+	records, err := storeList[SiteMetadata](metadataBucket)
+	if err != nil {
+		return websites, err
+	}
+	byID := map[int]SiteMetadata{}
+	for _, record := range records {
+		byID[record.SiteID] = record
+	}
+	for i := range websites {
+		if record, ok := byID[websites[i].ID]; ok {
+			record.Site = websites[i].Name
+			websites[i].Metadata = &record
+		}
+	}
+	return websites, nil
+}
+
+// labelRequirement is one term of a selector such as "team=web,env!=dev,canary".
+type labelRequirement struct {
+	key   string
+	op    string
+	value string
+}
+
+// parseLabelSelector parses comma separated terms: key=value, key!=value,
+// key (label present) and !key (label absent).
+func parseLabelSelector(selector string) ([]labelRequirement, error) {
+	requirements := []labelRequirement{}
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		var req labelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			parts := strings.SplitN(term, "!=", 2)
+			req = labelRequirement{key: strings.TrimSpace(parts[0]), op: "!=", value: strings.TrimSpace(parts[1])}
+		case strings.Contains(term, "="):
+			parts := strings.SplitN(strings.Replace(term, "==", "=", 1), "=", 2)
+			req = labelRequirement{key: strings.TrimSpace(parts[0]), op: "=", value: strings.TrimSpace(parts[1])}
+		case strings.HasPrefix(term, "!"):
+			req = labelRequirement{key: strings.TrimSpace(term[1:]), op: "!"}
+		default:
+			req = labelRequirement{key: term, op: "exists"}
+		}
+		if !labelKeyPattern.MatchString(req.key) {
+			return nil, fmt.Errorf("invalid label selector term %q", term)
+		}
+		requirements = append(requirements, req)
+	}
+	if len(requirements) == 0 {
+		return nil, fmt.Errorf("empty label selector")
+	}
+	return requirements, nil
+}
+
+// matchesLabels reports whether labels satisfy every requirement.
+func matchesLabels(requirements []labelRequirement, labels map[string]string) bool {
+	for _, req := range requirements {
+		value, ok := labels[req.key]
+		switch req.op {
+		case "=":
+			if !ok || value != req.value {
+				return false
+			}
+		case "!=":
+			if ok && value == req.value {
+				return false
+			}
+		case "!":
+			if ok {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SelectWebsites keeps the websites whose labels match selector. Websites
+// must already carry their metadata.
+func SelectWebsites(websites []Website, selector string) ([]Website, error) {
+	requirements, err := parseLabelSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	selected := []Website{}
+	for _, website := range websites {
+		labels := map[string]string{}
+		if website.Metadata != nil {
+			labels = website.Metadata.Labels
+		}
+		if matchesLabels(requirements, labels) {
+			selected = append(selected, website)
+		}
+	}
+	return selected, nil
+}
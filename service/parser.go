@@ -36,10 +36,13 @@ func getColumn(line string) []int {
 func extractColumn(line string, colIndexes []int) []string {
 	parts := []string{}
 	for i, colIndex := range colIndexes {
+		if colIndex >= len(line) {
+			break
+		}
 		var part string
 		if i < len(colIndexes)-1 {
 			// Extract substring from current column to next column
-			part = strings.TrimSpace(line[colIndex:colIndexes[i+1]])
+			part = strings.TrimSpace(line[colIndex:min(colIndexes[i+1], len(line))])
 		} else {
 			// Extract substring from current column to end of line
 			part = strings.TrimSpace(line[colIndex:])
@@ -52,7 +55,10 @@ func extractColumn(line string, colIndexes []int) []string {
 func getSites(rawData string) []Website {
 	websites := []Website{}
 	lines := getLines(rawData)
-	// Trim empty
+	// No table at all when IIS has no sites or Get-Website failed
+	if len(lines) < 2 {
+		return websites
+	}
 
 	colIndex := 0
 	sepIndex := 1
@@ -64,6 +70,9 @@ func getSites(rawData string) []Website {
 			continue
 		}
 		parts := extractColumn(line, colIndexes)
+		if len(parts) < 5 {
+			continue
+		}
 
 		name := parts[0]
 		id, _ := strconv.Atoi(parts[1])
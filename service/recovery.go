@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	recoveryPolicyBucket = "recovery-policies"
+	recoveryStateBucket  = "recovery-state"
+	recoveryEventBucket  = "recovery-events"
+
+	recoveryRecycle   = "recycle"
+	recoveryRestart   = "restart"
+	recoveryAlert     = "alert"
+	recoveryRecovered = "recovered"
+
+	// flappingRecoveries within flappingWindow mark a site as flapping
+	flappingRecoveries = 3
+	flappingWindow     = time.Hour
+
+	// recoveryMaxBackoffSeconds bounds both backoff settings to a day
+	recoveryMaxBackoffSeconds = 86400
+)
+
+var recoverySteps = []string{recoveryRecycle, recoveryRestart, recoveryAlert}
+
+func normalizeRecoveryPolicy(policy RecoveryPolicy) RecoveryPolicy {
+	if policy.FailureThreshold == 0 {
+		policy.FailureThreshold = 3
+	}
+	if len(policy.Steps) == 0 {
+		policy.Steps = slices.Clone(recoverySteps)
+	}
+	if policy.BackoffSeconds == 0 {
+		policy.BackoffSeconds = 60
+	}
+	if policy.MaxBackoffSeconds == 0 {
+		policy.MaxBackoffSeconds = 3600
+	}
+	if policy.MaxRestartsPerHour == 0 {
+		policy.MaxRestartsPerHour = 3
+	}
+	return policy
+}
+
+func ValidateRecoveryPolicy(policy RecoveryPolicy) error {
+	if policy.FailureThreshold < 1 || policy.FailureThreshold > 100 {
+		return fmt.Errorf("failureThreshold must be between 1 and 100")
+	}
+	for _, step := range policy.Steps {
+		if !slices.Contains(recoverySteps, step) {
+			return fmt.Errorf("unknown step %s, valid steps are: recycle, restart, alert", step)
+		}
+	}
+	if policy.BackoffSeconds < 1 || policy.MaxBackoffSeconds < policy.BackoffSeconds {
+		return fmt.Errorf("backoffSeconds must be at least 1 and no more than maxBackoffSeconds")
+	}
+	if policy.MaxBackoffSeconds > recoveryMaxBackoffSeconds {
+		return fmt.Errorf("maxBackoffSeconds cannot exceed %d", recoveryMaxBackoffSeconds)
+	}
+	if policy.MaxRestartsPerHour < 1 {
+		return fmt.Errorf("maxRestartsPerHour must be at least 1")
+	}
+	if policy.AlertWebhook != "" {
+		u, err := url.Parse(policy.AlertWebhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("alertWebhook must be an http or https url")
+		}
+	}
+	return nil
+}
+
+func GetRecoveryPolicyAction(website Website) (RecoveryPolicy, error) {
+	policy := RecoveryPolicy{}
+	if _, err := storeGet(recoveryPolicyBucket, strconv.Itoa(website.ID), &policy); err != nil {
+		return policy, err
+	}
+	policy.SiteID = website.ID
+	policy.Site = website.Name
+	return normalizeRecoveryPolicy(policy), nil
+}
+
+func SetRecoveryPolicyAction(website Website, policy RecoveryPolicy) (RecoveryPolicy, error) {
+	policy = normalizeRecoveryPolicy(policy)
+	if err := ValidateRecoveryPolicy(policy); err != nil {
+		return policy, err
+	}
+	policy.SiteID = website.ID
+	policy.Site = website.Name
+	return policy, storePut(recoveryPolicyBucket, strconv.Itoa(website.ID), policy)
+}
+
+// PlanRecovery decides what a health result calls for. It returns the next
+// state and the action to take now, "" for none. Failures only count while
+// a site is unhealthy; stopped sites and sites in maintenance are left
+// alone. Each attempt moves one step down the policy, repeating the last,
+// and waits twice as long as the one before. Restarts past the hourly cap
+// escalate to an alert instead.
+func PlanRecovery(policy RecoveryPolicy, state RecoveryState, result HealthResult, now time.Time) (RecoveryState, string, string) {
+	state.RestartTimes = slices.DeleteFunc(state.RestartTimes, func(t time.Time) bool { return now.Sub(t) >= time.Hour })
+	switch result.Status {
+	case healthHealthy, healthDegraded:
+		recovered := state.Attempt > 0
+		state.ConsecutiveFailures, state.Attempt, state.NextAttemptAt = 0, 0, time.Time{}
+		if recovered {
+			return state, recoveryRecovered, "healthy again"
+		}
+		return state, "", ""
+	case healthUnhealthy:
+	default:
+		return state, "", ""
+	}
+	state.ConsecutiveFailures++
+	if state.ConsecutiveFailures < policy.FailureThreshold || now.Before(state.NextAttemptAt) {
+		return state, "", ""
+	}
+	action := policy.Steps[min(state.Attempt, len(policy.Steps)-1)]
+	detail := result.Message
+	if action == recoveryRestart && len(state.RestartTimes) >= policy.MaxRestartsPerHour {
+		action = recoveryAlert
+		detail = fmt.Sprintf("restart cap of %d per hour reached; %s", policy.MaxRestartsPerHour, result.Message)
+	}
+	if action == recoveryRestart {
+		state.RestartTimes = append(state.RestartTimes, now)
+	}
+	state.NextAttemptAt = now.Add(recoveryBackoff(policy, state.Attempt))
+	state.Attempt++
+	return state, action, detail
+}
+
+// recoveryBackoff doubles the policy's backoff once per earlier attempt and
+// stops at its maximum. Doubling stops there too, so no attempt count can
+// overflow, and policies stored before the settings were capped are held
+// to the cap here.
+func recoveryBackoff(policy RecoveryPolicy, attempt int) time.Duration {
+	limit := time.Duration(min(policy.MaxBackoffSeconds, recoveryMaxBackoffSeconds)) * time.Second
+	backoff := time.Duration(max(min(policy.BackoffSeconds, recoveryMaxBackoffSeconds), 1)) * time.Second
+	for i := 0; i < attempt && backoff < limit; i++ {
+		backoff *= 2
+	}
+	return min(backoff, limit)
+}
+
+func RecycleAppPoolAction(pool string) error {
+	ps := fmt.Sprintf(`Import-Module WebAdministration; Restart-WebAppPool -Name %s`, psQuote(pool))
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to recycle app pool %s: %v", pool, err)
+	}
+	return nil
+}
+
+func recycleSiteAppPool(website Website) error {
+	live, err := readLiveManifest(func(string) bool { return false })
+	if err != nil {
+		return err
+	}
+	site, ok := liveManifestSite(live, website.Name)
+	if !ok || site.AppPool == "" {
+		return fmt.Errorf("no app pool found for %s", website.Name)
+	}
+	return RecycleAppPoolAction(site.AppPool)
+}
+
+func sendRecoveryAlert(policy RecoveryPolicy, event RecoveryEvent) error {
+	log.Printf("recovery alert for %s: %s", event.Site, event.Detail)
+	if policy.AlertWebhook == "" {
+		return nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Post(policy.AlertWebhook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to send alert: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook answered %s", response.Status)
+	}
+	return nil
+}
+
+// recordRecoveryEvent stores an event and prunes the site's events past
+// the health history retention, which they are read alongside.
+func recordRecoveryEvent(event RecoveryEvent) error {
+	if err := storePut(recoveryEventBucket, siteKeyPrefix(event.SiteID)+event.At.Format(storeTimeFormat), event); err != nil {
+		return err
+	}
+	settings, err := GetHealthSettingsAction()
+	if err != nil {
+		return err
+	}
+	cutoff := event.At.AddDate(0, 0, -settings.HistoryDays).Format(storeTimeFormat)
+	return storeDeleteRange(recoveryEventBucket, siteKeyPrefix(event.SiteID), "", cutoff)
+}
+
+// RecoverAction runs the recovery policy of every checked site against its
+// latest result and records each action it takes.
+func RecoverAction(results []HealthResult) error {
+	now := time.Now().UTC()
+	for _, result := range results {
+		website := Website{ID: result.SiteID, Name: result.Site}
+		policy, err := GetRecoveryPolicyAction(website)
+		if err != nil {
+			return err
+		}
+		if !policy.Enabled {
+			continue
+		}
+		state := RecoveryState{}
+		if _, err := storeGet(recoveryStateBucket, strconv.Itoa(website.ID), &state); err != nil {
+			return err
+		}
+		failures, attempts := state.ConsecutiveFailures, state.Attempt
+		state, action, detail := PlanRecovery(policy, state, result, now)
+		state.SiteID, state.Site = website.ID, website.Name
+		if err := storePut(recoveryStateBucket, strconv.Itoa(website.ID), state); err != nil {
+			return err
+		}
+		if action == "" {
+			continue
+		}
+		event := RecoveryEvent{
+			SiteID:              website.ID,
+			Site:                website.Name,
+			At:                  now,
+			Action:              action,
+			Success:             true,
+			Detail:              detail,
+			ConsecutiveFailures: max(failures, state.ConsecutiveFailures),
+			Attempt:             max(attempts, state.Attempt),
+		}
+		switch action {
+		case recoveryRecycle:
+			err = recycleSiteAppPool(website)
+		case recoveryRestart:
+			err = ControlWebsiteAction(ActionRestart, website.Name)
+		case recoveryAlert:
+			err = sendRecoveryAlert(policy, event)
+		}
+		if err != nil {
+			event.Success = false
+			event.Error = err.Error()
+		}
+		if err := recordRecoveryEvent(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRecoveryEventsAction lists events newest first, for one site or, with
+// a zero website, for all of them.
+func GetRecoveryEventsAction(website Website, limit int) ([]RecoveryEvent, error) {
+	if website.ID != 0 && limit > 0 {
+		return storeListLast[RecoveryEvent](recoveryEventBucket, siteKeyPrefix(website.ID), limit)
+	}
+	prefix := ""
+	if website.ID != 0 {
+		prefix = siteKeyPrefix(website.ID)
+	}
+	events, err := storeListRange[RecoveryEvent](recoveryEventBucket, prefix, "", "")
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].At.After(events[j].At) })
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// IsFlapping reports whether a site keeps recovering and failing again,
+// which self-healing is hiding rather than fixing.
+func IsFlapping(events []RecoveryEvent, now time.Time) bool {
+	recoveries := 0
+	for _, event := range events {
+		if event.Action == recoveryRecovered && now.Sub(event.At) < flappingWindow {
+			recoveries++
+		}
+	}
+	return recoveries >= flappingRecoveries
+}
+
+func GetRecoveryStatusAction(website Website) (RecoveryStatus, error) {
+	status := RecoveryStatus{}
+	policy, err := GetRecoveryPolicyAction(website)
+	if err != nil {
+		return status, err
+	}
+	state := RecoveryState{SiteID: website.ID, Site: website.Name}
+	if _, err := storeGet(recoveryStateBucket, strconv.Itoa(website.ID), &state); err != nil {
+		return status, err
+	}
+	events, err := GetRecoveryEventsAction(website, 20)
+	if err != nil {
+		return status, err
+	}
+	return RecoveryStatus{Policy: policy, State: state, Flapping: IsFlapping(events, time.Now()), Events: events}, nil
+}
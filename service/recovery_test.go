@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateRecoveryPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RecoveryPolicy
+		err    string
+	}{
+		{"defaults", RecoveryPolicy{}, ""},
+		{"a day", RecoveryPolicy{BackoffSeconds: 60, MaxBackoffSeconds: recoveryMaxBackoffSeconds}, ""},
+		{"max above a day", RecoveryPolicy{BackoffSeconds: 60, MaxBackoffSeconds: recoveryMaxBackoffSeconds + 1}, "maxBackoffSeconds"},
+		{"huge backoff", RecoveryPolicy{BackoffSeconds: math.MaxInt, MaxBackoffSeconds: math.MaxInt}, "maxBackoffSeconds"},
+		{"backoff above max", RecoveryPolicy{BackoffSeconds: 120, MaxBackoffSeconds: 60}, "backoffSeconds"},
+		{"negative backoff", RecoveryPolicy{BackoffSeconds: -1}, "backoffSeconds"},
+		{"unknown step", RecoveryPolicy{Steps: []string{"reboot"}}, "unknown step"},
+		{"threshold", RecoveryPolicy{FailureThreshold: 101}, "failureThreshold"},
+		{"webhook", RecoveryPolicy{AlertWebhook: "ftp://example.com"}, "alertWebhook"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateRecoveryPolicy(normalizeRecoveryPolicy(test.policy))
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestRecoveryBackoff(t *testing.T) {
+	policy := normalizeRecoveryPolicy(RecoveryPolicy{})
+	day := time.Duration(recoveryMaxBackoffSeconds) * time.Second
+	tests := []struct {
+		name    string
+		policy  RecoveryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"first attempt", policy, 0, time.Minute},
+		{"doubles", policy, 1, 2 * time.Minute},
+		{"doubles again", policy, 3, 8 * time.Minute},
+		{"stops at the maximum", policy, 6, time.Hour},
+		{"many attempts", policy, 64, time.Hour},
+		{"every attempt", policy, math.MaxInt, time.Hour},
+		{"stored before the cap", RecoveryPolicy{BackoffSeconds: 60, MaxBackoffSeconds: math.MaxInt}, math.MaxInt, day},
+		{"huge stored backoff", RecoveryPolicy{BackoffSeconds: math.MaxInt, MaxBackoffSeconds: math.MaxInt}, 3, day},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := recoveryBackoff(test.policy, test.attempt); got != test.want {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestPlanRecovery(t *testing.T) {
+	policy := normalizeRecoveryPolicy(RecoveryPolicy{Enabled: true, MaxRestartsPerHour: 1})
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	down := HealthResult{Status: healthUnhealthy, Message: "down"}
+	up := HealthResult{Status: healthHealthy}
+
+	// Each check runs on the state the one before left behind
+	checks := []struct {
+		name   string
+		after  time.Duration
+		result HealthResult
+		action string
+		wait   time.Duration
+	}{
+		{"below threshold", 0, down, "", 0},
+		{"still below threshold", time.Second, down, "", 0},
+		{"threshold recycles", 2 * time.Second, down, recoveryRecycle, time.Minute},
+		{"waits out the backoff", 30 * time.Second, down, "", time.Minute - 28*time.Second},
+		{"then restarts", 2*time.Second + time.Minute, down, recoveryRestart, 2 * time.Minute},
+		{"then alerts", 2*time.Second + 3*time.Minute, down, recoveryAlert, 4 * time.Minute},
+		{"stopped sites are left alone", 2*time.Second + 5*time.Minute, HealthResult{Status: healthStopped}, "", 2 * time.Minute},
+		{"repeats the last step", 2*time.Second + 7*time.Minute, down, recoveryAlert, 8 * time.Minute},
+		{"recovers", 2*time.Second + 8*time.Minute, up, recoveryRecovered, 0},
+		{"healthy again is quiet", 2*time.Second + 9*time.Minute, up, "", 0},
+	}
+	state := RecoveryState{}
+	for _, check := range checks {
+		now := start.Add(check.after)
+		var action string
+		state, action, _ = PlanRecovery(policy, state, check.result, now)
+		if action != check.action {
+			t.Fatalf("%s: expected action %q, got %q", check.name, check.action, action)
+		}
+		var wait time.Duration
+		if !state.NextAttemptAt.IsZero() {
+			wait = state.NextAttemptAt.Sub(now)
+		}
+		if wait != check.wait {
+			t.Fatalf("%s: expected the next attempt in %v, got %v", check.name, check.wait, wait)
+		}
+	}
+
+	// A restart past the hourly cap alerts instead, until the hour is up
+	capped := policy
+	capped.Steps = []string{recoveryRestart}
+	now := start.Add(2 * time.Hour)
+	failing := RecoveryState{ConsecutiveFailures: 5, RestartTimes: []time.Time{now.Add(-30 * time.Minute)}}
+	if _, action, detail := PlanRecovery(capped, failing, down, now); action != recoveryAlert || !strings.Contains(detail, "restart cap") {
+		t.Fatalf("expected the restart cap to alert, got %s %s", action, detail)
+	}
+	failing.RestartTimes = []time.Time{now.Add(-2 * time.Hour)}
+	if next, action, _ := PlanRecovery(capped, failing, down, now); action != recoveryRestart || len(next.RestartTimes) != 1 {
+		t.Fatalf("expected a restart once the cap expired, got %s %+v", action, next)
+	}
+
+	// Long failing sites keep planning without the wait overflowing
+	failing = RecoveryState{ConsecutiveFailures: 1000, Attempt: math.MaxInt - 1}
+	next, _, _ := PlanRecovery(policy, failing, down, now)
+	if !next.NextAttemptAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expected the maximum backoff, got %v", next.NextAttemptAt.Sub(now))
+	}
+}
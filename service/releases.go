@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// GetDeploymentsAction returns a site's deployments and rollbacks, newest
+// first, failed ones included.
+func GetDeploymentsAction(website Website) ([]Deployment, error) {
+	deployments, err := storeListRange[Deployment](deploymentBucket, siteKeyPrefix(website.ID), "", "")
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(deployments)
+	return deployments, nil
+}
+
+// GetReleasesAction lists the releases still on disk, newest first. The
+// one the site points at is marked active.
+func GetReleasesAction(website Website) ([]Release, error) {
+	deployments, err := GetDeploymentsAction(website)
+	if err != nil {
+		return nil, err
+	}
+	releases := []Release{}
+	for _, deployment := range deployments {
+		if deployment.Kind != deployKind || deployment.Status != deployedStatus {
+			continue
+		}
+		releases = append(releases, Release{Deployment: deployment, Active: samePath(deployment.Path, website.PhysicalPath)})
+	}
+	return releases, nil
+}
+
+func getRelease(website Website, id string) (Release, error) {
+	releases, err := GetReleasesAction(website)
+	if err != nil {
+		return Release{}, err
+	}
+	i := slices.IndexFunc(releases, func(r Release) bool { return r.ID == id })
+	if i < 0 {
+		return Release{}, errReleaseNotFound
+	}
+	return releases[i], nil
+}
+
+// RollbackAction points the site back at an earlier release, or forward at
+// a later one. The release is used as it is on disk, web.config included.
+func RollbackAction(website Website, id string) (Deployment, error) {
+	deployMu.Lock()
+	defer deployMu.Unlock()
+	release, err := getRelease(website, id)
+	if err != nil {
+		return Deployment{}, err
+	}
+	if release.Active {
+		return release.Deployment, errReleaseActive
+	}
+	maintenance, err := GetMaintenanceAction(website)
+	if err != nil {
+		return Deployment{}, err
+	}
+	if maintenance.Active {
+		return Deployment{}, errDeployInMaintenance
+	}
+	now := time.Now().UTC()
+	rollback := release.Deployment
+	rollback.ID = now.Format(storeTimeFormat)
+	rollback.CreatedAt = now
+	rollback.Kind = rollbackKind
+	rollback.ReleaseID = release.ID
+	rollback.PreviousPath = website.PhysicalPath
+	rollback.Steps = []DeployStep{}
+	rollback.Hooks = nil
+	hooks, err := GetDeployHooksAction(website)
+	if err != nil {
+		return Deployment{}, err
+	}
+	if _, err := os.Stat(release.Path); err != nil {
+		return rollback, rollback.fail(fmt.Errorf("release %s is missing on disk: %v", release.ID, err))
+	}
+	if err := rollback.runHooks(hooks, hookPreSwap, release.Path); err != nil {
+		return rollback, rollback.fail(err)
+	}
+	err = rollback.step("swap", func() (string, error) {
+		return release.Path, SetSitePhysicalPathAction(website.Name, release.Path)
+	})
+	if err != nil {
+		return rollback, rollback.fail(err)
+	}
+	rollback.runHooks(hooks, hookPostSwap, release.Path)
+	rollback.Status = deployedStatus
+	return rollback, recordDeployment(rollback)
+}
+
+// DeleteReleaseAction removes an inactive release from disk. Its record
+// stays in the history, marked pruned.
+func DeleteReleaseAction(website Website, id string) error {
+	deployMu.Lock()
+	defer deployMu.Unlock()
+	release, err := getRelease(website, id)
+	if err != nil {
+		return err
+	}
+	if release.Active {
+		return errReleaseActive
+	}
+	return removeRelease(website, release.Deployment)
+}
+
+func removeRelease(website Website, release Deployment) error {
+	target, err := getDeployTarget(website)
+	if err != nil {
+		return err
+	}
+	// Only ever delete directories this service created
+	if !samePath(filepath.Dir(release.Path), target.ReleasesDir) {
+		return fmt.Errorf("release %s is outside %s", release.ID, target.ReleasesDir)
+	}
+	if err := os.RemoveAll(release.Path); err != nil {
+		return fmt.Errorf("failed to remove release %s: %v", release.ID, err)
+	}
+	release.Status = prunedStatus
+	return recordDeployment(release)
+}
+
+// pruneReleases keeps the newest keep releases and the active one, and
+// removes the rest. It returns the IDs removed.
+func pruneReleases(website Website, keep int) ([]string, error) {
+	releases, err := GetReleasesAction(website)
+	if err != nil {
+		return nil, err
+	}
+	pruned := []string{}
+	for i, release := range releases {
+		if i < keep || release.Active {
+			continue
+		}
+		if err := removeRelease(website, release.Deployment); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, release.ID)
+	}
+	return pruned, nil
+}
+
+// PruneReleasesAction applies the KeepReleases retention now.
+func PruneReleasesAction(website Website) ([]string, error) {
+	deployMu.Lock()
+	defer deployMu.Unlock()
+	settings, err := GetDeploySettingsAction()
+	if err != nil {
+		return nil, err
+	}
+	return pruneReleases(website, settings.KeepReleases)
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const rewriteRulesFilter = "system.webServer/rewrite/rules"
+
+var (
+	errRewriteRuleNotFound = errors.New("rewrite rule not found")
+	errRewriteOrder        = errors.New("invalid rule order")
+)
+
+var rewriteActionTypes = []string{"None", "Rewrite", "Redirect", "CustomResponse", "AbortRequest"}
+
+var rewriteRedirectCodes = map[string]int{
+	"Permanent": 301,
+	"Found":     302,
+	"SeeOther":  303,
+	"Temporary": 307,
+}
+
+// rewriteVarPattern matches {R:1}, {C:0} and {SERVER_VARIABLE} references.
+var rewriteVarPattern = regexp.MustCompile(`\{([A-Za-z_]+)(?::(\d+))?\}`)
+
+func GetRewriteRulesAction(site string) ([]RewriteRule, error) {
+	ps := fmt.Sprintf(`Import-Module WebAdministration;
+		$rules = Get-WebConfiguration -PSPath %s -Filter '%s/rule' | ForEach-Object {
+			$rule = $_
+			[PSCustomObject]@{
+				name = $rule.name
+				stopProcessing = [bool]$rule.stopProcessing
+				matchUrl = $rule.match.url
+				ignoreCase = [bool]$rule.match.ignoreCase
+				negate = [bool]$rule.match.negate
+				logicalGrouping = [string]$rule.conditions.logicalGrouping
+				conditions = @($rule.conditions.Collection | ForEach-Object {
+					[PSCustomObject]@{
+						input = $_.input
+						pattern = $_.pattern
+						ignoreCase = [bool]$_.ignoreCase
+						negate = [bool]$_.negate
+					}
+				})
+				action = [PSCustomObject]@{
+					type = [string]$rule.action.type
+					url = $rule.action.url
+					redirectType = [string]$rule.action.redirectType
+					appendQueryString = [bool]$rule.action.appendQueryString
+					statusCode = [int]$rule.action.statusCode
+					statusReason = $rule.action.statusReason
+				}
+			}
+		};
+		ConvertTo-Json -InputObject @($rules) -Depth 5`, sitePSPath(site), rewriteRulesFilter)
+	out, err := runPowerShell(ps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rewrite rules for %s: %v", site, err)
+	}
+	rules, err := decodePSList[RewriteRule](out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rewrite rules for %s: %v", site, err)
+	}
+	return rules, nil
+}
+
+// SetRewriteRulesAction replaces the site's rule collection with rules, in
+// order. Callers edit the list in Go and write it back whole, which keeps
+// add, delete and reorder the same operation on the IIS side. The whole
+// collection is built in one ServerManager and committed once, so a failure
+// part way leaves the site's rules as they were.
+func SetRewriteRulesAction(site string, rules []RewriteRule) error {
+	if err := ValidateRewriteRules(rules); err != nil {
+		return err
+	}
+	payload, err := psJSON(rules)
+	if err != nil {
+		return err
+	}
+	// The rules read back are the effective ones, inherited included, so
+	// the collection starts with <clear /> and holds all of them
+	ps := fmt.Sprintf(`%s;
+		$manager = New-Object Microsoft.Web.Administration.ServerManager;
+		if (-not $manager.Sites[%s]) { throw 'site not found' };
+		$config = $manager.GetWebConfiguration(%s);
+		$collection = $config.GetSection('%s').GetCollection();
+		$collection.Clear();
+		foreach ($r in %s) {
+			$rule = $collection.CreateElement('rule');
+			$rule['name'] = $r.name;
+			$rule['stopProcessing'] = [bool]$r.stopProcessing;
+			$match = $rule.GetChildElement('match');
+			$match['url'] = $r.matchUrl;
+			$match['ignoreCase'] = [bool]$r.ignoreCase;
+			$match['negate'] = [bool]$r.negate;
+			$conditions = $rule.GetChildElement('conditions');
+			$conditions['logicalGrouping'] = $r.logicalGrouping;
+			foreach ($c in $r.conditions) {
+				$condition = $conditions.GetCollection().CreateElement('add');
+				$condition['input'] = $c.input;
+				$condition['pattern'] = $c.pattern;
+				$condition['ignoreCase'] = [bool]$c.ignoreCase;
+				$condition['negate'] = [bool]$c.negate;
+				[void]$conditions.GetCollection().Add($condition);
+			}
+			$action = $rule.GetChildElement('action');
+			$action['type'] = $r.action.type;
+			if ($r.action.url) { $action['url'] = $r.action.url }
+			if ($r.action.type -eq 'Redirect') { $action['redirectType'] = $r.action.redirectType }
+			if ($r.action.type -in 'Redirect', 'Rewrite') { $action['appendQueryString'] = [bool]$r.action.appendQueryString }
+			if ($r.action.type -eq 'CustomResponse') {
+				$action['statusCode'] = [uint32]$r.action.statusCode;
+				if ($r.action.statusReason) { $action['statusReason'] = $r.action.statusReason }
+			}
+			[void]$collection.Add($rule);
+		};
+		$manager.CommitChanges()`, mwaAssembly, psQuote(site), psQuote(site), rewriteRulesFilter, payload)
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to write rewrite rules for %s: %v", site, err)
+	}
+	return nil
+}
+
+func AddRewriteRuleAction(site string, rule RewriteRule) error {
+	rules, err := GetRewriteRulesAction(site)
+	if err != nil {
+		return err
+	}
+	return SetRewriteRulesAction(site, append(rules, normalizeRewriteRule(rule)))
+}
+
+func DeleteRewriteRuleAction(site string, name string) error {
+	rules, err := GetRewriteRulesAction(site)
+	if err != nil {
+		return err
+	}
+	kept := []RewriteRule{}
+	for _, rule := range rules {
+		if rule.Name != name {
+			kept = append(kept, rule)
+		}
+	}
+	if len(kept) == len(rules) {
+		return fmt.Errorf("%w: %s", errRewriteRuleNotFound, name)
+	}
+	return SetRewriteRulesAction(site, kept)
+}
+
+// ReorderRewriteRulesAction applies a new order given by rule names. Every
+// existing rule must be named exactly once.
+func ReorderRewriteRulesAction(site string, names []string) error {
+	rules, err := GetRewriteRulesAction(site)
+	if err != nil {
+		return err
+	}
+	if len(names) != len(rules) {
+		return fmt.Errorf("%w: expected %d rule names, got %d", errRewriteOrder, len(rules), len(names))
+	}
+	byName := map[string]RewriteRule{}
+	for _, rule := range rules {
+		byName[rule.Name] = rule
+	}
+	ordered := []RewriteRule{}
+	for _, name := range names {
+		rule, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("%w: rewrite rule %s not found or listed twice", errRewriteOrder, name)
+		}
+		delete(byName, name)
+		ordered = append(ordered, rule)
+	}
+	return SetRewriteRulesAction(site, ordered)
+}
+
+func normalizeRewriteRule(rule RewriteRule) RewriteRule {
+	if rule.LogicalGrouping == "" {
+		rule.LogicalGrouping = "MatchAll"
+	}
+	if rule.Conditions == nil {
+		rule.Conditions = []RewriteCondition{}
+	}
+	if rule.Action.Type == "Redirect" && rule.Action.RedirectType == "" {
+		rule.Action.RedirectType = "Permanent"
+	}
+	return rule
+}
+
+func ValidateRewriteRules(rules []RewriteRule) error {
+	seen := map[string]bool{}
+	for _, rule := range rules {
+		if strings.TrimSpace(rule.Name) == "" {
+			return fmt.Errorf("rule name is required")
+		}
+		if strings.ContainsAny(rule.Name, `'"[]`) {
+			return fmt.Errorf("rule name %s contains invalid characters", rule.Name)
+		}
+		if seen[rule.Name] {
+			return fmt.Errorf("duplicate rule name %s", rule.Name)
+		}
+		seen[rule.Name] = true
+		if _, err := regexp.Compile(rule.MatchURL); err != nil {
+			return fmt.Errorf("rule %s: invalid match url pattern: %v", rule.Name, err)
+		}
+		if rule.LogicalGrouping != "" && rule.LogicalGrouping != "MatchAll" && rule.LogicalGrouping != "MatchAny" {
+			return fmt.Errorf("rule %s: logicalGrouping must be MatchAll or MatchAny", rule.Name)
+		}
+		for _, cond := range rule.Conditions {
+			if cond.Input == "" {
+				return fmt.Errorf("rule %s: condition input is required", rule.Name)
+			}
+			if _, err := regexp.Compile(cond.Pattern); err != nil {
+				return fmt.Errorf("rule %s: invalid condition pattern: %v", rule.Name, err)
+			}
+		}
+		if !slices.Contains(rewriteActionTypes, rule.Action.Type) {
+			return fmt.Errorf("rule %s: action type must be one of %s", rule.Name, strings.Join(rewriteActionTypes, ", "))
+		}
+		switch rule.Action.Type {
+		case "Rewrite", "Redirect":
+			if rule.Action.URL == "" {
+				return fmt.Errorf("rule %s: action url is required for %s", rule.Name, rule.Action.Type)
+			}
+			if rule.Action.Type == "Redirect" && rule.Action.RedirectType != "" {
+				if _, ok := rewriteRedirectCodes[rule.Action.RedirectType]; !ok {
+					return fmt.Errorf("rule %s: invalid redirect type %s", rule.Name, rule.Action.RedirectType)
+				}
+			}
+		case "CustomResponse":
+			if rule.Action.StatusCode < 100 || rule.Action.StatusCode > 999 {
+				return fmt.Errorf("rule %s: custom response needs a status code", rule.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// RewritePresetRule builds a rule for one of the common redirect setups.
+func RewritePresetRule(preset RewritePresetRequest) (RewriteRule, error) {
+	scheme := preset.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	switch preset.Preset {
+	case "https":
+		return RewriteRule{
+			Name:            "Redirect to HTTPS",
+			StopProcessing:  true,
+			MatchURL:        "(.*)",
+			LogicalGrouping: "MatchAll",
+			Conditions:      []RewriteCondition{{Input: "{HTTPS}", Pattern: "^OFF$", IgnoreCase: true}},
+			Action:          RewriteAction{Type: "Redirect", URL: "https://{HTTP_HOST}/{R:1}", RedirectType: "Permanent", AppendQueryString: true},
+		}, nil
+	case "www":
+		if preset.Host == "" {
+			return RewriteRule{}, fmt.Errorf("host is required for the www preset")
+		}
+		return RewriteRule{
+			Name:            "Redirect to www",
+			StopProcessing:  true,
+			MatchURL:        "(.*)",
+			LogicalGrouping: "MatchAll",
+			Conditions:      []RewriteCondition{{Input: "{HTTP_HOST}", Pattern: "^" + regexp.QuoteMeta(preset.Host) + "$", IgnoreCase: true}},
+			Action:          RewriteAction{Type: "Redirect", URL: fmt.Sprintf("%s://www.%s/{R:1}", scheme, preset.Host), RedirectType: "Permanent", AppendQueryString: true},
+		}, nil
+	case "non-www":
+		return RewriteRule{
+			Name:            "Redirect to non-www",
+			StopProcessing:  true,
+			MatchURL:        "(.*)",
+			LogicalGrouping: "MatchAll",
+			Conditions:      []RewriteCondition{{Input: "{HTTP_HOST}", Pattern: `^www\.(.+)$`, IgnoreCase: true}},
+			Action:          RewriteAction{Type: "Redirect", URL: scheme + "://{C:1}/{R:1}", RedirectType: "Permanent", AppendQueryString: true},
+		}, nil
+	case "reverse-proxy":
+		target, err := url.Parse(preset.Target)
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return RewriteRule{}, fmt.Errorf("target must be an absolute url for the reverse-proxy preset")
+		}
+		return RewriteRule{
+			Name:            "Reverse proxy to " + target.Host,
+			StopProcessing:  true,
+			MatchURL:        "(.*)",
+			LogicalGrouping: "MatchAll",
+			Conditions:      []RewriteCondition{},
+			Action:          RewriteAction{Type: "Rewrite", URL: strings.TrimRight(preset.Target, "/") + "/{R:1}", AppendQueryString: true},
+		}, nil
+	}
+	return RewriteRule{}, fmt.Errorf("unknown preset %s, valid presets are: https, www, non-www, reverse-proxy", preset.Preset)
+}
+
+// EvaluateRewriteRules runs a sample request through rules the way the URL
+// Rewrite module does for inbound rules: the match pattern is applied to the
+// path without its leading slash, conditions read server variables, and a
+// Rewrite action feeds its result into the next rule.
+func EvaluateRewriteRules(rules []RewriteRule, sample string) (RewriteTestResult, error) {
+	u, err := url.Parse(sample)
+	if err != nil || u.Host == "" {
+		return RewriteTestResult{}, fmt.Errorf("sample must be an absolute url")
+	}
+	vars := map[string]string{
+		"HTTP_HOST":    u.Host,
+		"SERVER_NAME":  u.Hostname(),
+		"SERVER_PORT":  u.Port(),
+		"HTTPS":        "off",
+		"QUERY_STRING": u.RawQuery,
+		"REQUEST_URI":  u.RequestURI(),
+		"URL":          u.EscapedPath(),
+	}
+	if u.Scheme == "https" {
+		vars["HTTPS"] = "on"
+	}
+	if vars["SERVER_PORT"] == "" {
+		vars["SERVER_PORT"] = "80"
+		if u.Scheme == "https" {
+			vars["SERVER_PORT"] = "443"
+		}
+	}
+
+	result := RewriteTestResult{Input: sample, Trace: []RewriteTraceStep{}}
+	current := strings.TrimPrefix(u.EscapedPath(), "/")
+	for _, rule := range rules {
+		step := RewriteTraceStep{Rule: rule.Name, Input: current}
+		ruleCaptures, matched := rewriteMatch(rule.MatchURL, current, rule.IgnoreCase)
+		if matched == rule.Negate {
+			step.Reason = "pattern did not match"
+			result.Trace = append(result.Trace, step)
+			continue
+		}
+		condCaptures, ok := rewriteConditions(rule, vars, ruleCaptures)
+		if !ok {
+			step.Reason = "conditions not met"
+			result.Trace = append(result.Trace, step)
+			continue
+		}
+		step.Matched = true
+		target := expandRewriteURL(rule.Action.URL, vars, ruleCaptures, condCaptures)
+		switch rule.Action.Type {
+		case "Rewrite":
+			if rule.Action.AppendQueryString && u.RawQuery != "" && !strings.Contains(target, "?") {
+				target += "?" + u.RawQuery
+			}
+			step.Output = target
+			result.Trace = append(result.Trace, step)
+			if strings.Contains(target, "://") {
+				result.Action = "Proxy"
+				result.URL = target
+				return result, nil
+			}
+			current = strings.TrimPrefix(strings.SplitN(target, "?", 2)[0], "/")
+		case "Redirect":
+			if rule.Action.AppendQueryString && u.RawQuery != "" && !strings.Contains(target, "?") {
+				target += "?" + u.RawQuery
+			}
+			code, ok := rewriteRedirectCodes[rule.Action.RedirectType]
+			if !ok {
+				code = 301
+			}
+			step.Output = target
+			result.Trace = append(result.Trace, step)
+			result.Action = "Redirect"
+			result.StatusCode = code
+			result.URL = target
+			return result, nil
+		case "CustomResponse":
+			result.Trace = append(result.Trace, step)
+			result.Action = "CustomResponse"
+			result.StatusCode = rule.Action.StatusCode
+			return result, nil
+		case "AbortRequest":
+			result.Trace = append(result.Trace, step)
+			result.Action = "AbortRequest"
+			return result, nil
+		default:
+			result.Trace = append(result.Trace, step)
+		}
+		if rule.StopProcessing {
+			break
+		}
+	}
+	result.Action = "Serve"
+	result.URL = "/" + current
+	return result, nil
+}
+
+func rewriteMatch(pattern string, input string, ignoreCase bool) ([]string, bool) {
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, false
+	}
+	m := re.FindStringSubmatch(input)
+	return m, m != nil
+}
+
+// rewriteConditions evaluates the rule's conditions and returns the captures
+// of the last condition that matched, which is what {C:n} refers to.
+func rewriteConditions(rule RewriteRule, vars map[string]string, ruleCaptures []string) ([]string, bool) {
+	if len(rule.Conditions) == 0 {
+		return nil, true
+	}
+	var captures []string
+	matchAny := rule.LogicalGrouping == "MatchAny"
+	for _, cond := range rule.Conditions {
+		input := expandRewriteURL(cond.Input, vars, ruleCaptures, captures)
+		m, matched := rewriteMatch(cond.Pattern, input, cond.IgnoreCase)
+		ok := matched != cond.Negate
+		if ok && matched {
+			captures = m
+		}
+		if matchAny && ok {
+			return captures, true
+		}
+		if !matchAny && !ok {
+			return nil, false
+		}
+	}
+	return captures, !matchAny
+}
+
+func expandRewriteURL(template string, vars map[string]string, ruleCaptures []string, condCaptures []string) string {
+	return rewriteVarPattern.ReplaceAllStringFunc(template, func(ref string) string {
+		m := rewriteVarPattern.FindStringSubmatch(ref)
+		if m[2] == "" {
+			return vars[strings.ToUpper(m[1])]
+		}
+		index, _ := strconv.Atoi(m[2])
+		var captures []string
+		switch m[1] {
+		case "R":
+			captures = ruleCaptures
+		case "C":
+			captures = condCaptures
+		default:
+			return ref
+		}
+		if index < len(captures) {
+			return captures[index]
+		}
+		return ""
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEvaluateRewriteRules(t *testing.T) {
+	preset := func(request RewritePresetRequest) RewriteRule {
+		rule, err := RewritePresetRule(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rule
+	}
+	rewrite := func(name string, pattern string, target string) RewriteRule {
+		return RewriteRule{Name: name, MatchURL: pattern, Action: RewriteAction{Type: "Rewrite", URL: target}}
+	}
+	redirect := func(name string, pattern string, target string) RewriteRule {
+		return RewriteRule{Name: name, MatchURL: pattern, Action: RewriteAction{Type: "Redirect", URL: target, RedirectType: "Found"}}
+	}
+	stop := func(rule RewriteRule) RewriteRule {
+		rule.StopProcessing = true
+		return rule
+	}
+	withConditions := func(rule RewriteRule, grouping string, conditions ...RewriteCondition) RewriteRule {
+		rule.LogicalGrouping = grouping
+		rule.Conditions = conditions
+		return rule
+	}
+	host := RewriteCondition{Input: "{HTTP_HOST}", Pattern: `^shop\.example\.com$`}
+	debug := RewriteCondition{Input: "{QUERY_STRING}", Pattern: `(^|&)debug=1(&|$)`}
+
+	tests := []struct {
+		name    string
+		rules   []RewriteRule
+		sample  string
+		action  string
+		url     string
+		status  int
+		matched []string
+	}{
+		{"no rules serve the path", nil, "http://shop.example.com/a/b?x=1", "Serve", "/a/b", 0, nil},
+		{"rewrite", []RewriteRule{rewrite("old", "^old/(.*)$", "new/{R:1}")}, "http://shop.example.com/old/page", "Serve", "/new/page", 0, []string{"old"}},
+		{"pattern not matched", []RewriteRule{redirect("admin", "^admin", "/login")}, "http://shop.example.com/shop", "Serve", "/shop", 0, nil},
+		{"bad pattern never matches", []RewriteRule{redirect("bad", "(", "/x")}, "http://shop.example.com/a", "Serve", "/a", 0, nil},
+		{
+			"rewrite feeds the next rule",
+			[]RewriteRule{rewrite("a", "^a$", "b"), redirect("b", "^b$", "/c")},
+			"http://shop.example.com/a", "Redirect", "/c", 302, []string{"a", "b"},
+		},
+		{
+			"stopProcessing ends the run",
+			[]RewriteRule{stop(rewrite("a", "^a$", "b")), redirect("b", "^b$", "/c")},
+			"http://shop.example.com/a", "Serve", "/b", 0, []string{"a"},
+		},
+		{
+			"without stopProcessing later rules still run",
+			[]RewriteRule{rewrite("a", "^a$", "a"), redirect("again", "^a$", "/c")},
+			"http://shop.example.com/a", "Redirect", "/c", 302, []string{"a", "again"},
+		},
+		{
+			"a terminal action stops regardless",
+			[]RewriteRule{redirect("first", ".*", "/one"), redirect("second", ".*", "/two")},
+			"http://shop.example.com/a", "Redirect", "/one", 302, []string{"first"},
+		},
+		{
+			"negated pattern matches other paths",
+			[]RewriteRule{{Name: "block", MatchURL: "^api/", Negate: true, Action: RewriteAction{Type: "CustomResponse", StatusCode: 403}}},
+			"http://shop.example.com/admin", "CustomResponse", "", 403, []string{"block"},
+		},
+		{
+			"negated pattern skips its own paths",
+			[]RewriteRule{{Name: "block", MatchURL: "^api/", Negate: true, Action: RewriteAction{Type: "CustomResponse", StatusCode: 403}}},
+			"http://shop.example.com/api/orders", "Serve", "/api/orders", 0, nil,
+		},
+		{
+			"ignoreCase",
+			[]RewriteRule{{Name: "abort", MatchURL: "^ADMIN", IgnoreCase: true, Action: RewriteAction{Type: "AbortRequest"}}},
+			"http://shop.example.com/admin", "AbortRequest", "", 0, []string{"abort"},
+		},
+		{
+			"case sensitive",
+			[]RewriteRule{{Name: "abort", MatchURL: "^ADMIN", Action: RewriteAction{Type: "AbortRequest"}}},
+			"http://shop.example.com/admin", "Serve", "/admin", 0, nil,
+		},
+		{
+			"all conditions met",
+			[]RewriteRule{withConditions(redirect("debug", ".*", "/debug"), "MatchAll", host, debug)},
+			"http://shop.example.com/a?debug=1", "Redirect", "/debug", 302, []string{"debug"},
+		},
+		{
+			"one condition of all missing",
+			[]RewriteRule{withConditions(redirect("debug", ".*", "/debug"), "MatchAll", host, debug)},
+			"http://shop.example.com/a", "Serve", "/a", 0, nil,
+		},
+		{
+			"any condition met",
+			[]RewriteRule{withConditions(redirect("debug", ".*", "/debug"), "MatchAny", debug, host)},
+			"http://shop.example.com/a", "Redirect", "/debug", 302, []string{"debug"},
+		},
+		{
+			"no condition of any met",
+			[]RewriteRule{withConditions(redirect("debug", ".*", "/debug"), "MatchAny", debug, host)},
+			"http://blog.example.com/a", "Serve", "/a", 0, nil,
+		},
+		{
+			"negated condition",
+			[]RewriteRule{withConditions(redirect("canonical", "(.*)", "http://shop.example.com/{R:1}"), "MatchAll",
+				RewriteCondition{Input: "{HTTP_HOST}", Pattern: `^shop\.example\.com$`, Negate: true})},
+			"http://old.example.com/a", "Redirect", "http://shop.example.com/a", 302, []string{"canonical"},
+		},
+		{
+			"https preset redirects with the query",
+			[]RewriteRule{preset(RewritePresetRequest{Preset: "https"})},
+			"http://shop.example.com/a?x=1", "Redirect", "https://shop.example.com/a?x=1", 301, []string{"Redirect to HTTPS"},
+		},
+		{
+			"https preset leaves https alone",
+			[]RewriteRule{preset(RewritePresetRequest{Preset: "https"})},
+			"https://shop.example.com/a", "Serve", "/a", 0, nil,
+		},
+		{
+			"condition captures",
+			[]RewriteRule{preset(RewritePresetRequest{Preset: "non-www", Scheme: "https"})},
+			"http://www.shop.example.com/a", "Redirect", "https://shop.example.com/a", 301, []string{"Redirect to non-www"},
+		},
+		{
+			"rewrite to another host proxies",
+			[]RewriteRule{preset(RewritePresetRequest{Preset: "reverse-proxy", Target: "http://backend:8080/"})},
+			"http://shop.example.com/api/orders?page=2", "Proxy", "http://backend:8080/api/orders?page=2", 0, []string{"Reverse proxy to backend:8080"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := EvaluateRewriteRules(test.rules, test.sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Action != test.action || result.URL != test.url || result.StatusCode != test.status {
+				t.Fatalf("expected %s %s %d, got %s %s %d", test.action, test.url, test.status, result.Action, result.URL, result.StatusCode)
+			}
+			matched := []string{}
+			for _, step := range result.Trace {
+				if step.Matched {
+					matched = append(matched, step.Rule)
+				}
+			}
+			if !slices.Equal(matched, append([]string{}, test.matched...)) {
+				t.Fatalf("expected %v to match, got %v", test.matched, matched)
+			}
+		})
+	}
+
+	if _, err := EvaluateRewriteRules(nil, "/relative"); err == nil {
+		t.Fatal("expected a relative sample to be refused")
+	}
+}
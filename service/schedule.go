@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// runEvery runs job in the background every interval minutes. interval is
+// asked again each minute, so settings changes apply without a restart; 0
+// pauses the job.
+func runEvery(name string, interval func() (int, error), job func() error) {
+	go func() {
+		last := time.Now()
+		for range time.Tick(time.Minute) {
+			minutes, err := interval()
+			if err != nil || minutes == 0 {
+				continue
+			}
+			if time.Since(last) < time.Duration(minutes)*time.Minute {
+				continue
+			}
+			last = time.Now()
+			if err := runJob(job); err != nil {
+				log.Printf("%s failed: %v", name, err)
+			}
+		}
+	}()
+}
+
+// runJob turns a panic in job into an error, so one bad run does not take
+// the whole service down with it.
+func runJob(job func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job()
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	slotsBucket    = "slots"
+	slotSwapBucket = "slot-swaps"
+	slotBlue       = "blue"
+	slotGreen      = "green"
+	swappedStatus  = "swapped"
+	swapManual     = "manual"
+	swapDeploy     = "deploy"
+
+	// mwaAssembly lets a script change several sites and commit them as one
+	// write of applicationHost.config, which WebAdministration cannot
+	mwaAssembly = `Add-Type -Path "$env:windir\System32\inetsrv\Microsoft.Web.Administration.dll"`
+)
+
+var (
+	errSlotsNotEnabled = errors.New("blue/green slots are not enabled for this website")
+	errSlotsEnabled    = errors.New("website already has blue/green slots")
+	errSlotUnhealthy   = errors.New("idle slot failed its health check")
+	errSlotsBlueActive = errors.New("swap back to the blue slot before disabling slots")
+)
+
+// privateBinding is the binding a slot keeps whether active or idle, which
+// health checks use before the slot goes live.
+func (s BlueGreenSlot) privateBinding() string {
+	return fmt.Sprintf("127.0.0.1:%d:", s.PrivatePort)
+}
+
+func (c BlueGreenConfig) slot(name string) BlueGreenSlot {
+	if name == slotGreen {
+		return c.Green
+	}
+	return c.Blue
+}
+
+func (c BlueGreenConfig) idle() BlueGreenSlot {
+	if c.Active == slotGreen {
+		return c.Blue
+	}
+	return c.Green
+}
+
+// Summary describes the slots from the point of view of one of the two
+// slot sites.
+func (c BlueGreenConfig) Summary(website Website) *SlotSummary {
+	summary := &SlotSummary{Slot: slotBlue, Active: c.Active, ActiveSite: c.slot(c.Active).Site, IdleSite: c.idle().Site, LastSwapAt: c.LastSwapAt}
+	if website.ID == c.Green.SiteID {
+		summary.Slot = slotGreen
+	}
+	return summary
+}
+
+func ValidateSlotsRequest(request SlotsRequest) error {
+	for _, port := range []int{request.BluePort, request.GreenPort} {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("bluePort and greenPort must be between 1 and 65535")
+		}
+	}
+	if request.BluePort == request.GreenPort {
+		return fmt.Errorf("bluePort and greenPort must differ")
+	}
+	if strings.ContainsAny(request.GreenSite, `\/:*?"<>|`) {
+		return fmt.Errorf("invalid greenSite %s", request.GreenSite)
+	}
+	return nil
+}
+
+// GetSlotsAction finds the slots a site belongs to, whether it is the blue
+// slot the slots were enabled on or the green one.
+func GetSlotsAction(website Website) (BlueGreenConfig, bool, error) {
+	configs, err := storeList[BlueGreenConfig](slotsBucket)
+	if err != nil {
+		return BlueGreenConfig{}, false, err
+	}
+	for _, config := range configs {
+		if config.Blue.SiteID == website.ID || config.Green.SiteID == website.ID {
+			return config, true, nil
+		}
+	}
+	return BlueGreenConfig{}, false, nil
+}
+
+// moveSlotRecords points slots at a slot site's new ID and name. Slots are
+// keyed by the blue site, so they are re-keyed when that one moves; a moved
+// green site only changes the record.
+func moveSlotRecords(oldID int, newID int, newName string) error {
+	configs, err := storeList[BlueGreenConfig](slotsBucket)
+	if err != nil {
+		return err
+	}
+	for _, config := range configs {
+		if config.Blue.SiteID != oldID && config.Green.SiteID != oldID {
+			continue
+		}
+		key := config.SiteID
+		if config.Blue.SiteID == oldID {
+			config.SiteID, config.Site = newID, newName
+			config.Blue.SiteID, config.Blue.Site = newID, newName
+		} else {
+			config.Green.SiteID, config.Green.Site = newID, newName
+		}
+		if err := storePut(slotsBucket, strconv.Itoa(config.SiteID), config); err != nil {
+			return err
+		}
+		if config.SiteID != key {
+			if err := storeDelete(slotsBucket, strconv.Itoa(key)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// attachSlots fills in Slots on both sites of every pair of slots.
+func attachSlots(websites []Website) ([]Website, error) {
+	configs, err := storeList[BlueGreenConfig](slotsBucket)
+	if err != nil {
+		return websites, err
+	}
+	for i := range websites {
+		for _, config := range configs {
+			if config.Blue.SiteID == websites[i].ID || config.Green.SiteID == websites[i].ID {
+				websites[i].Slots = config.Summary(websites[i])
+			}
+		}
+	}
+	return websites, nil
+}
+
+// checkPrivatePort refuses a port some other binding already listens on
+// for loopback requests.
+func checkPrivatePort(live Manifest, port int, own string) error {
+	for _, site := range live.Sites {
+		for _, binding := range site.Bindings {
+			if binding.Port != port || (binding.IP != "" && binding.IP != "127.0.0.1") {
+				continue
+			}
+			if strings.EqualFold(site.Name, own) && binding.IP == "127.0.0.1" && binding.Host == "" {
+				continue
+			}
+			return fmt.Errorf("port %d is already bound by %s", port, site.Name)
+		}
+	}
+	return nil
+}
+
+// EnableSlotsAction makes a site the blue slot of a blue/green pair. The
+// green slot is an existing site without public bindings, or a new site
+// with its own app pool and a copy of the blue slot's content. Each slot
+// gets a loopback-only binding on its private port; the public bindings
+// stay on the blue slot.
+func EnableSlotsAction(website Website, request SlotsRequest) (BlueGreenConfig, error) {
+	_, found, err := GetSlotsAction(website)
+	if err != nil {
+		return BlueGreenConfig{}, err
+	}
+	if found {
+		return BlueGreenConfig{}, errSlotsEnabled
+	}
+	if request.GreenSite == "" {
+		request.GreenSite = website.Name + "-green"
+	}
+	if strings.EqualFold(request.GreenSite, website.Name) {
+		return BlueGreenConfig{}, fmt.Errorf("greenSite must be another site")
+	}
+	if green, err := GetByNameAction(request.GreenSite); err == nil {
+		_, found, err := GetSlotsAction(green)
+		if err != nil {
+			return BlueGreenConfig{}, err
+		}
+		if found {
+			return BlueGreenConfig{}, fmt.Errorf("%w: %s is already a slot", errSlotsEnabled, green.Name)
+		}
+	}
+	if request.GreenPhysicalPath == "" {
+		// Next to the site's own directory, not its current release
+		target, err := getDeployTarget(website)
+		if err != nil {
+			return BlueGreenConfig{}, err
+		}
+		request.GreenPhysicalPath = strings.TrimRight(target.OriginalPath, `\/`) + "-green"
+	}
+	live, err := readLiveManifest(func(string) bool { return false })
+	if err != nil {
+		return BlueGreenConfig{}, err
+	}
+	if err := checkPrivatePort(live, request.BluePort, website.Name); err != nil {
+		return BlueGreenConfig{}, err
+	}
+	if err := checkPrivatePort(live, request.GreenPort, request.GreenSite); err != nil {
+		return BlueGreenConfig{}, err
+	}
+	config := BlueGreenConfig{
+		SiteID: website.ID,
+		Site:   website.Name,
+		Active: slotBlue,
+		Blue:   BlueGreenSlot{Name: slotBlue, Site: website.Name, SiteID: website.ID, PrivatePort: request.BluePort},
+		Green:  BlueGreenSlot{Name: slotGreen, Site: request.GreenSite, PrivatePort: request.GreenPort},
+	}
+	ps := fmt.Sprintf(`%s;
+		$manager = New-Object Microsoft.Web.Administration.ServerManager;
+		$blue = $manager.Sites[%s];
+		$bluePrivate = %s;
+		$greenPrivate = %s;
+		if (-not ($blue.Bindings | Where-Object { $_.BindingInformation -eq $bluePrivate })) { [void]$blue.Bindings.Add($bluePrivate, 'http') };
+		$green = $manager.Sites[%s];
+		if ($green) {
+			if ($green.Bindings | Where-Object { $_.Protocol -in 'http', 'https' -and $_.BindingInformation -ne $greenPrivate }) {
+				throw ('site ' + $green.Name + ' already has bindings; remove them or choose another greenSite')
+			};
+			if (-not ($green.Bindings | Where-Object { $_.BindingInformation -eq $greenPrivate })) { [void]$green.Bindings.Add($greenPrivate, 'http') }
+		} else {
+			$root = $blue.Applications['/'];
+			$dir = [Environment]::ExpandEnvironmentVariables(%s);
+			if (-not (Test-Path $dir)) {
+				Copy-Item -Path ([Environment]::ExpandEnvironmentVariables($root.VirtualDirectories['/'].PhysicalPath)) -Destination $dir -Recurse
+			};
+			$pool = $manager.ApplicationPools[%s];
+			if (-not $pool) {
+				$source = $manager.ApplicationPools[$root.ApplicationPoolName];
+				$pool = $manager.ApplicationPools.Add(%s);
+				$pool.ManagedRuntimeVersion = $source.ManagedRuntimeVersion;
+				$pool.ManagedPipelineMode = $source.ManagedPipelineMode
+			};
+			$green = $manager.Sites.Add(%s, 'http', $greenPrivate, %s);
+			$green.Applications['/'].ApplicationPoolName = $pool.Name
+		};
+		$manager.CommitChanges()`,
+		mwaAssembly, psQuote(website.Name), psQuote(config.Blue.privateBinding()), psQuote(config.Green.privateBinding()),
+		psQuote(request.GreenSite), psQuote(request.GreenPhysicalPath), psQuote(request.GreenSite), psQuote(request.GreenSite),
+		psQuote(request.GreenSite), psQuote(request.GreenPhysicalPath))
+	if _, err := runPowerShell(ps); err != nil {
+		return config, fmt.Errorf("failed to set up slots for %s: %v", website.Name, err)
+	}
+	green, err := GetByNameAction(request.GreenSite)
+	if err != nil {
+		return config, err
+	}
+	config.Green.SiteID = green.ID
+	return config, storePut(slotsBucket, strconv.Itoa(website.ID), config)
+}
+
+// DisableSlotsAction removes the private bindings and forgets the pair. The
+// green site is left in place.
+func DisableSlotsAction(website Website) error {
+	deployMu.Lock()
+	defer deployMu.Unlock()
+	config, found, err := GetSlotsAction(website)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errSlotsNotEnabled
+	}
+	if config.Active != slotBlue {
+		return errSlotsBlueActive
+	}
+	ps := fmt.Sprintf(`%s;
+		$manager = New-Object Microsoft.Web.Administration.ServerManager;
+		foreach ($slot in @(@{ site = %s; binding = %s }, @{ site = %s; binding = %s })) {
+			$site = $manager.Sites[$slot.site];
+			if (-not $site) { continue };
+			@($site.Bindings | Where-Object { $_.BindingInformation -eq $slot.binding }) | ForEach-Object { $site.Bindings.Remove($_) }
+		};
+		$manager.CommitChanges()`,
+		mwaAssembly, psQuote(config.Blue.Site), psQuote(config.Blue.privateBinding()), psQuote(config.Green.Site), psQuote(config.Green.privateBinding()))
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to remove slot bindings: %v", err)
+	}
+	return storeDelete(slotsBucket, strconv.Itoa(config.SiteID))
+}
+
+// ProbeSlot checks a slot on its private port, with the blue site's health
+// check path and expected status.
+func ProbeSlot(config BlueGreenConfig, slot BlueGreenSlot) (ProbeResult, error) {
+	health, err := GetHealthConfigAction(Website{ID: config.SiteID, Name: config.Site})
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	port := strconv.Itoa(slot.PrivatePort)
+	return Probe(ProbeTarget{URL: "http://localhost:" + port + health.Path, DialAddress: net.JoinHostPort("127.0.0.1", port)}, health), nil
+}
+
+// swapSlotBindings moves every http and https binding but the private one
+// from one slot site to the other, certificates included, and commits both
+// sites in one write, so no request finds the bindings on neither.
+func swapSlotBindings(from BlueGreenSlot, to BlueGreenSlot) error {
+	ps := fmt.Sprintf(`%s;
+		$manager = New-Object Microsoft.Web.Administration.ServerManager;
+		$from = $manager.Sites[%s];
+		$to = $manager.Sites[%s];
+		if (-not $from -or -not $to) { throw 'slot site not found' };
+		$private = %s;
+		$moving = @($from.Bindings | Where-Object { $_.Protocol -in 'http', 'https' -and $_.BindingInformation -ne $private });
+		if ($moving.Count -eq 0) { throw ('site ' + $from.Name + ' has no public bindings to move') };
+		foreach ($binding in $moving) {
+			$from.Bindings.Remove($binding);
+			if ($binding.Protocol -eq 'https') {
+				[void]$to.Bindings.Add($binding.BindingInformation, $binding.CertificateHash, $binding.CertificateStoreName, $binding.SslFlags)
+			} else {
+				[void]$to.Bindings.Add($binding.BindingInformation, $binding.Protocol)
+			}
+		};
+		$manager.CommitChanges();
+		if ($to.State -ne 'Started') { [void]$to.Start() }`,
+		mwaAssembly, psQuote(from.Site), psQuote(to.Site), psQuote(from.privateBinding()))
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to swap bindings from %s to %s: %v", from.Site, to.Site, err)
+	}
+	return nil
+}
+
+// SwapSlotsAction makes the idle slot the active one. The idle slot must
+// pass its health check first unless forced; a failed check or swap leaves
+// the public bindings where they were. Every attempt is recorded.
+func SwapSlotsAction(website Website, force bool, reason string, deploymentID string) (SlotSwap, error) {
+	deployMu.Lock()
+	defer deployMu.Unlock()
+	config, found, err := GetSlotsAction(website)
+	if err != nil {
+		return SlotSwap{}, err
+	}
+	if !found {
+		return SlotSwap{}, errSlotsNotEnabled
+	}
+	from, to := config.slot(config.Active), config.idle()
+	now := time.Now().UTC()
+	swap := SlotSwap{
+		ID:           now.Format(storeTimeFormat),
+		SiteID:       config.SiteID,
+		Site:         config.Site,
+		At:           now,
+		From:         from.Name,
+		To:           to.Name,
+		FromSite:     from.Site,
+		ToSite:       to.Site,
+		Reason:       reason,
+		DeploymentID: deploymentID,
+		Forced:       force,
+		Status:       deployFailed,
+	}
+	record := func(err error) (SlotSwap, error) {
+		if err != nil {
+			swap.Error = err.Error()
+		}
+		return swap, errors.Join(err, storePut(slotSwapBucket, siteKeyPrefix(config.SiteID)+swap.ID, swap))
+	}
+	if !force {
+		probe, err := ProbeSlot(config, to)
+		if err != nil {
+			return swap, err
+		}
+		swap.Probe = &probe
+		if !probe.OK {
+			return record(fmt.Errorf("%w: %s: %s", errSlotUnhealthy, probe.URL, probe.Error))
+		}
+	}
+	if err := swapSlotBindings(from, to); err != nil {
+		return record(err)
+	}
+	swap.Status = swappedStatus
+	config.Active, config.LastSwapAt = to.Name, &now
+	if err := storePut(slotsBucket, strconv.Itoa(config.SiteID), config); err != nil {
+		return record(err)
+	}
+	return record(nil)
+}
+
+// SlotDeployAction deploys an archive to the idle slot and, unless told
+// not to, swaps it in once it passes its health check.
+func SlotDeployAction(website Website, name string, file archiveFile, size int64, keepConfig bool, swap bool) (Deployment, *SlotSwap, error) {
+	config, found, err := GetSlotsAction(website)
+	if err != nil {
+		return Deployment{}, nil, err
+	}
+	if !found {
+		return Deployment{}, nil, errSlotsNotEnabled
+	}
+	idle, err := GetByNameAction(config.idle().Site)
+	if err != nil {
+		return Deployment{}, nil, err
+	}
+	deployment, err := DeployArchiveAction(idle, name, file, size, keepConfig)
+	if err != nil || !swap {
+		return deployment, nil, err
+	}
+	swapped, err := SwapSlotsAction(website, false, swapDeploy, deployment.ID)
+	return deployment, &swapped, err
+}
+
+// GetSlotsStatusAction returns the slots, the public bindings as IIS has
+// them now, and the swap history newest first.
+func GetSlotsStatusAction(website Website) (SlotsStatus, error) {
+	config, found, err := GetSlotsAction(website)
+	if err != nil {
+		return SlotsStatus{}, err
+	}
+	if !found {
+		return SlotsStatus{}, errSlotsNotEnabled
+	}
+	live, err := readLiveManifest(func(string) bool { return false })
+	if err != nil {
+		return SlotsStatus{}, err
+	}
+	active := config.slot(config.Active)
+	status := SlotsStatus{BlueGreenConfig: config, PublicBindings: []ManifestBinding{}}
+	if site, ok := liveManifestSite(live, active.Site); ok {
+		status.PublicBindings = slices.DeleteFunc(site.Bindings, func(b ManifestBinding) bool {
+			return b.Protocol == "http" && b.IP == "127.0.0.1" && b.Port == active.PrivatePort && b.Host == ""
+		})
+	}
+	status.History, err = storeListRange[SlotSwap](slotSwapBucket, siteKeyPrefix(config.SiteID), "", "")
+	slices.Reverse(status.History)
+	return status, err
+}
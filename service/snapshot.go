@@ -0,0 +1,446 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	snapshotBucket  = "snapshots"
+	settingsBucket  = "settings"
+	apphostFile     = "applicationHost.config"
+	snapshotLive    = "live"
+	triggerSchedule = "schedule"
+	triggerMutation = "mutation"
+	triggerManual   = "manual"
+	triggerRestore  = "restore"
+)
+
+var (
+	defaultSnapshotSettings = SnapshotSettings{IntervalMinutes: 60, Keep: 100, MaxAgeDays: 30}
+	// snapshotMu serializes snapshots and restores with each other
+	snapshotMu sync.Mutex
+)
+
+// snapshotCapture is the configuration as read from disk: applicationHost.config
+// and each site's root web.config, keyed by their path inside a snapshot.
+type snapshotCapture struct {
+	files map[string][]byte
+	sites []SnapshotSite
+}
+
+func applicationHostPath() string {
+	windir := os.Getenv("windir")
+	if windir == "" {
+		windir = `C:\Windows`
+	}
+	return filepath.Join(windir, "System32", "inetsrv", "config", apphostFile)
+}
+
+func siteConfigFile(id int) string {
+	return fmt.Sprintf("sites/%d/web.config", id)
+}
+
+func snapshotDir(id string) string {
+	return filepath.Join(DataDir(), "snapshots", id)
+}
+
+func captureConfiguration() (snapshotCapture, error) {
+	capture := snapshotCapture{files: map[string][]byte{}, sites: []SnapshotSite{}}
+	apphost, err := os.ReadFile(applicationHostPath())
+	if err != nil {
+		return capture, fmt.Errorf("failed to read %s: %v", apphostFile, err)
+	}
+	capture.files[apphostFile] = apphost
+	websites, err := ListWebsitesAction()
+	if err != nil {
+		return capture, err
+	}
+	for _, website := range websites {
+		site := SnapshotSite{ID: website.ID, Name: website.Name, PhysicalPath: website.PhysicalPath}
+		data, err := os.ReadFile(filepath.Join(expandPhysicalPath(website.PhysicalPath), "web.config"))
+		switch {
+		case err == nil:
+			site.HasConfig = true
+			capture.files[siteConfigFile(website.ID)] = data
+		case !errors.Is(err, fs.ErrNotExist):
+			return capture, fmt.Errorf("failed to read web.config of %s: %v", website.Name, err)
+		}
+		capture.sites = append(capture.sites, site)
+	}
+	return capture, nil
+}
+
+func (c snapshotCapture) hash() string {
+	keys := []string{}
+	for key := range c.files {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	h := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s\x00%d\x00", key, len(c.files[key]))
+		h.Write(c.files[key])
+	}
+	for _, site := range c.sites {
+		fmt.Fprintf(h, "%d\x00%s\x00%s\x00", site.ID, site.Name, site.PhysicalPath)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// displayName labels a snapshot file by site name rather than ID.
+func (c snapshotCapture) displayName(key string) string {
+	for _, site := range c.sites {
+		if key == siteConfigFile(site.ID) {
+			return site.Name + "/web.config"
+		}
+	}
+	return key
+}
+
+func GetSnapshotSettingsAction() (SnapshotSettings, error) {
+	settings := defaultSnapshotSettings
+	_, err := storeGet(settingsBucket, snapshotBucket, &settings)
+	return settings, err
+}
+
+func SetSnapshotSettingsAction(settings SnapshotSettings) error {
+	if err := ValidateSnapshotSettings(settings); err != nil {
+		return err
+	}
+	return storePut(settingsBucket, snapshotBucket, settings)
+}
+
+func ValidateSnapshotSettings(settings SnapshotSettings) error {
+	if settings.IntervalMinutes < 0 || settings.IntervalMinutes > 7*24*60 {
+		return fmt.Errorf("intervalMinutes must be between 0 (off) and %d", 7*24*60)
+	}
+	if settings.Keep < 1 || settings.Keep > 10000 {
+		return fmt.Errorf("keep must be between 1 and 10000")
+	}
+	if settings.MaxAgeDays < 0 || settings.MaxAgeDays > 3650 {
+		return fmt.Errorf("maxAgeDays must be between 0 (no limit) and 3650")
+	}
+	return nil
+}
+
+// GetSnapshotsAction lists snapshots, newest first.
+func GetSnapshotsAction() ([]Snapshot, error) {
+	snapshots, err := storeList[Snapshot](snapshotBucket)
+	slices.Reverse(snapshots)
+	return snapshots, err
+}
+
+func GetSnapshotAction(id string) (Snapshot, bool, error) {
+	snapshot := Snapshot{}
+	found, err := storeGet(snapshotBucket, id, &snapshot)
+	return snapshot, found, err
+}
+
+// TakeSnapshotAction records the current configuration. Scheduled and
+// pre-mutation snapshots are skipped when nothing changed since the latest
+// one, which is returned instead.
+func TakeSnapshotAction(trigger string, reason string) (Snapshot, error) {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	return takeSnapshot(trigger, reason)
+}
+
+func takeSnapshot(trigger string, reason string) (Snapshot, error) {
+	capture, err := captureConfiguration()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	hash := capture.hash()
+	if trigger == triggerSchedule || trigger == triggerMutation {
+		snapshots, err := GetSnapshotsAction()
+		if err != nil {
+			return Snapshot{}, err
+		}
+		if len(snapshots) > 0 && snapshots[0].Hash == hash {
+			return snapshots[0], nil
+		}
+	}
+	now := time.Now().UTC()
+	snapshot := Snapshot{
+		ID:        now.Format(storeTimeFormat),
+		CreatedAt: now,
+		Trigger:   trigger,
+		Reason:    reason,
+		Hash:      hash,
+		Sites:     capture.sites,
+	}
+	dir := snapshotDir(snapshot.ID)
+	for key, data := range capture.files {
+		file := filepath.Join(dir, filepath.FromSlash(key))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return Snapshot{}, err
+		}
+		if err := os.WriteFile(file, data, 0o600); err != nil {
+			return Snapshot{}, fmt.Errorf("failed to write snapshot: %v", err)
+		}
+		snapshot.Size += int64(len(data))
+	}
+	if err := storePut(snapshotBucket, snapshot.ID, snapshot); err != nil {
+		os.RemoveAll(dir)
+		return Snapshot{}, err
+	}
+	return snapshot, pruneSnapshots()
+}
+
+// pruneSnapshots applies retention: at most Keep snapshots, none older
+// than MaxAgeDays. The newest snapshot is always kept.
+func pruneSnapshots() error {
+	settings, err := GetSnapshotSettingsAction()
+	if err != nil {
+		return err
+	}
+	snapshots, err := GetSnapshotsAction()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().AddDate(0, 0, -settings.MaxAgeDays)
+	for i, snapshot := range snapshots {
+		expired := settings.MaxAgeDays > 0 && snapshot.CreatedAt.Before(cutoff)
+		if i == 0 || (i < settings.Keep && !expired) {
+			continue
+		}
+		if err := deleteSnapshot(snapshot.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func DeleteSnapshotAction(id string) error {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	return deleteSnapshot(id)
+}
+
+func deleteSnapshot(id string) error {
+	if id == "" || filepath.Base(id) != id || strings.Contains(id, "..") {
+		return fmt.Errorf("invalid snapshot id %s", id)
+	}
+	if err := os.RemoveAll(snapshotDir(id)); err != nil {
+		return fmt.Errorf("failed to delete snapshot %s: %v", id, err)
+	}
+	return storeDelete(snapshotBucket, id)
+}
+
+func loadSnapshot(snapshot Snapshot) (snapshotCapture, error) {
+	capture := snapshotCapture{files: map[string][]byte{}, sites: snapshot.Sites}
+	keys := []string{apphostFile}
+	for _, site := range snapshot.Sites {
+		if site.HasConfig {
+			keys = append(keys, siteConfigFile(site.ID))
+		}
+	}
+	for _, key := range keys {
+		data, err := os.ReadFile(filepath.Join(snapshotDir(snapshot.ID), filepath.FromSlash(key)))
+		if err != nil {
+			return capture, fmt.Errorf("snapshot %s is incomplete: %v", snapshot.ID, err)
+		}
+		capture.files[key] = data
+	}
+	return capture, nil
+}
+
+// captureByID loads a stored snapshot, or reads live configuration for "live".
+func captureByID(id string) (snapshotCapture, error) {
+	if id == snapshotLive {
+		return captureConfiguration()
+	}
+	snapshot, found, err := GetSnapshotAction(id)
+	if err != nil {
+		return snapshotCapture{}, err
+	}
+	if !found {
+		return snapshotCapture{}, fmt.Errorf("snapshot %s not found", id)
+	}
+	return loadSnapshot(snapshot)
+}
+
+// DiffSnapshotsAction compares two snapshots file by file. Either side may
+// be "live" for the current configuration.
+func DiffSnapshotsAction(from string, to string) (SnapshotDiff, error) {
+	before, err := captureByID(from)
+	if err != nil {
+		return SnapshotDiff{}, err
+	}
+	after, err := captureByID(to)
+	if err != nil {
+		return SnapshotDiff{}, err
+	}
+	return diffCaptures(from, to, before, after), nil
+}
+
+func diffCaptures(from string, to string, before snapshotCapture, after snapshotCapture) SnapshotDiff {
+	result := SnapshotDiff{From: from, To: to, Files: []FileDiff{}}
+	keys := []string{}
+	for key := range before.files {
+		keys = append(keys, key)
+	}
+	for key := range after.files {
+		if _, ok := before.files[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		old, inBefore := before.files[key]
+		current, inAfter := after.files[key]
+		name := after.displayName(key)
+		if !inAfter {
+			name = before.displayName(key)
+		}
+		file := FileDiff{Path: name, Status: "modified"}
+		switch {
+		case !inBefore:
+			file.Status = "added"
+		case !inAfter:
+			file.Status = "removed"
+		}
+		file.Diff = UnifiedDiff(from+"/"+before.displayName(key), to+"/"+name, old, current)
+		if file.Diff != "" || file.Status != "modified" {
+			result.Files = append(result.Files, file)
+		}
+	}
+	return result
+}
+
+// RestoreSnapshotAction puts configuration back as it was in a snapshot,
+// for the whole server or one site. A snapshot of the current state is
+// taken first and returned, so a restore can itself be undone.
+func RestoreSnapshotAction(id string, site string) (Snapshot, error) {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	snapshot, found, err := GetSnapshotAction(id)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if !found {
+		return Snapshot{}, fmt.Errorf("snapshot %s not found", id)
+	}
+	capture, err := loadSnapshot(snapshot)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	sites := snapshot.Sites
+	if site != "" {
+		i := slices.IndexFunc(sites, func(s SnapshotSite) bool { return strings.EqualFold(s.Name, site) })
+		if i < 0 {
+			return Snapshot{}, fmt.Errorf("site %s is not in snapshot %s", site, id)
+		}
+		sites = sites[i : i+1]
+	}
+	reason := "before restoring " + id
+	if site != "" {
+		reason += " for " + sites[0].Name
+	}
+	before, err := takeSnapshot(triggerRestore, reason)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	if site == "" {
+		err = writeFileAtomic(applicationHostPath(), capture.files[apphostFile])
+	} else {
+		err = restoreSiteElement(snapshot, sites[0].Name)
+	}
+	if err != nil {
+		return before, err
+	}
+	for _, s := range sites {
+		if err := restoreSiteConfig(capture, s); err != nil {
+			return before, err
+		}
+	}
+	return before, nil
+}
+
+// restoreSiteElement swaps one site's <site> element and its <location>
+// sections in applicationHost.config for those in the snapshot.
+func restoreSiteElement(snapshot Snapshot, site string) error {
+	ps := fmt.Sprintf(`$livePath = %s; $name = %s;
+		$snapshot = New-Object System.Xml.XmlDocument; $snapshot.PreserveWhitespace = $true; $snapshot.Load(%s);
+		$live = New-Object System.Xml.XmlDocument; $live.PreserveWhitespace = $true; $live.Load($livePath);
+		$sites = $live.SelectSingleNode('/configuration/system.applicationHost/sites');
+		$old = $sites.SelectNodes('site') | Where-Object { $_.name -eq $name };
+		$new = $snapshot.SelectNodes('/configuration/system.applicationHost/sites/site') | Where-Object { $_.name -eq $name } | Select-Object -First 1;
+		$imported = $live.ImportNode($new, $true);
+		if ($old) {
+			$sites.ReplaceChild($imported, @($old)[0]) | Out-Null
+		} else {
+			$anchor = $sites.SelectSingleNode('siteDefaults');
+			if ($anchor) { $sites.InsertBefore($imported, $anchor) | Out-Null } else { $sites.AppendChild($imported) | Out-Null }
+		};
+		$isSite = { param($path) $path = [string]$path; $path -eq $name -or $path.StartsWith($name + '/', [StringComparison]::OrdinalIgnoreCase) };
+		@($live.configuration.SelectNodes('location')) | Where-Object { & $isSite $_.path } | ForEach-Object { $live.DocumentElement.RemoveChild($_) | Out-Null };
+		@($snapshot.configuration.SelectNodes('location')) | Where-Object { & $isSite $_.path } | ForEach-Object { $live.DocumentElement.AppendChild($live.ImportNode($_, $true)) | Out-Null };
+		$temp = $livePath + '.restore';
+		$live.Save($temp);
+		Move-Item -Path $temp -Destination $livePath -Force`,
+		psQuote(applicationHostPath()), psQuote(site), psQuote(filepath.Join(snapshotDir(snapshot.ID), apphostFile)))
+	if _, err := runPowerShell(ps); err != nil {
+		return fmt.Errorf("failed to restore configuration of %s: %v", site, err)
+	}
+	return nil
+}
+
+// restoreSiteConfig writes back a site's web.config, or removes it when the
+// site had none at the time of the snapshot.
+func restoreSiteConfig(capture snapshotCapture, site SnapshotSite) error {
+	file := filepath.Join(expandPhysicalPath(site.PhysicalPath), "web.config")
+	if !site.HasConfig {
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove web.config of %s: %v", site.Name, err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(file, capture.files[siteConfigFile(site.ID)]); err != nil {
+		return fmt.Errorf("failed to restore web.config of %s: %v", site.Name, err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path through a temporary file in the same
+// directory, so readers such as IIS never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// StartSnapshotScheduler takes a snapshot every IntervalMinutes.
+func StartSnapshotScheduler() {
+	runEvery("scheduled snapshot", func() (int, error) {
+		settings, err := GetSnapshotSettingsAction()
+		return settings.IntervalMinutes, err
+	}, func() error {
+		_, err := TakeSnapshotAction(triggerSchedule, "")
+		return err
+	})
+}
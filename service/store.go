@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// store is the service's own embedded database. IIS remains the source of
+// truth for site configuration; the store only keeps what IIS has no place
+// for. Values are JSON encoded, one bucket per kind of record.
+var store *bbolt.DB
+
+// DataDir is where the service keeps its database and other state. It can
+// be moved with SERVICE_DATA_DIR.
+func DataDir() string {
+	if dir := os.Getenv("SERVICE_DATA_DIR"); dir != "" {
+		return dir
+	}
+	return "data"
+}
+
+func OpenStore() error {
+	if err := os.MkdirAll(DataDir(), 0o755); err != nil {
+		return err
+	}
+	db, err := bbolt.Open(filepath.Join(DataDir(), "service.db"), 0o600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	store = db
+	return nil
+}
+
+func storePut(bucket string, key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return store.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+// storeGet loads the record at key into value and reports whether it existed.
+func storeGet(bucket string, key string, value any) (bool, error) {
+	var data []byte
+	err := store.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		if v := b.Get([]byte(key)); v != nil {
+			data = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, value)
+}
+
+func storeDelete(bucket string, key string) error {
+	return store.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+// storeList returns every record in bucket in key order.
+func storeList[T any](bucket string) ([]T, error) {
+	items := []T{}
+	err := store.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, v []byte) error {
+			var item T
+			if err := json.Unmarshal(v, &item); err != nil {
+				return err
+			}
+			items = append(items, item)
+			return nil
+		})
+	})
+	return items, err
+}
+
+// storeTimeFormat renders times in keys so that key order is time order.
+const storeTimeFormat = "20060102T150405.000000Z"
+
+// siteKeyPrefix starts the keys of per-site series such as health history,
+// which hold one record per key under "<siteId>/<time>".
+func siteKeyPrefix(id int) string {
+	return strconv.Itoa(id) + "/"
+}
+
+// storeListRange returns the records keyed prefix+from up to, but not
+// including, prefix+to, in key order. An empty from or to leaves that end
+// open.
+func storeListRange[T any](bucket string, prefix string, from string, to string) ([]T, error) {
+	items := []T{}
+	err := store.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek([]byte(prefix + from)); k != nil && inRange(string(k), prefix, to); k, v = c.Next() {
+			var item T
+			if err := json.Unmarshal(v, &item); err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+		return nil
+	})
+	return items, err
+}
+
+// storeListLast returns up to limit records under prefix, last key first,
+// without reading the older ones.
+func storeListLast[T any](bucket string, prefix string, limit int) ([]T, error) {
+	items := []T{}
+	err := store.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		// Keys under prefix sort before prefix followed by 0xff
+		k, v := c.Seek(append([]byte(prefix), 0xff))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil && strings.HasPrefix(string(k), prefix) && len(items) < limit; k, v = c.Prev() {
+			var item T
+			if err := json.Unmarshal(v, &item); err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+		return nil
+	})
+	return items, err
+}
+
+// storeDeleteRange deletes the keys storeListRange would return.
+func storeDeleteRange(bucket string, prefix string, from string, to string) error {
+	return store.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, _ := c.Seek([]byte(prefix + from)); k != nil && inRange(string(k), prefix, to); {
+			key := append([]byte{}, k...)
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			// Seek again rather than Next, which skips a key after Delete
+			k, _ = c.Seek(key)
+		}
+		return nil
+	})
+}
+
+// storeMoveRange moves every record under oldPrefix to newPrefix, letting
+// update rewrite each record on the way.
+func storeMoveRange(bucket string, oldPrefix string, newPrefix string, update func(record map[string]any)) error {
+	return store.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		moved := map[string][]byte{}
+		c := b.Cursor()
+		for k, v := c.Seek([]byte(oldPrefix)); k != nil && strings.HasPrefix(string(k), oldPrefix); k, v = c.Next() {
+			record := map[string]any{}
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			update(record)
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			moved[newPrefix+strings.TrimPrefix(string(k), oldPrefix)] = data
+		}
+		for k := range moved {
+			if err := b.Delete([]byte(oldPrefix + strings.TrimPrefix(k, newPrefix))); err != nil {
+				return err
+			}
+		}
+		for k, data := range moved {
+			if err := b.Put([]byte(k), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func inRange(key string, prefix string, to string) bool {
+	return strings.HasPrefix(key, prefix) && (to == "" || key < prefix+to)
+}
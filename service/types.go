@@ -2,16 +2,23 @@ package main
 
 import (
 	"fmt"
+	"time"
 )
 
 type WebsiteAction string
 
 type Website struct {
-	Name         string  `json:"name"`
-	ID           int     `json:"id"`
-	State        string  `json:"state"`
-	PhysicalPath string  `json:"physicalPath"`
-	Binding      Binding `json:"bindings"`
+	Name           string                 `json:"name"`
+	ID             int                    `json:"id"`
+	State          string                 `json:"state"`
+	PhysicalPath   string                 `json:"physicalPath"`
+	Binding        Binding                `json:"bindings"`
+	Authentication *AuthenticationSummary `json:"authentication,omitempty"`
+	Limits         *SiteLimits            `json:"limits,omitempty"`
+	Metadata       *SiteMetadata          `json:"metadata,omitempty"`
+	Health         *HealthSummary         `json:"health,omitempty"`
+	Maintenance    *MaintenanceStatus     `json:"maintenance,omitempty"`
+	Slots          *SlotSummary           `json:"slots,omitempty"`
 }
 
 type Binding struct {
@@ -46,12 +53,884 @@ type MachineState struct {
 
 type DirFile struct {
 	Name       string `json:"name"`
+	Path       string `json:"path"`
 	Size       int64  `json:"size"`
 	IsDir      bool   `json:"isDir"`
+	IsLink     bool   `json:"isLink,omitempty"`
 	ModTime    string `json:"modTime"`
 	Permission string `json:"permission"`
 }
 
+type RewriteRule struct {
+	Name            string             `json:"name"`
+	StopProcessing  bool               `json:"stopProcessing"`
+	MatchURL        string             `json:"matchUrl"`
+	IgnoreCase      bool               `json:"ignoreCase"`
+	Negate          bool               `json:"negate"`
+	LogicalGrouping string             `json:"logicalGrouping"`
+	Conditions      []RewriteCondition `json:"conditions"`
+	Action          RewriteAction      `json:"action"`
+}
+
+type RewriteCondition struct {
+	Input      string `json:"input"`
+	Pattern    string `json:"pattern"`
+	IgnoreCase bool   `json:"ignoreCase"`
+	Negate     bool   `json:"negate"`
+}
+
+type RewriteAction struct {
+	Type              string `json:"type"`
+	URL               string `json:"url"`
+	RedirectType      string `json:"redirectType"`
+	AppendQueryString bool   `json:"appendQueryString"`
+	StatusCode        int    `json:"statusCode"`
+	StatusReason      string `json:"statusReason"`
+}
+
+type RewritePresetRequest struct {
+	Preset string `json:"preset"`
+	Host   string `json:"host"`
+	Target string `json:"target"`
+	Scheme string `json:"scheme"`
+}
+
+type RewriteOrderRequest struct {
+	Names []string `json:"names"`
+}
+
+type RewriteTestRequest struct {
+	URL   string        `json:"url"`
+	Rules []RewriteRule `json:"rules"`
+}
+
+type RewriteTestResult struct {
+	Input      string             `json:"input"`
+	Action     string             `json:"action"`
+	URL        string             `json:"url"`
+	StatusCode int                `json:"statusCode,omitempty"`
+	Trace      []RewriteTraceStep `json:"trace"`
+}
+
+type RewriteTraceStep struct {
+	Rule    string `json:"rule"`
+	Input   string `json:"input"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason,omitempty"`
+	Output  string `json:"output,omitempty"`
+}
+
+type IPSecurityPolicy struct {
+	Scope            string            `json:"scope"`
+	AllowUnlisted    bool              `json:"allowUnlisted"`
+	DenyAction       string            `json:"denyAction"`
+	EnableProxyMode  bool              `json:"enableProxyMode"`
+	EnableReverseDNS bool              `json:"enableReverseDns"`
+	Rules            []IPSecurityRule  `json:"rules"`
+	Dynamic          DynamicIPSecurity `json:"dynamic"`
+}
+
+type IPSecurityRule struct {
+	CIDR      string `json:"cidr,omitempty"`
+	Domain    string `json:"domain,omitempty"`
+	Allowed   bool   `json:"allowed"`
+	Inherited bool   `json:"inherited"`
+}
+
+type DynamicIPSecurity struct {
+	DenyAction            string `json:"denyAction"`
+	LoggingOnly           bool   `json:"loggingOnly"`
+	ConcurrentEnabled     bool   `json:"concurrentEnabled"`
+	MaxConcurrentRequests int    `json:"maxConcurrentRequests"`
+	RateEnabled           bool   `json:"rateEnabled"`
+	MaxRequests           int    `json:"maxRequests"`
+	RequestIntervalMs     int    `json:"requestIntervalMs"`
+}
+
+type IPSecurityDecision struct {
+	IP          string            `json:"ip"`
+	Scope       string            `json:"scope"`
+	Allowed     bool              `json:"allowed"`
+	DenyAction  string            `json:"denyAction,omitempty"`
+	Reason      string            `json:"reason"`
+	MatchedRule *IPSecurityRule   `json:"matchedRule"`
+	Dynamic     DynamicIPSecurity `json:"dynamic"`
+}
+
+type AuthenticationSettings struct {
+	Path      string                  `json:"path"`
+	Anonymous AnonymousAuthentication `json:"anonymous"`
+	Basic     BasicAuthentication     `json:"basic"`
+	Windows   WindowsAuthentication   `json:"windows"`
+	Digest    DigestAuthentication    `json:"digest"`
+}
+
+type AnonymousAuthentication struct {
+	Enabled  bool   `json:"enabled"`
+	Identity string `json:"identity"`
+	UserName string `json:"userName,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+type BasicAuthentication struct {
+	Enabled            bool   `json:"enabled"`
+	DefaultLogonDomain string `json:"defaultLogonDomain"`
+	Realm              string `json:"realm"`
+}
+
+type WindowsAuthentication struct {
+	Enabled       bool     `json:"enabled"`
+	Providers     []string `json:"providers"`
+	UseKernelMode bool     `json:"useKernelMode"`
+}
+
+type DigestAuthentication struct {
+	Enabled bool   `json:"enabled"`
+	Realm   string `json:"realm"`
+}
+
+type AuthenticationSummary struct {
+	Methods           []string `json:"methods"`
+	AnonymousIdentity string   `json:"anonymousIdentity"`
+}
+
+type CustomHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type SiteHeaders struct {
+	Headers            []CustomHeader    `json:"headers"`
+	RemoveXPoweredBy   bool              `json:"removeXPoweredBy"`
+	RemoveServerHeader bool              `json:"removeServerHeader"`
+	Profile            string            `json:"profile,omitempty"`
+	Drift              []HeaderDriftItem `json:"drift,omitempty"`
+}
+
+type SecurityHeaderProfile struct {
+	Name                    string         `json:"name"`
+	StrictTransportSecurity string         `json:"strictTransportSecurity"`
+	ContentSecurityPolicy   string         `json:"contentSecurityPolicy"`
+	XFrameOptions           string         `json:"xFrameOptions"`
+	ReferrerPolicy          string         `json:"referrerPolicy"`
+	PermissionsPolicy       string         `json:"permissionsPolicy"`
+	Headers                 []CustomHeader `json:"headers"`
+	RemoveXPoweredBy        bool           `json:"removeXPoweredBy"`
+	RemoveServerHeader      bool           `json:"removeServerHeader"`
+}
+
+type HeaderDriftItem struct {
+	Header   string `json:"header"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+type HeaderDriftReport struct {
+	Site    string            `json:"site"`
+	Profile string            `json:"profile"`
+	InSync  bool              `json:"inSync"`
+	Drift   []HeaderDriftItem `json:"drift"`
+	Error   string            `json:"error,omitempty"`
+}
+
+type SitesRequest struct {
+	Sites []string `json:"sites"`
+}
+
+type SiteResult struct {
+	Site    string `json:"site"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+type HTTPErrorsConfig struct {
+	ErrorMode           string      `json:"errorMode"`
+	ExistingResponse    string      `json:"existingResponse"`
+	DefaultResponseMode string      `json:"defaultResponseMode"`
+	Pages               []ErrorPage `json:"pages"`
+	Warnings            []string    `json:"warnings,omitempty"`
+}
+
+type ErrorPage struct {
+	StatusCode             int    `json:"statusCode"`
+	SubStatusCode          *int   `json:"subStatusCode"`
+	Path                   string `json:"path"`
+	ResponseMode           string `json:"responseMode"`
+	PrefixLanguageFilePath string `json:"prefixLanguageFilePath,omitempty"`
+	Content                string `json:"content,omitempty"`
+}
+
+type SiteLimits struct {
+	ConnectionTimeoutSeconds int    `json:"connectionTimeoutSeconds"`
+	MaxConnections           uint32 `json:"maxConnections"`
+	MaxBandwidth             uint32 `json:"maxBandwidth"`
+	MaxURLSegments           uint32 `json:"maxUrlSegments"`
+	MaxAllowedContentLength  uint32 `json:"maxAllowedContentLength"`
+	MaxURL                   uint32 `json:"maxUrl"`
+	MaxQueryString           uint32 `json:"maxQueryString"`
+}
+
+type BulkRequest struct {
+	Sites       []string `json:"sites"`
+	Selector    string   `json:"selector"`
+	Action      string   `json:"action"`
+	AppPool     string   `json:"appPool"`
+	Parallelism int      `json:"parallelism"`
+}
+
+type BulkResponse struct {
+	Action    string       `json:"action"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []SiteResult `json:"results"`
+}
+
+type SiteMetadata struct {
+	SiteID     int               `json:"siteId"`
+	Site       string            `json:"site"`
+	Labels     map[string]string `json:"labels"`
+	Owner      string            `json:"owner"`
+	Notes      string            `json:"notes"`
+	Repository string            `json:"repository"`
+	UpdatedAt  time.Time         `json:"updatedAt"`
+}
+
+type WebsiteQuery struct {
+	Q        string `form:"q"`
+	State    string `form:"state"`
+	Protocol string `form:"protocol"`
+	Port     int    `form:"port"`
+	Selector string `form:"selector"`
+	Owner    string `form:"owner"`
+	Sort     string `form:"sort"`
+	Order    string `form:"order"`
+	Page     int    `form:"page"`
+	PageSize int    `form:"pageSize"`
+}
+
+type WebsitePage struct {
+	Items      []Website `json:"items"`
+	Total      int       `json:"total"`
+	Page       int       `json:"page"`
+	PageSize   int       `json:"pageSize"`
+	TotalPages int       `json:"totalPages"`
+}
+
+type Manifest struct {
+	AppPools []ManifestAppPool `json:"appPools"`
+	Sites    []ManifestSite    `json:"sites"`
+}
+
+type ManifestAppPool struct {
+	Name           string  `json:"name"`
+	RuntimeVersion *string `json:"runtimeVersion,omitempty"`
+	PipelineMode   string  `json:"pipelineMode,omitempty"`
+}
+
+type ManifestSite struct {
+	Name               string                     `json:"name"`
+	PhysicalPath       string                     `json:"physicalPath,omitempty"`
+	AppPool            string                     `json:"appPool,omitempty"`
+	State              string                     `json:"state,omitempty"`
+	Bindings           []ManifestBinding          `json:"bindings"`
+	VirtualDirectories []ManifestVirtualDirectory `json:"virtualDirectories,omitempty"`
+	Limits             *SiteLimits                `json:"limits,omitempty"`
+}
+
+type ManifestBinding struct {
+	Protocol string `json:"protocol"`
+	IP       string `json:"ip,omitempty"`
+	Port     int    `json:"port"`
+	Host     string `json:"host,omitempty"`
+}
+
+type ManifestVirtualDirectory struct {
+	Path         string `json:"path"`
+	PhysicalPath string `json:"physicalPath"`
+}
+
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// PlanAction is one step of a plan. Status and Error are only set by apply.
+type PlanAction struct {
+	Op      string        `json:"op"`
+	Kind    string        `json:"kind"`
+	Name    string        `json:"name"`
+	Site    string        `json:"site,omitempty"`
+	Changes []FieldChange `json:"changes,omitempty"`
+	Status  string        `json:"status,omitempty"`
+	Error   string        `json:"error,omitempty"`
+
+	AppPool          *ManifestAppPool          `json:"-"`
+	Desired          *ManifestSite             `json:"-"`
+	VirtualDirectory *ManifestVirtualDirectory `json:"-"`
+}
+
+type PlanSummary struct {
+	Create int `json:"create"`
+	Update int `json:"update"`
+	Delete int `json:"delete"`
+}
+
+type Plan struct {
+	Prune   bool         `json:"prune"`
+	Summary PlanSummary  `json:"summary"`
+	Actions []PlanAction `json:"actions"`
+}
+
+type BundleInfo struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Source    string    `json:"source"`
+	Content   bool      `json:"content"`
+	Manifest  Manifest  `json:"manifest"`
+}
+
+type ExportOptions struct {
+	Sites   []string `form:"site"`
+	Content bool     `form:"content"`
+}
+
+type ImportOptions struct {
+	Names    map[string]string `json:"names"`
+	Paths    map[string]string `json:"paths"`
+	Sites    []string          `json:"sites"`
+	Conflict string            `json:"conflict"`
+	DryRun   bool              `json:"dryRun"`
+}
+
+type ImportResult struct {
+	Version   int       `json:"version"`
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"createdAt"`
+	DryRun    bool      `json:"dryRun"`
+	Skipped   []string  `json:"skipped"`
+	Files     int       `json:"files"`
+	Plan      Plan      `json:"plan"`
+}
+
+type SnapshotSettings struct {
+	IntervalMinutes int `json:"intervalMinutes"`
+	Keep            int `json:"keep"`
+	MaxAgeDays      int `json:"maxAgeDays"`
+}
+
+type SnapshotSite struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	PhysicalPath string `json:"physicalPath"`
+	HasConfig    bool   `json:"hasConfig"`
+}
+
+type Snapshot struct {
+	ID        string         `json:"id"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Trigger   string         `json:"trigger"`
+	Reason    string         `json:"reason,omitempty"`
+	Hash      string         `json:"hash"`
+	Size      int64          `json:"size"`
+	Sites     []SnapshotSite `json:"sites"`
+}
+
+type SnapshotRequest struct {
+	Reason string `json:"reason"`
+}
+
+type SnapshotRestoreRequest struct {
+	Site string `json:"site"`
+}
+
+type FileDiff struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Diff   string `json:"diff"`
+}
+
+type SnapshotDiff struct {
+	From  string     `json:"from"`
+	To    string     `json:"to"`
+	Files []FileDiff `json:"files"`
+}
+
+type DriftSettings struct {
+	IntervalMinutes int `json:"intervalMinutes"`
+}
+
+type SiteBaseline struct {
+	SiteID         int                    `json:"siteId"`
+	Site           string                 `json:"site"`
+	RecordedAt     time.Time              `json:"recordedAt"`
+	Bindings       []ManifestBinding      `json:"bindings"`
+	AppPool        string                 `json:"appPool"`
+	Authentication AuthenticationSettings `json:"authentication"`
+	Headers        SiteHeaders            `json:"headers"`
+}
+
+type SiteDrift struct {
+	SiteID     int           `json:"siteId"`
+	Site       string        `json:"site"`
+	BaselineAt time.Time     `json:"baselineAt"`
+	Missing    bool          `json:"missing,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Changes    []FieldChange `json:"changes"`
+}
+
+type DriftReport struct {
+	CheckedAt time.Time   `json:"checkedAt"`
+	Checked   int         `json:"checked"`
+	Sites     []SiteDrift `json:"sites"`
+}
+
+type HealthSettings struct {
+	IntervalMinutes int `json:"intervalMinutes"`
+	HistoryDays     int `json:"historyDays"`
+}
+
+type HealthCheckConfig struct {
+	SiteID         int    `json:"siteId"`
+	Site           string `json:"site"`
+	Disabled       bool   `json:"disabled"`
+	Path           string `json:"path"`
+	ExpectedStatus []int  `json:"expectedStatus"`
+	ExpectedBody   string `json:"expectedBody,omitempty"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+}
+
+// ProbeTarget is one URL to probe. DialAddress, when set, is connected to
+// instead of whatever the URL's host resolves to.
+type ProbeTarget struct {
+	URL         string `json:"url"`
+	DialAddress string `json:"dialAddress,omitempty"`
+	AnyHost     bool   `json:"anyHost,omitempty"`
+}
+
+type TLSStatus struct {
+	Valid    bool      `json:"valid"`
+	Subject  string    `json:"subject,omitempty"`
+	Issuer   string    `json:"issuer,omitempty"`
+	NotAfter time.Time `json:"notAfter,omitempty"`
+	DaysLeft int       `json:"daysLeft"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type ProbeResult struct {
+	URL        string     `json:"url"`
+	OK         bool       `json:"ok"`
+	StatusCode int        `json:"statusCode,omitempty"`
+	LatencyMs  int64      `json:"latencyMs"`
+	TLS        *TLSStatus `json:"tls,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+type HealthResult struct {
+	SiteID    int           `json:"siteId"`
+	Site      string        `json:"site"`
+	CheckedAt time.Time     `json:"checkedAt"`
+	Status    string        `json:"status"`
+	Message   string        `json:"message,omitempty"`
+	Probes    []ProbeResult `json:"probes"`
+}
+
+type HealthSummary struct {
+	Status    string    `json:"status"`
+	CheckedAt time.Time `json:"checkedAt"`
+	LatencyMs int64     `json:"latencyMs"`
+	Message   string    `json:"message,omitempty"`
+}
+
+type HealthHistoryQuery struct {
+	From  time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To    time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit int       `form:"limit"`
+}
+
+type RecoveryPolicy struct {
+	SiteID             int      `json:"siteId"`
+	Site               string   `json:"site"`
+	Enabled            bool     `json:"enabled"`
+	FailureThreshold   int      `json:"failureThreshold"`
+	Steps              []string `json:"steps"`
+	BackoffSeconds     int      `json:"backoffSeconds"`
+	MaxBackoffSeconds  int      `json:"maxBackoffSeconds"`
+	MaxRestartsPerHour int      `json:"maxRestartsPerHour"`
+	AlertWebhook       string   `json:"alertWebhook,omitempty"`
+}
+
+type RecoveryState struct {
+	SiteID              int         `json:"siteId"`
+	Site                string      `json:"site"`
+	ConsecutiveFailures int         `json:"consecutiveFailures"`
+	Attempt             int         `json:"attempt"`
+	NextAttemptAt       time.Time   `json:"nextAttemptAt,omitempty"`
+	RestartTimes        []time.Time `json:"restartTimes,omitempty"`
+}
+
+type RecoveryEvent struct {
+	SiteID              int       `json:"siteId"`
+	Site                string    `json:"site"`
+	At                  time.Time `json:"at"`
+	Action              string    `json:"action"`
+	Success             bool      `json:"success"`
+	Detail              string    `json:"detail,omitempty"`
+	Error               string    `json:"error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Attempt             int       `json:"attempt"`
+}
+
+type RecoveryStatus struct {
+	Policy   RecoveryPolicy  `json:"policy"`
+	State    RecoveryState   `json:"state"`
+	Flapping bool            `json:"flapping"`
+	Events   []RecoveryEvent `json:"events"`
+}
+
+type RecoveryEventsQuery struct {
+	Limit int `form:"limit"`
+}
+
+type UptimeQuery struct {
+	From   time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To     time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Format string    `form:"format"`
+	Report string    `form:"report"`
+	Target float64   `form:"target"`
+}
+
+type UptimeIncident struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds int64     `json:"durationSeconds"`
+	Ongoing         bool      `json:"ongoing"`
+	Reason          string    `json:"reason,omitempty"`
+}
+
+type MonthlyUptime struct {
+	Month               string   `json:"month"`
+	AvailabilityPercent *float64 `json:"availabilityPercent"`
+	UpSeconds           int64    `json:"upSeconds"`
+	DownSeconds         int64    `json:"downSeconds"`
+	NoDataSeconds       int64    `json:"noDataSeconds"`
+	Incidents           int      `json:"incidents"`
+	SLAMet              *bool    `json:"slaMet,omitempty"`
+}
+
+type UptimeReport struct {
+	Site                string           `json:"site"`
+	From                time.Time        `json:"from"`
+	To                  time.Time        `json:"to"`
+	AvailabilityPercent *float64         `json:"availabilityPercent"`
+	UpSeconds           int64            `json:"upSeconds"`
+	DownSeconds         int64            `json:"downSeconds"`
+	NoDataSeconds       int64            `json:"noDataSeconds"`
+	MTTRSeconds         *int64           `json:"mttrSeconds"`
+	Target              float64          `json:"target,omitempty"`
+	Incidents           []UptimeIncident `json:"incidents"`
+	Months              []MonthlyUptime  `json:"months"`
+}
+
+// MaintenanceRequest takes a site offline. Page, when set, is an HTML
+// template with {{.Site}}, {{.Message}} and {{.EndsAt}}.
+type MaintenanceRequest struct {
+	Mode              string     `json:"mode"`
+	Message           string     `json:"message"`
+	EndsAt            *time.Time `json:"endsAt"`
+	RetryAfterSeconds int        `json:"retryAfterSeconds"`
+	Page              string     `json:"page"`
+}
+
+type MaintenanceStatus struct {
+	SiteID            int        `json:"siteId"`
+	Site              string     `json:"site"`
+	Active            bool       `json:"active"`
+	Mode              string     `json:"mode,omitempty"`
+	Message           string     `json:"message,omitempty"`
+	StartedAt         time.Time  `json:"startedAt,omitempty"`
+	EndsAt            *time.Time `json:"endsAt,omitempty"`
+	RetryAfterSeconds int        `json:"retryAfterSeconds,omitempty"`
+}
+
+type DeploySettings struct {
+	MaxUploadMB    int `json:"maxUploadMB"`
+	MaxExtractedMB int `json:"maxExtractedMB"`
+	MaxFiles       int `json:"maxFiles"`
+	KeepReleases   int `json:"keepReleases"`
+}
+
+type DeployStep struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	DurationMs int64  `json:"durationMs"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+type Deployment struct {
+	ID           string       `json:"id"`
+	SiteID       int          `json:"siteId"`
+	Site         string       `json:"site"`
+	CreatedAt    time.Time    `json:"createdAt"`
+	Kind         string       `json:"kind"`
+	Status       string       `json:"status"`
+	Error        string       `json:"error,omitempty"`
+	ReleaseID    string       `json:"releaseId,omitempty"`
+	ArchiveName  string       `json:"archiveName"`
+	ArchiveBytes int64        `json:"archiveBytes"`
+	Format       string       `json:"format"`
+	SHA256       string       `json:"sha256"`
+	Files        int          `json:"files"`
+	Bytes        int64        `json:"bytes"`
+	KeptConfig   bool         `json:"keptConfig"`
+	Git          *GitRevision `json:"git,omitempty"`
+	Hooks        []HookRun    `json:"hooks,omitempty"`
+	Path         string       `json:"path,omitempty"`
+	PreviousPath string       `json:"previousPath"`
+	Steps        []DeployStep `json:"steps"`
+}
+
+// GitSource links a site to a branch of a repository. DeployKey is only
+// ever written; reads report HasDeployKey instead.
+type GitSource struct {
+	SiteID       int     `json:"siteId"`
+	Site         string  `json:"site"`
+	Repository   string  `json:"repository"`
+	Branch       string  `json:"branch"`
+	Subdirectory string  `json:"subdirectory"`
+	DeployKey    *string `json:"deployKey,omitempty"`
+	HasDeployKey bool    `json:"hasDeployKey"`
+}
+
+type GitRevision struct {
+	Repository   string `json:"repository"`
+	Branch       string `json:"branch"`
+	Subdirectory string `json:"subdirectory,omitempty"`
+	Commit       string `json:"commit"`
+	Author       string `json:"author,omitempty"`
+	Message      string `json:"message,omitempty"`
+}
+
+type GitDeployRequest struct {
+	Ref string `json:"ref"`
+}
+
+// Release is a deployment whose content is still on disk.
+type Release struct {
+	Deployment
+	Active bool `json:"active"`
+}
+
+// WebhookConfig lets CI trigger a site's deployments. Secret is only ever
+// written; reads report HasSecret instead.
+type WebhookConfig struct {
+	SiteID    int     `json:"siteId"`
+	Site      string  `json:"site"`
+	Enabled   bool    `json:"enabled"`
+	Format    string  `json:"format"`
+	Secret    *string `json:"secret,omitempty"`
+	HasSecret bool    `json:"hasSecret"`
+}
+
+type WebhookDelivery struct {
+	ID           string    `json:"id"`
+	SiteID       int       `json:"siteId"`
+	Site         string    `json:"site"`
+	ReceivedAt   time.Time `json:"receivedAt"`
+	DeliveryID   string    `json:"deliveryId"`
+	ReplayKey    string    `json:"replayKey,omitempty"`
+	Format       string    `json:"format"`
+	Event        string    `json:"event,omitempty"`
+	Status       string    `json:"status"`
+	Detail       string    `json:"detail,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	DeploymentID string    `json:"deploymentId,omitempty"`
+	RemoteAddr   string    `json:"remoteAddr"`
+}
+
+// WebhookPayload is the body of a generic delivery: an artifact to deploy,
+// or a ref of the linked repository.
+type WebhookPayload struct {
+	ArtifactURL string `json:"artifactUrl"`
+	SHA256      string `json:"sha256"`
+	Ref         string `json:"ref"`
+	KeepConfig  *bool  `json:"keepConfig"`
+}
+
+// GitHubPushEvent is the part of a GitHub push delivery deployments use.
+type GitHubPushEvent struct {
+	Ref     string `json:"ref"`
+	After   string `json:"after"`
+	Deleted bool   `json:"deleted"`
+}
+
+type WebhookDeliveriesQuery struct {
+	Limit int `form:"limit"`
+}
+
+// DeployHook is a script or executable run at one stage of a site's
+// deployments, in the order the site lists them.
+type DeployHook struct {
+	Name           string   `json:"name"`
+	Stage          string   `json:"stage"`
+	Script         string   `json:"script,omitempty"`
+	Command        string   `json:"command,omitempty"`
+	Args           []string `json:"args,omitempty"`
+	TimeoutSeconds int      `json:"timeoutSeconds"`
+}
+
+type DeployHooks struct {
+	SiteID int          `json:"siteId"`
+	Site   string       `json:"site"`
+	Hooks  []DeployHook `json:"hooks"`
+}
+
+type HookRun struct {
+	Name       string `json:"name"`
+	Stage      string `json:"stage"`
+	Status     string `json:"status"`
+	ExitCode   int    `json:"exitCode"`
+	DurationMs int64  `json:"durationMs"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	Error      string `json:"error,omitempty"`
+}
+
+type BlueGreenSlot struct {
+	Name        string `json:"name"`
+	Site        string `json:"site"`
+	SiteID      int    `json:"siteId"`
+	PrivatePort int    `json:"privatePort"`
+}
+
+// BlueGreenConfig pairs a site, the blue slot, with a green one. The active
+// slot holds the public bindings; both keep a private one.
+type BlueGreenConfig struct {
+	SiteID     int           `json:"siteId"`
+	Site       string        `json:"site"`
+	Active     string        `json:"active"`
+	Blue       BlueGreenSlot `json:"blue"`
+	Green      BlueGreenSlot `json:"green"`
+	LastSwapAt *time.Time    `json:"lastSwapAt,omitempty"`
+}
+
+type SlotsRequest struct {
+	GreenSite         string `json:"greenSite"`
+	GreenPhysicalPath string `json:"greenPhysicalPath"`
+	BluePort          int    `json:"bluePort"`
+	GreenPort         int    `json:"greenPort"`
+}
+
+type SlotSwapRequest struct {
+	Force bool `json:"force"`
+}
+
+type SlotSwap struct {
+	ID           string       `json:"id"`
+	SiteID       int          `json:"siteId"`
+	Site         string       `json:"site"`
+	At           time.Time    `json:"at"`
+	From         string       `json:"from"`
+	To           string       `json:"to"`
+	FromSite     string       `json:"fromSite"`
+	ToSite       string       `json:"toSite"`
+	Reason       string       `json:"reason"`
+	DeploymentID string       `json:"deploymentId,omitempty"`
+	Forced       bool         `json:"forced"`
+	Probe        *ProbeResult `json:"probe,omitempty"`
+	Status       string       `json:"status"`
+	Error        string       `json:"error,omitempty"`
+}
+
+type SlotsStatus struct {
+	BlueGreenConfig
+	PublicBindings []ManifestBinding `json:"publicBindings"`
+	History        []SlotSwap        `json:"history"`
+}
+
+// SlotSummary is what the site API shows of a slot site's pair.
+type SlotSummary struct {
+	Slot       string     `json:"slot"`
+	Active     string     `json:"active"`
+	ActiveSite string     `json:"activeSite"`
+	IdleSite   string     `json:"idleSite"`
+	LastSwapAt *time.Time `json:"lastSwapAt,omitempty"`
+}
+
+type FilesQuery struct {
+	Path      string `form:"path"`
+	Recursive bool   `form:"recursive"`
+	Overwrite bool   `form:"overwrite"`
+}
+
+type MakeDirectoryRequest struct {
+	Path string `json:"path"`
+}
+
+type MoveFileRequest struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+type RenameFileRequest struct {
+	Path      string `json:"path"`
+	Name      string `json:"name"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+type FileContentQuery struct {
+	Path   string `form:"path"`
+	Offset int64  `form:"offset"`
+	Length int64  `form:"length"`
+}
+
+// FileContent is a text file, or the part of it from Offset, as the editor
+// shows it. Complete is set when Content is the whole file.
+type FileContent struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	ETag     string    `json:"etag"`
+	Encoding string    `json:"encoding,omitempty"`
+	Binary   bool      `json:"binary"`
+	Offset   int64     `json:"offset"`
+	Length   int64     `json:"length"`
+	Complete bool      `json:"complete"`
+	Content  string    `json:"content"`
+}
+
+type SaveFileRequest struct {
+	Path     string     `json:"path"`
+	Content  string     `json:"content"`
+	Encoding string     `json:"encoding"`
+	ETag     string     `json:"etag"`
+	ModTime  *time.Time `json:"modTime"`
+	Create   bool       `json:"create"`
+}
+
+type FileSaved struct {
+	Path     string      `json:"path"`
+	Size     int64       `json:"size"`
+	ModTime  time.Time   `json:"modTime"`
+	ETag     string      `json:"etag"`
+	Encoding string      `json:"encoding"`
+	Backup   *FileBackup `json:"backup,omitempty"`
+}
+
+// FileBackup is a version of a file replaced through the editor. Content
+// is only kept in the store.
+type FileBackup struct {
+	ID      string    `json:"id"`
+	SiteID  int       `json:"siteId"`
+	Site    string    `json:"site"`
+	Path    string    `json:"path"`
+	At      time.Time `json:"at"`
+	Size    int64     `json:"size"`
+	ETag    string    `json:"etag"`
+	Content []byte    `json:"content,omitempty"`
+}
+
 func (w Website) String() string {
 	return fmt.Sprintf(`{
   "name": "%s",
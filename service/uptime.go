@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// ValidateUptimeQuery fills in the default range, the last 30 days, and
+// checks the rest of the query.
+func ValidateUptimeQuery(query *UptimeQuery) error {
+	if query.To.IsZero() {
+		query.To = time.Now().UTC()
+	}
+	if query.From.IsZero() {
+		query.From = query.To.AddDate(0, 0, -30)
+	}
+	query.From, query.To = query.From.UTC(), query.To.UTC()
+	if !query.From.Before(query.To) {
+		return fmt.Errorf("from must be before to")
+	}
+	if !slices.Contains([]string{"", "json", "csv"}, query.Format) {
+		return fmt.Errorf("format must be json or csv")
+	}
+	if !slices.Contains([]string{"", "monthly", "incidents"}, query.Report) {
+		return fmt.Errorf("report must be monthly or incidents")
+	}
+	if query.Target < 0 || query.Target > 100 {
+		return fmt.Errorf("target must be a percentage")
+	}
+	return nil
+}
+
+// uptimeStatus reports whether a result counts as up, and whether it counts
+// at all. A stopped site is unavailable like a failing one; planned
+// maintenance and a site without bindings to probe count neither way.
+func uptimeStatus(status string) (bool, bool) {
+	switch status {
+	case healthHealthy, healthDegraded:
+		return true, true
+	case healthUnhealthy, healthStopped:
+		return false, true
+	}
+	return false, false
+}
+
+func percent(up time.Duration, down time.Duration) *float64 {
+	if up+down == 0 {
+		return nil
+	}
+	p := math.Round(float64(up)/float64(up+down)*100*1000) / 1000
+	return &p
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func earlier(a time.Time, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func later(a time.Time, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+type uptimeTotals struct {
+	up, down  time.Duration
+	incidents int
+}
+
+// ComputeUptime turns health history, oldest first, into an uptime report
+// for [from, to). Each result stands for the time until the next one, but
+// for no longer than maxGap; time no result covers is reported as no data
+// and counts neither way. Back-to-back failing results make one incident.
+func ComputeUptime(results []HealthResult, from time.Time, to time.Time, maxGap time.Duration, target float64) UptimeReport {
+	report := UptimeReport{From: from, To: to, Target: target, Incidents: []UptimeIncident{}, Months: []MonthlyUptime{}}
+	months := map[time.Time]*uptimeTotals{}
+	total := uptimeTotals{}
+	var open *UptimeIncident
+	closeIncident := func() {
+		if open != nil {
+			open.DurationSeconds = int64(open.End.Sub(open.Start).Seconds())
+			report.Incidents = append(report.Incidents, *open)
+			months[monthStart(open.Start)].incidents++
+			open = nil
+		}
+	}
+	for month := monthStart(from); month.Before(to); month = month.AddDate(0, 1, 0) {
+		months[month] = &uptimeTotals{}
+	}
+
+	for i, result := range results {
+		start, end := result.CheckedAt.UTC(), result.CheckedAt.UTC().Add(maxGap)
+		if i+1 < len(results) && results[i+1].CheckedAt.Before(end) {
+			end = results[i+1].CheckedAt.UTC()
+		}
+		start, end = later(start, from), earlier(end, to)
+		if !end.After(start) {
+			continue
+		}
+		up, measured := uptimeStatus(result.Status)
+		if !measured {
+			closeIncident()
+			continue
+		}
+		for chunkStart := start; chunkStart.Before(end); {
+			chunkEnd := earlier(end, monthStart(chunkStart).AddDate(0, 1, 0))
+			if up {
+				months[monthStart(chunkStart)].up += chunkEnd.Sub(chunkStart)
+			} else {
+				months[monthStart(chunkStart)].down += chunkEnd.Sub(chunkStart)
+			}
+			chunkStart = chunkEnd
+		}
+		if up {
+			total.up += end.Sub(start)
+			closeIncident()
+			continue
+		}
+		total.down += end.Sub(start)
+		if open != nil && !open.End.Equal(start) {
+			closeIncident()
+		}
+		if open == nil {
+			open = &UptimeIncident{Start: start, Reason: result.Message}
+		}
+		open.End = end
+	}
+	if open != nil {
+		open.Ongoing = open.End.Equal(to)
+		closeIncident()
+	}
+
+	report.UpSeconds = int64(total.up.Seconds())
+	report.DownSeconds = int64(total.down.Seconds())
+	report.NoDataSeconds = int64((to.Sub(from) - total.up - total.down).Seconds())
+	report.AvailabilityPercent = percent(total.up, total.down)
+	resolved, repair := 0, int64(0)
+	for _, incident := range report.Incidents {
+		if !incident.Ongoing {
+			resolved++
+			repair += incident.DurationSeconds
+		}
+	}
+	if resolved > 0 {
+		mttr := repair / int64(resolved)
+		report.MTTRSeconds = &mttr
+	}
+	for month := monthStart(from); month.Before(to); month = month.AddDate(0, 1, 0) {
+		totals := months[month]
+		span := earlier(month.AddDate(0, 1, 0), to).Sub(later(month, from))
+		monthly := MonthlyUptime{
+			Month:               month.Format("2006-01"),
+			AvailabilityPercent: percent(totals.up, totals.down),
+			UpSeconds:           int64(totals.up.Seconds()),
+			DownSeconds:         int64(totals.down.Seconds()),
+			NoDataSeconds:       int64((span - totals.up - totals.down).Seconds()),
+			Incidents:           totals.incidents,
+		}
+		if target > 0 && monthly.AvailabilityPercent != nil {
+			met := *monthly.AvailabilityPercent >= target
+			monthly.SLAMet = &met
+		}
+		report.Months = append(report.Months, monthly)
+	}
+	return report
+}
+
+// UptimeAction builds a site's uptime report from its health history, so
+// it reaches back no further than HistoryDays.
+func UptimeAction(website Website, query UptimeQuery) (UptimeReport, error) {
+	settings, err := GetHealthSettingsAction()
+	if err != nil {
+		return UptimeReport{}, err
+	}
+	// A missed check or two is not a gap; the scheduler only looks once a minute
+	maxGap := time.Duration(max(settings.IntervalMinutes, 1)*2+1) * time.Minute
+	results, err := GetHealthHistoryAction(website, query.From.Add(-maxGap), query.To, 0)
+	if err != nil {
+		return UptimeReport{}, err
+	}
+	report := ComputeUptime(results, query.From, query.To, maxGap, query.Target)
+	report.Site = website.Name
+	return report, nil
+}
+
+func formatPercent(p *float64) string {
+	if p == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*p, 'f', 3, 64)
+}
+
+// WriteUptimeCSV writes one table of the report: a row per month, or with
+// report "incidents" a row per incident.
+func WriteUptimeCSV(w io.Writer, report UptimeReport, kind string) error {
+	out := csv.NewWriter(w)
+	if kind == "incidents" {
+		out.Write([]string{"site", "start", "end", "duration_seconds", "ongoing", "reason"})
+		for _, incident := range report.Incidents {
+			out.Write([]string{
+				report.Site,
+				incident.Start.Format(time.RFC3339),
+				incident.End.Format(time.RFC3339),
+				strconv.FormatInt(incident.DurationSeconds, 10),
+				strconv.FormatBool(incident.Ongoing),
+				incident.Reason,
+			})
+		}
+	} else {
+		out.Write([]string{"site", "month", "availability_percent", "up_seconds", "down_seconds", "no_data_seconds", "incidents", "sla_met"})
+		for _, month := range report.Months {
+			met := ""
+			if month.SLAMet != nil {
+				met = strconv.FormatBool(*month.SLAMet)
+			}
+			out.Write([]string{
+				report.Site,
+				month.Month,
+				formatPercent(month.AvailabilityPercent),
+				strconv.FormatInt(month.UpSeconds, 10),
+				strconv.FormatInt(month.DownSeconds, 10),
+				strconv.FormatInt(month.NoDataSeconds, 10),
+				strconv.Itoa(month.Incidents),
+				met,
+			})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	webhookBucket         = "webhooks"
+	webhookDeliveryBucket = "webhook-deliveries"
+	webhookReplayBucket   = "webhook-replays"
+	webhookGitHub         = "github"
+	webhookGeneric        = "generic"
+
+	webhookRejected = "rejected"
+	webhookIgnored  = "ignored"
+	webhookAccepted = "accepted"
+
+	// webhookTolerance is how far a generic delivery's timestamp may be
+	// from the server's clock
+	webhookTolerance = 5 * time.Minute
+	// webhookRetention is how long deliveries are kept, and so how long a
+	// signed GitHub body cannot be delivered again
+	webhookRetention = 30 * 24 * time.Hour
+	webhookMaxBody   = 1 << 20
+)
+
+var (
+	errWebhookDisabled     = errors.New("webhook is not enabled for this website")
+	errWebhookUnauthorized = errors.New("webhook signature rejected")
+	errWebhookReplay       = errors.New("delivery was already received")
+	errWebhookPayload      = errors.New("invalid webhook payload")
+	errWebhookNoSecret     = errors.New("an enabled webhook needs a secret")
+	errArtifactAddress     = errors.New("artifact address is not public")
+
+	sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+	// webhookMu makes checking a replay key and recording it one step, so
+	// the same delivery sent twice at once is only accepted once
+	webhookMu sync.Mutex
+
+	// artifactAddressAllowed decides which addresses artifact downloads
+	// may connect to; tests replace it to reach local servers
+	artifactAddressAllowed = func(addr netip.AddrPort) bool { return publicAddress(addr.Addr()) }
+	// nonPublicPrefixes are ranges netip does not flag as private but that
+	// are not reachable from the internet either
+	nonPublicPrefixes = []netip.Prefix{
+		netip.MustParsePrefix("100.64.0.0/10"),
+		netip.MustParsePrefix("192.0.0.0/24"),
+		netip.MustParsePrefix("198.18.0.0/15"),
+	}
+)
+
+// webhookJob is the deployment a delivery asks for: an artifact to
+// download, or a ref of the linked repository.
+type webhookJob struct {
+	artifactURL string
+	sha256      string
+	ref         string
+	keepConfig  bool
+}
+
+// webhookReplay marks a replay key as used by the delivery that carried it.
+type webhookReplay struct {
+	Delivery   string    `json:"delivery"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+func normalizeWebhookConfig(config WebhookConfig) WebhookConfig {
+	if config.Format == "" {
+		config.Format = webhookGeneric
+	}
+	return config
+}
+
+func ValidateWebhookConfig(config WebhookConfig) error {
+	config = normalizeWebhookConfig(config)
+	if config.Format != webhookGitHub && config.Format != webhookGeneric {
+		return fmt.Errorf("format must be github or generic")
+	}
+	if config.Secret != nil && *config.Secret != "" && len(*config.Secret) < 16 {
+		return fmt.Errorf("secret must be at least 16 characters")
+	}
+	return nil
+}
+
+// GetWebhookConfigAction returns a site's webhook, secret included; see
+// Public for what may be shown.
+func GetWebhookConfigAction(website Website) (WebhookConfig, bool, error) {
+	config := WebhookConfig{}
+	found, err := storeGet(webhookBucket, strconv.Itoa(website.ID), &config)
+	config.SiteID = website.ID
+	config.Site = website.Name
+	return normalizeWebhookConfig(config), found, err
+}
+
+// SetWebhookConfigAction saves a site's webhook. A secret left out keeps
+// the current one; an empty one removes it. An enabled webhook needs one.
+func SetWebhookConfigAction(website Website, config WebhookConfig) (WebhookConfig, error) {
+	config = normalizeWebhookConfig(config)
+	if err := ValidateWebhookConfig(config); err != nil {
+		return config, err
+	}
+	current, _, err := GetWebhookConfigAction(website)
+	if err != nil {
+		return config, err
+	}
+	if config.Secret == nil {
+		config.Secret = current.Secret
+	}
+	if config.Secret != nil && *config.Secret == "" {
+		config.Secret = nil
+	}
+	if config.Enabled && config.Secret == nil {
+		return config.Public(), errWebhookNoSecret
+	}
+	config.SiteID = website.ID
+	config.Site = website.Name
+	return config.Public(), storePut(webhookBucket, strconv.Itoa(website.ID), config)
+}
+
+func DeleteWebhookConfigAction(website Website) error {
+	return storeDelete(webhookBucket, strconv.Itoa(website.ID))
+}
+
+// Public leaves out the secret, saying only whether there is one.
+func (c WebhookConfig) Public() WebhookConfig {
+	c.HasSecret = c.Secret != nil
+	c.Secret = nil
+	return c
+}
+
+// validSignature checks a "sha256=<hex>" header against the HMAC-SHA256 of
+// message, in constant time.
+func validSignature(secret string, message []byte, header string) bool {
+	signature, ok := strings.CutPrefix(strings.TrimSpace(header), "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(message)
+	return hmac.Equal(mac.Sum(nil), got)
+}
+
+// verifiedDelivery is what a valid signature vouches for. The sender's
+// delivery ID is only shown; replays are recognised by key, derived from
+// the signature, since headers outside the signed message can be changed
+// by anyone replaying a captured request.
+type verifiedDelivery struct {
+	id    string
+	event string
+	key   string
+}
+
+// verifyWebhook checks a delivery's signature. GitHub signs the body alone,
+// so the same body can never be delivered twice. Generic senders sign
+// "<timestamp>.<id>.<body>", so a captured delivery goes stale after
+// webhookTolerance and its ID cannot be swapped; without an X-Webhook-Id
+// the id part is empty.
+func verifyWebhook(config WebhookConfig, header http.Header, body []byte, now time.Time) (verifiedDelivery, error) {
+	if config.Format == webhookGitHub {
+		signature := header.Get("X-Hub-Signature-256")
+		if !validSignature(*config.Secret, body, signature) {
+			return verifiedDelivery{}, fmt.Errorf("%w: X-Hub-Signature-256 does not match", errWebhookUnauthorized)
+		}
+		id := header.Get("X-GitHub-Delivery")
+		if id == "" {
+			return verifiedDelivery{}, fmt.Errorf("%w: X-GitHub-Delivery is missing", errWebhookUnauthorized)
+		}
+		return verifiedDelivery{id: id, event: header.Get("X-GitHub-Event"), key: replayKey(signature)}, nil
+	}
+
+	timestamp := header.Get("X-Webhook-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return verifiedDelivery{}, fmt.Errorf("%w: X-Webhook-Timestamp must be unix seconds", errWebhookUnauthorized)
+	}
+	id := header.Get("X-Webhook-Id")
+	signature := header.Get("X-Webhook-Signature")
+	message := append([]byte(timestamp+"."+id+"."), body...)
+	if !validSignature(*config.Secret, message, signature) {
+		return verifiedDelivery{}, fmt.Errorf("%w: X-Webhook-Signature does not match", errWebhookUnauthorized)
+	}
+	if skew := now.Sub(time.Unix(seconds, 0)); skew > webhookTolerance || skew < -webhookTolerance {
+		return verifiedDelivery{}, fmt.Errorf("%w: timestamp is more than %s off", errWebhookUnauthorized, webhookTolerance)
+	}
+	key := replayKey(signature)
+	if id == "" {
+		id = key[:16]
+	}
+	return verifiedDelivery{id: id, event: "deploy", key: key}, nil
+}
+
+// replayKey identifies a verified signature. Only valid signatures get
+// here, so the header differs exactly when the signed message does.
+func replayKey(signature string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimPrefix(strings.TrimSpace(signature), "sha256="))))
+	return hex.EncodeToString(sum[:])
+}
+
+// planWebhook reads what a verified delivery asks for. It returns no job
+// and a reason for deliveries that call for nothing, such as GitHub pings
+// and pushes to other branches.
+func planWebhook(website Website, format string, event string, body []byte) (*webhookJob, string, error) {
+	if format == webhookGitHub {
+		if event != "push" {
+			return nil, event + " event", nil
+		}
+		push := GitHubPushEvent{}
+		if err := json.Unmarshal(body, &push); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", errWebhookPayload, err)
+		}
+		source, found, err := GetGitSourceAction(website)
+		if err != nil {
+			return nil, "", err
+		}
+		if !found {
+			return nil, "", errGitNotLinked
+		}
+		if push.Ref != "refs/heads/"+source.Branch {
+			return nil, fmt.Sprintf("push to %s, website deploys %s", push.Ref, source.Branch), nil
+		}
+		if push.Deleted {
+			return nil, "branch deleted", nil
+		}
+		if !gitCommitPattern.MatchString(push.After) {
+			return nil, "", fmt.Errorf("%w: after must be a commit", errWebhookPayload)
+		}
+		return &webhookJob{ref: push.After, keepConfig: true}, "", nil
+	}
+
+	payload := WebhookPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errWebhookPayload, err)
+	}
+	job := &webhookJob{artifactURL: payload.ArtifactURL, sha256: strings.ToLower(payload.SHA256), ref: payload.Ref, keepConfig: true}
+	if payload.KeepConfig != nil {
+		job.keepConfig = *payload.KeepConfig
+	}
+	switch {
+	case job.artifactURL != "":
+		u, err := url.Parse(job.artifactURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, "", fmt.Errorf("%w: artifactUrl must be an http or https url", errWebhookPayload)
+		}
+		if job.sha256 != "" && !sha256Pattern.MatchString(job.sha256) {
+			return nil, "", fmt.Errorf("%w: sha256 must be 64 hex characters", errWebhookPayload)
+		}
+	case job.ref != "":
+		if err := ValidateGitRef(job.ref); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", errWebhookPayload, err)
+		}
+		_, found, err := GetGitSourceAction(website)
+		if err != nil {
+			return nil, "", err
+		}
+		if !found {
+			return nil, "", errGitNotLinked
+		}
+	default:
+		return nil, "", fmt.Errorf("%w: artifactUrl or ref is required", errWebhookPayload)
+	}
+	return job, "", nil
+}
+
+// recordWebhookDelivery saves a verified delivery. Deliveries that were let
+// through also claim their replay key.
+func recordWebhookDelivery(delivery WebhookDelivery) error {
+	prefix := siteKeyPrefix(delivery.SiteID)
+	if err := storePut(webhookDeliveryBucket, prefix+delivery.ID, delivery); err != nil {
+		return err
+	}
+	if delivery.ReplayKey == "" || delivery.Status == webhookRejected {
+		return nil
+	}
+	return storePut(webhookReplayBucket, prefix+delivery.ReplayKey, webhookReplay{Delivery: delivery.ID, ReceivedAt: delivery.ReceivedAt})
+}
+
+// webhookDeliverySeen reports whether a delivery with this replay key was
+// let through within webhookRetention.
+func webhookDeliverySeen(website Website, key string, now time.Time) (bool, error) {
+	replay := webhookReplay{}
+	found, err := storeGet(webhookReplayBucket, siteKeyPrefix(website.ID)+key, &replay)
+	if err != nil {
+		return false, err
+	}
+	return found && now.Sub(replay.ReceivedAt) < webhookRetention, nil
+}
+
+// pruneWebhookDeliveries drops deliveries older than webhookRetention, and
+// the replay keys they still hold.
+func pruneWebhookDeliveries(website Website, now time.Time) error {
+	prefix := siteKeyPrefix(website.ID)
+	cutoff := now.Add(-webhookRetention).Format(storeTimeFormat)
+	expired, err := storeListRange[WebhookDelivery](webhookDeliveryBucket, prefix, "", cutoff)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range expired {
+		if delivery.ReplayKey == "" {
+			continue
+		}
+		replay := webhookReplay{}
+		found, err := storeGet(webhookReplayBucket, prefix+delivery.ReplayKey, &replay)
+		if err != nil {
+			return err
+		}
+		if found && replay.Delivery == delivery.ID {
+			if err := storeDelete(webhookReplayBucket, prefix+delivery.ReplayKey); err != nil {
+				return err
+			}
+		}
+	}
+	return storeDeleteRange(webhookDeliveryBucket, prefix, "", cutoff)
+}
+
+// ReceiveWebhookAction verifies a delivery, records it, and starts the
+// deployment it asks for. The deployment runs in the background, as
+// senders do not wait for one; its outcome is written to the delivery.
+// Deliveries that fail verification or replay an earlier one are not
+// recorded, so unauthenticated requests never write to the store.
+func ReceiveWebhookAction(website Website, header http.Header, body []byte, remoteAddr string) (WebhookDelivery, error) {
+	config, found, err := GetWebhookConfigAction(website)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	if !found || !config.Enabled || config.Secret == nil {
+		return WebhookDelivery{}, errWebhookDisabled
+	}
+	now := time.Now().UTC()
+	delivery := WebhookDelivery{
+		ID:         now.Format(storeTimeFormat),
+		SiteID:     website.ID,
+		Site:       website.Name,
+		ReceivedAt: now,
+		Format:     config.Format,
+		RemoteAddr: remoteAddr,
+	}
+	reject := func(err error) (WebhookDelivery, error) {
+		delivery.Status, delivery.Error = webhookRejected, err.Error()
+		return delivery, err
+	}
+
+	verified, err := verifyWebhook(config, header, body, now)
+	if err != nil {
+		return reject(err)
+	}
+	delivery.DeliveryID, delivery.Event, delivery.ReplayKey = verified.id, verified.event, verified.key
+	webhookMu.Lock()
+	defer webhookMu.Unlock()
+	seen, err := webhookDeliverySeen(website, delivery.ReplayKey, now)
+	if err != nil {
+		return delivery, err
+	}
+	if seen {
+		return reject(errWebhookReplay)
+	}
+	if err := pruneWebhookDeliveries(website, now); err != nil {
+		return delivery, err
+	}
+	job, reason, err := planWebhook(website, config.Format, delivery.Event, body)
+	if err != nil {
+		// Signed by the sender, so worth showing them why
+		delivery.Status, delivery.Error = webhookRejected, err.Error()
+		return delivery, errors.Join(err, recordWebhookDelivery(delivery))
+	}
+	if job == nil {
+		delivery.Status, delivery.Detail = webhookIgnored, reason
+		return delivery, recordWebhookDelivery(delivery)
+	}
+	delivery.Status = webhookAccepted
+	if job.artifactURL != "" {
+		delivery.Detail = "artifact " + redactURL(job.artifactURL)
+	} else {
+		delivery.Detail = "ref " + job.ref
+	}
+	if err := recordWebhookDelivery(delivery); err != nil {
+		return delivery, err
+	}
+	go runWebhookJob(website, delivery, *job)
+	return delivery, nil
+}
+
+func runWebhookJob(website Website, delivery WebhookDelivery, job webhookJob) {
+	// Verified deliveries get the snapshot other mutating requests get
+	// before they reach a handler
+	if _, err := TakeSnapshotAction(triggerMutation, "webhook delivery "+delivery.DeliveryID); err != nil {
+		log.Printf("snapshot before webhook delivery %s failed: %v", delivery.DeliveryID, err)
+	}
+	var deployment Deployment
+	var err error
+	if job.artifactURL != "" {
+		deployment, err = DeployArtifactAction(website, job.artifactURL, job.sha256, job.keepConfig)
+	} else {
+		deployment, err = GitDeployAction(website, job.ref, job.keepConfig)
+	}
+	delivery.DeploymentID, delivery.Status = deployment.ID, deployedStatus
+	if err != nil {
+		delivery.Status, delivery.Error = deployFailed, err.Error()
+	}
+	if err := recordWebhookDelivery(delivery); err != nil {
+		log.Printf("failed to record webhook delivery %s: %v", delivery.DeliveryID, err)
+	}
+}
+
+// redactURL leaves out the query, where pre-signed artifact URLs carry
+// their credentials.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	u.RawQuery, u.Fragment, u.User = "", "", nil
+	return u.String()
+}
+
+// publicAddress reports whether ip is reachable from the internet. Artifact
+// downloads are refused anything else, so a delivery cannot point the
+// server at itself, its network or a cloud metadata endpoint.
+func publicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// artifactClient downloads artifacts. Addresses are checked as each
+// connection is made, so names that resolve to a private address and
+// redirects to one are refused as well. Proxies are not used, since the
+// check would only see the proxy.
+func artifactClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil || !artifactAddressAllowed(addr) {
+				return fmt.Errorf("%w: %s", errArtifactAddress, address)
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: 30 * time.Second,
+		ForceAttemptHTTP2:   true,
+	}
+	return &http.Client{Timeout: gitTimeout, Transport: transport}
+}
+
+// DeployArtifactAction downloads a build artifact and deploys it like an
+// uploaded archive, after checking it against checksum when one is given.
+func DeployArtifactAction(website Website, artifactURL string, checksum string, keepConfig bool) (Deployment, error) {
+	settings, err := GetDeploySettingsAction()
+	if err != nil {
+		return Deployment{}, err
+	}
+	deployment := newDeployment(website)
+	deployment.ArchiveName = path.Base(redactURL(artifactURL))
+
+	archive, err := os.CreateTemp("", "artifact-*")
+	if err != nil {
+		return deployment, err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	size := int64(0)
+	err = deployment.step("download", func() (string, error) {
+		response, err := artifactClient().Get(artifactURL)
+		if err != nil {
+			return "", fmt.Errorf("failed to download %s: %v", redactURL(artifactURL), errors.Unwrap(err))
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("failed to download %s: %s", redactURL(artifactURL), response.Status)
+		}
+		limit := int64(settings.MaxUploadMB) << 20
+		hash := sha256.New()
+		size, err = io.Copy(io.MultiWriter(archive, hash), io.LimitReader(response.Body, limit+1))
+		if err != nil {
+			return "", err
+		}
+		if size > limit {
+			return "", fmt.Errorf("%w: artifact is larger than %d MB", errDeployTooLarge, settings.MaxUploadMB)
+		}
+		if sum := hex.EncodeToString(hash.Sum(nil)); checksum != "" && sum != checksum {
+			return "", fmt.Errorf("%w: sha256 is %s, expected %s", errInvalidArchive, sum, checksum)
+		}
+		_, err = archive.Seek(0, io.SeekStart)
+		return fmt.Sprintf("%d bytes", size), err
+	})
+	if err != nil {
+		return deployment, deployment.fail(err)
+	}
+	return deployArchive(website, deployment, archive, size, keepConfig)
+}
+
+// GetWebhookDeliveriesAction lists a site's deliveries newest first.
+func GetWebhookDeliveriesAction(website Website, limit int) ([]WebhookDelivery, error) {
+	deliveries, err := storeListRange[WebhookDelivery](webhookDeliveryBucket, siteKeyPrefix(website.ID), "", "")
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool { return deliveries[i].ReceivedAt.After(deliveries[j].ReceivedAt) })
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "0123456789abcdef0123"
+
+func sign(secret string, message string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidSignature(t *testing.T) {
+	message := `{"ref":"v1"}`
+	valid := sign(testWebhookSecret, message)
+	tests := []struct {
+		name    string
+		secret  string
+		message string
+		header  string
+		ok      bool
+	}{
+		{"valid", testWebhookSecret, message, valid, true},
+		{"surrounding space", testWebhookSecret, message, " " + valid + " ", true},
+		{"tampered body", testWebhookSecret, `{"ref":"v2"}`, valid, false},
+		{"wrong secret", "another-secret-0000", message, valid, false},
+		{"missing prefix", testWebhookSecret, message, strings.TrimPrefix(valid, "sha256="), false},
+		{"sha1 prefix", testWebhookSecret, message, "sha1=" + strings.TrimPrefix(valid, "sha256="), false},
+		{"not hex", testWebhookSecret, message, "sha256=zz", false},
+		{"truncated", testWebhookSecret, message, valid[:len(valid)-2], false},
+		{"empty", testWebhookSecret, message, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := validSignature(test.secret, []byte(test.message), test.header); got != test.ok {
+				t.Fatalf("expected %v, got %v", test.ok, got)
+			}
+		})
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	secret := testWebhookSecret
+	now := time.Unix(1_800_000_000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	stale := strconv.FormatInt(now.Add(-webhookTolerance-time.Second).Unix(), 10)
+	body := `{"ref":"v1"}`
+	headers := func(pairs ...string) http.Header {
+		header := http.Header{}
+		for i := 0; i < len(pairs); i += 2 {
+			header.Set(pairs[i], pairs[i+1])
+		}
+		return header
+	}
+	github := WebhookConfig{Format: webhookGitHub, Secret: &secret}
+	generic := WebhookConfig{Format: webhookGeneric, Secret: &secret}
+	tests := []struct {
+		name   string
+		config WebhookConfig
+		header http.Header
+		body   string
+		id     string
+		event  string
+		err    error
+	}{
+		{
+			"github", github,
+			headers("X-Hub-Signature-256", sign(secret, body), "X-GitHub-Delivery", "d-1", "X-GitHub-Event", "push"),
+			body, "d-1", "push", nil,
+		},
+		{
+			"github tampered body", github,
+			headers("X-Hub-Signature-256", sign(secret, body), "X-GitHub-Delivery", "d-1", "X-GitHub-Event", "push"),
+			`{"ref":"v2"}`, "", "", errWebhookUnauthorized,
+		},
+		{
+			"github without delivery", github,
+			headers("X-Hub-Signature-256", sign(secret, body), "X-GitHub-Event", "push"),
+			body, "", "", errWebhookUnauthorized,
+		},
+		{
+			"generic", generic,
+			headers("X-Webhook-Timestamp", ts, "X-Webhook-Id", "build-1", "X-Webhook-Signature", sign(secret, ts+".build-1."+body)),
+			body, "build-1", "deploy", nil,
+		},
+		{
+			"generic without id", generic,
+			headers("X-Webhook-Timestamp", ts, "X-Webhook-Signature", sign(secret, ts+".."+body)),
+			body, "", "deploy", nil,
+		},
+		{
+			"generic tampered body", generic,
+			headers("X-Webhook-Timestamp", ts, "X-Webhook-Id", "build-1", "X-Webhook-Signature", sign(secret, ts+".build-1."+body)),
+			`{"ref":"v2"}`, "", "", errWebhookUnauthorized,
+		},
+		{
+			"generic id swapped", generic,
+			headers("X-Webhook-Timestamp", ts, "X-Webhook-Id", "build-2", "X-Webhook-Signature", sign(secret, ts+".build-1."+body)),
+			body, "", "", errWebhookUnauthorized,
+		},
+		{
+			"generic timestamp swapped", generic,
+			headers("X-Webhook-Timestamp", strconv.FormatInt(now.Unix()+1, 10), "X-Webhook-Id", "build-1", "X-Webhook-Signature", sign(secret, ts+".build-1."+body)),
+			body, "", "", errWebhookUnauthorized,
+		},
+		{
+			"generic old format", generic,
+			headers("X-Webhook-Timestamp", ts, "X-Webhook-Signature", sign(secret, ts+"."+body)),
+			body, "", "", errWebhookUnauthorized,
+		},
+		{
+			"generic stale timestamp", generic,
+			headers("X-Webhook-Timestamp", stale, "X-Webhook-Id", "build-1", "X-Webhook-Signature", sign(secret, stale+".build-1."+body)),
+			body, "", "", errWebhookUnauthorized,
+		},
+		{
+			"generic bad timestamp", generic,
+			headers("X-Webhook-Timestamp", "yesterday", "X-Webhook-Signature", sign(secret, "yesterday.."+body)),
+			body, "", "", errWebhookUnauthorized,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verified, err := verifyWebhook(test.config, test.header, []byte(test.body), now)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if test.id != "" && verified.id != test.id || verified.event != test.event {
+				t.Fatalf("expected %s %s, got %+v", test.id, test.event, verified)
+			}
+			if verified.id == "" || len(verified.key) != 64 {
+				t.Fatalf("expected an id and a replay key, got %+v", verified)
+			}
+		})
+	}
+
+	// The replay key follows the signed message, not the unsigned headers
+	first, _ := verifyWebhook(github, headers("X-Hub-Signature-256", sign(secret, body), "X-GitHub-Delivery", "d-1"), []byte(body), now)
+	renamed, _ := verifyWebhook(github, headers("X-Hub-Signature-256", sign(secret, body), "X-GitHub-Delivery", "d-2"), []byte(body), now)
+	if first.key != renamed.key {
+		t.Fatal("a new X-GitHub-Delivery changed the replay key")
+	}
+	other, _ := verifyWebhook(github, headers("X-Hub-Signature-256", sign(secret, "{}"), "X-GitHub-Delivery", "d-1"), []byte("{}"), now)
+	if other.key == first.key {
+		t.Fatal("different bodies share a replay key")
+	}
+}
+
+func TestReceiveWebhookReplay(t *testing.T) {
+	t.Setenv("SERVICE_DATA_DIR", t.TempDir())
+	if err := OpenStore(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	site := Website{ID: 4, Name: "hooked"}
+	secret := testWebhookSecret
+	if _, err := ReceiveWebhookAction(site, http.Header{}, nil, ""); !errors.Is(err, errWebhookDisabled) {
+		t.Fatalf("expected a disabled webhook, got %v", err)
+	}
+	if _, err := SetWebhookConfigAction(site, WebhookConfig{Enabled: true, Format: webhookGitHub, Secret: &secret}); err != nil {
+		t.Fatal(err)
+	}
+	ping := func(id string, body string, signature string) (WebhookDelivery, error) {
+		header := http.Header{}
+		header.Set("X-Hub-Signature-256", signature)
+		header.Set("X-GitHub-Delivery", id)
+		header.Set("X-GitHub-Event", "ping")
+		return ReceiveWebhookAction(site, header, []byte(body), "192.0.2.1")
+	}
+
+	// A forged delivery is rejected and does not use up the body
+	if delivery, err := ping("d-1", `{"zen":"a"}`, sign("another-secret-0000", `{"zen":"a"}`)); !errors.Is(err, errWebhookUnauthorized) || delivery.Status != webhookRejected {
+		t.Fatalf("expected a rejected delivery, got %+v %v", delivery, err)
+	}
+	tests := []struct {
+		name string
+		id   string
+		body string
+		err  error
+	}{
+		{"first delivery", "d-1", `{"zen":"a"}`, nil},
+		{"replayed", "d-1", `{"zen":"a"}`, errWebhookReplay},
+		{"replayed under a new id", "d-9", `{"zen":"a"}`, errWebhookReplay},
+		{"new body under an old id", "d-1", `{"zen":"b"}`, nil},
+	}
+	for _, test := range tests {
+		delivery, err := ping(test.id, test.body, sign(secret, test.body))
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+		if err == nil && (delivery.Status != webhookIgnored || delivery.DeliveryID != test.id) {
+			t.Fatalf("%s: expected an ignored ping, got %+v", test.name, delivery)
+		}
+	}
+	// Forged and replayed deliveries are not written to the store
+	deliveries, err := GetWebhookDeliveriesAction(site, 0)
+	if err != nil || len(deliveries) != 2 {
+		t.Fatalf("expected the two accepted deliveries recorded, got %d %v", len(deliveries), err)
+	}
+
+	// Once a delivery is pruned, its replay key goes with it
+	if err := pruneWebhookDeliveries(site, time.Now().Add(webhookRetention+time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if delivery, err := ping("d-1", `{"zen":"a"}`, sign(secret, `{"zen":"a"}`)); err != nil {
+		t.Fatalf("expected an expired replay key to be free again, got %+v %v", delivery, err)
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"fd00:ec2::254", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"224.0.0.1", false},
+	}
+	for _, test := range tests {
+		if got := publicAddress(netip.MustParseAddr(test.ip)); got != test.public {
+			t.Errorf("%s: expected public=%v, got %v", test.ip, test.public, got)
+		}
+	}
+}
+
+func TestArtifactClient(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer internal.Close()
+	if _, err := artifactClient().Get(internal.URL); !errors.Is(err, errArtifactAddress) {
+		t.Fatalf("expected a loopback artifact to be refused, got %v", err)
+	}
+
+	// A server let through cannot redirect to one that is not
+	redirect := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusFound))
+	defer redirect.Close()
+	allowed := netip.MustParseAddrPort(strings.TrimPrefix(redirect.URL, "http://"))
+	restore := artifactAddressAllowed
+	artifactAddressAllowed = func(addr netip.AddrPort) bool { return addr == allowed }
+	defer func() { artifactAddressAllowed = restore }()
+	if response, err := artifactClient().Get(redirect.URL + "/redirect"); err == nil {
+		response.Body.Close()
+		t.Fatal("expected the redirect target to be refused")
+	} else if !errors.Is(err, errArtifactAddress) {
+		t.Fatalf("expected the redirect target to be refused, got %v", err)
+	}
+}